@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"time"
+)
+
+const TrustEventYearPolicy = "event"
+const TrustRowYearPolicy = "row"
+const QuarantineYearPolicy = "quarantine"
+const DefaultYearMismatchPolicy = TrustEventYearPolicy
+
+// YearCheckCounts counts how the academic year derived from DATEZAN compared with the meta event year.
+type YearCheckCounts struct {
+	Matched      int
+	TrustedRow   int
+	TrustedEvent int
+	Quarantined  int
+}
+
+func (counts *YearCheckCounts) add(other YearCheckCounts) {
+	counts.Matched += other.Matched
+	counts.TrustedRow += other.TrustedRow
+	counts.TrustedEvent += other.TrustedEvent
+	counts.Quarantined += other.Quarantined
+}
+
+func (counts YearCheckCounts) mismatches() int {
+	return counts.TrustedRow + counts.TrustedEvent + counts.Quarantined
+}
+
+// academicYear returns the year the academic year of the date begins in: September starts a new one.
+func academicYear(date time.Time) int {
+	if date.Month() >= time.September {
+		return date.Year()
+	}
+	return date.Year() - 1
+}
+
+// lessonSemester keeps a valid HALF and otherwise derives the semester from the date:
+// September to January is the first one, February to August the second.
+func lessonSemester(half uint8, date time.Time) uint8 {
+	if half == 1 || half == 2 {
+		return half
+	}
+	if date.Month() >= time.September || date.Month() == time.January {
+		return 1
+	}
+	return 2
+}
+
+// checkAcademicYear sets the year and semester of the lesson according to the year mismatch policy.
+// It returns false for a quarantined lesson, which must not be published.
+func (importer *LessonsImporter) checkAcademicYear(event *ExtendedLessonEvent, eventYear int, counts *YearCheckCounts) bool {
+	event.Year = eventYear
+	if event.Date.IsZero() {
+		counts.Matched++
+		return true
+	}
+
+	event.Semester = lessonSemester(event.Semester, event.Date)
+	rowYear := academicYear(event.Date)
+	if rowYear == eventYear {
+		counts.Matched++
+		return true
+	}
+
+	switch importer.yearMismatchPolicy {
+	case TrustRowYearPolicy:
+		event.Year = rowYear
+		counts.TrustedRow++
+	case QuarantineYearPolicy:
+		counts.Quarantined++
+		fmt.Fprintf(
+			importer.out, "\n Quarantine lesson %d: DATEZAN %s belongs to %d academic year, event year %d \n",
+			event.Id, event.Date.Format(dateFormat), rowYear, eventYear,
+		)
+		return false
+	default:
+		counts.TrustedEvent++
+	}
+
+	return true
+}
+
+// addPublished records published lessons under the academic year of their DATEZAN,
+// the same year the reconciliation later lists the secondary DB lessons for.
+func (importer *LessonsImporter) addPublished(year int, lessons []events.LessonEvent) (err error) {
+	byYear := make(map[int][]events.LessonEvent)
+	for _, lesson := range lessons {
+		lessonYear := year
+		if !lesson.Date.IsZero() {
+			lessonYear = academicYear(lesson.Date)
+		}
+		byYear[lessonYear] = append(byYear[lessonYear], lesson)
+	}
+
+	for lessonYear, yearLessons := range byYear {
+		if err = importer.publishedStore.add(lessonYear, yearLessons); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAcademicYear(t *testing.T) {
+	assert.Equal(t, 2022, academicYear(time.Date(2023, 8, 31, 23, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 2023, academicYear(time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 2023, academicYear(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestLessonSemester(t *testing.T) {
+	assert.Equal(t, uint8(2), lessonSemester(2, time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, uint8(1), lessonSemester(0, time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, uint8(1), lessonSemester(0, time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, uint8(2), lessonSemester(7, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestYearMismatchPolicy(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	startDatetime := time.Date(2023, 9, 2, 4, 0, 0, 0, time.UTC)
+	endDatetime := time.Date(2023, 9, 2, 4, 0, 0, 0, time.UTC)
+
+	execute := func(t *testing.T, policy string, expectedWrites int) (ImportSummary, []events.LessonEvent) {
+		db, dbMock, _ := sqlmock.New()
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WillReturnRows(
+			sqlmock.NewRows(expectedColumns).
+				AddRow(10, 100, time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC), 1, 1, false).
+				AddRow(11, 100, time.Date(2023, 8, 31, 0, 0, 0, 0, time.UTC), 1, 0, false),
+		)
+
+		writer := mocks.NewWriterInterface(t)
+		if expectedWrites != 0 {
+			writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Times(expectedWrites)
+		}
+
+		store := newTestPublishedLessonsStore(t)
+		importer := LessonsImporter{
+			out:                &out,
+			db:                 db,
+			dialect:            FirebirdDialect,
+			writer:             writer,
+			writeThreshold:     1,
+			publishedStore:     store,
+			location:           time.UTC,
+			yearMismatchPolicy: policy,
+		}
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, 2023)
+		assert.NoError(t, err)
+
+		published2023, _ := store.list(2023)
+		published2022, _ := store.list(2022)
+		var published []events.LessonEvent
+		for _, lesson := range published2023 {
+			published = append(published, lesson)
+		}
+		for _, lesson := range published2022 {
+			published = append(published, lesson)
+		}
+		assert.Len(t, published2022, expectedWrites-1, "lessons are recorded under DATEZAN academic year")
+
+		return summary, published
+	}
+
+	t.Run("trust event", func(t *testing.T) {
+		summary, published := execute(t, TrustEventYearPolicy, 2)
+
+		assert.Equal(t, YearCheckCounts{Matched: 1, TrustedEvent: 1}, summary.YearChecks)
+		for _, lesson := range published {
+			assert.Equal(t, 2023, lesson.Year)
+		}
+	})
+
+	t.Run("trust row", func(t *testing.T) {
+		summary, published := execute(t, TrustRowYearPolicy, 2)
+
+		assert.Equal(t, YearCheckCounts{Matched: 1, TrustedRow: 1}, summary.YearChecks)
+		for _, lesson := range published {
+			if lesson.Id == 11 {
+				assert.Equal(t, 2022, lesson.Year)
+				assert.Equal(t, uint8(2), lesson.Semester)
+			} else {
+				assert.Equal(t, 2023, lesson.Year)
+			}
+		}
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		summary, published := execute(t, QuarantineYearPolicy, 1)
+
+		assert.Equal(t, YearCheckCounts{Matched: 1, Quarantined: 1}, summary.YearChecks)
+		assert.Len(t, published, 1)
+		assert.Equal(t, uint(10), published[0].Id)
+		assert.Contains(t, out.String(), "Quarantine lesson 11: DATEZAN 2023-08-31 00:00:00 belongs to 2022 academic year, event year 2023")
+		assert.Contains(t, out.String(), "Academic year mismatches: 0 trusted row, 0 trusted event, 1 quarantined")
+	})
+}
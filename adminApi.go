@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdminApi triggers runs through the same EventLoop methods as meta events. Only one run of a year goes at a time:
+// a trigger is refused while the event loop or another trigger is busy with the year. Runs go on under ctx,
+// the shutdown context of the service, not under the request that triggered them, and the service waits for them
+// before it closes the database and the writers.
+type AdminApi struct {
+	ctx           context.Context
+	token         string
+	eventLoop     *EventLoop
+	pause         *PauseControl
+	location      *time.Location
+	consumerStats func() kafka.ReaderStats
+	running       sync.WaitGroup
+}
+
+type AdminImportRequest struct {
+	Year  int
+	Start time.Time
+	End   time.Time
+}
+
+type AdminLessonTypesRequest struct {
+	Year int
+}
+
+// AdminReconcileRequest comes from an operator by default; the reconcile CLI command marks its own requests.
+type AdminReconcileRequest struct {
+	Year    int
+	Trigger string
+}
+
+type AdminPauseRequest struct {
+	Reason string
+}
+
+type AdminRunView struct {
+	RunRecord
+	Running        bool
+	WrittenLessons int64
+	WrittenBatches int64
+}
+
+type AdminRunsResponse struct {
+	Active []AdminRunView
+	Recent []RunRecord
+}
+
+type AdminConsumerResponse struct {
+	Topic     string
+	Partition string
+	Offset    int64
+	Lag       int64
+}
+
+func newAdminServer(addr string, api *AdminApi) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /imports", api.triggerImport)
+	mux.HandleFunc("POST /lesson-types", api.triggerLessonTypes)
+	mux.HandleFunc("POST /reconcile", api.triggerReconcile)
+	mux.HandleFunc("GET /runs", api.listRuns)
+	mux.HandleFunc("GET /runs/{id}", api.showRun)
+	mux.HandleFunc("GET /consumer", api.showConsumer)
+	mux.HandleFunc("GET /pause", api.showPause)
+	mux.HandleFunc("POST /pause", api.pauseConsumption)
+	mux.HandleFunc("POST /resume", api.resumeConsumption)
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: api.authenticate(mux),
+	}
+}
+
+func (api *AdminApi) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			http.Error(writer, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (api *AdminApi) triggerImport(writer http.ResponseWriter, request *http.Request) {
+	var importRequest AdminImportRequest
+	if err := json.NewDecoder(request.Body).Decode(&importRequest); err != nil {
+		http.Error(writer, "wrong request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if importRequest.Year == 0 {
+		http.Error(writer, "Year is required", http.StatusBadRequest)
+		return
+	}
+	if importRequest.Start.IsZero() || !importRequest.End.After(importRequest.Start) {
+		http.Error(writer, "End must be after Start", http.StatusBadRequest)
+		return
+	}
+
+	event := events.SecondaryDbLoadedEvent{
+		PreviousSecondaryDatabaseDatetime: importRequest.Start.In(api.location),
+		CurrentSecondaryDatabaseDatetime:  importRequest.End.In(api.location),
+		Year:                              importRequest.Year,
+	}
+	run := &RunRecord{
+		Kind:    ImportRunKind,
+		Trigger: AdminRunTrigger,
+		Year:    event.Year,
+		Start:   event.PreviousSecondaryDatabaseDatetime,
+		End:     event.CurrentSecondaryDatabaseDatetime,
+	}
+
+	api.startRun(writer, run, func(ctx context.Context) (int, int, error) {
+		summary, err := api.eventLoop.importWindow(ctx, event)
+		return summary.Lessons, summary.Batches, err
+	})
+}
+
+func (api *AdminApi) triggerLessonTypes(writer http.ResponseWriter, request *http.Request) {
+	var lessonTypesRequest AdminLessonTypesRequest
+	if err := json.NewDecoder(request.Body).Decode(&lessonTypesRequest); err != nil {
+		http.Error(writer, "wrong request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if lessonTypesRequest.Year == 0 {
+		http.Error(writer, "Year is required", http.StatusBadRequest)
+		return
+	}
+
+	run := &RunRecord{Kind: LessonTypesRunKind, Trigger: AdminRunTrigger, Year: lessonTypesRequest.Year}
+	api.startRun(writer, run, func(ctx context.Context) (int, int, error) {
+		count, err := api.eventLoop.refreshLessonTypes(ctx, lessonTypesRequest.Year)
+		return count, 0, err
+	})
+}
+
+func (api *AdminApi) triggerReconcile(writer http.ResponseWriter, request *http.Request) {
+	var reconcileRequest AdminReconcileRequest
+	if err := json.NewDecoder(request.Body).Decode(&reconcileRequest); err != nil {
+		http.Error(writer, "wrong request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reconcileRequest.Year == 0 {
+		http.Error(writer, "Year is required", http.StatusBadRequest)
+		return
+	}
+
+	if reconcileRequest.Trigger == "" {
+		reconcileRequest.Trigger = AdminRunTrigger
+	}
+	if reconcileRequest.Trigger != AdminRunTrigger && reconcileRequest.Trigger != CliRunTrigger {
+		http.Error(writer, "wrong Trigger "+reconcileRequest.Trigger, http.StatusBadRequest)
+		return
+	}
+
+	run := &RunRecord{Kind: ReconcileRunKind, Trigger: reconcileRequest.Trigger, Year: reconcileRequest.Year}
+	api.startRun(writer, run, func(ctx context.Context) (int, int, error) {
+		deletedCount, err := api.eventLoop.importerFor(reconcileRequest.Year).reconcile(ctx, reconcileRequest.Year)
+		return deletedCount, 0, err
+	})
+}
+
+// startRun responds with the started run and leaves the work to a goroutine that holds the run lock until it is done.
+func (api *AdminApi) startRun(writer http.ResponseWriter, run *RunRecord, work func(ctx context.Context) (int, int, error)) {
+	yearMutex := api.eventLoop.yearMutex(run.Year)
+	if !yearMutex.TryLock() {
+		http.Error(writer, "another run of "+strconv.Itoa(run.Year)+" year is in progress", http.StatusConflict)
+		return
+	}
+
+	ctx, span := tracer.Start(api.ctx, "admin "+run.Kind)
+	ctx, run = api.eventLoop.startRun(ctx, run)
+	started := *run
+
+	api.running.Add(1)
+	go func() {
+		defer api.running.Done()
+		defer yearMutex.Unlock()
+
+		rows, batches, err := work(ctx)
+		api.eventLoop.finishRun(run, rows, batches, err)
+		endSpan(span, err)
+	}()
+
+	writeJson(writer, http.StatusAccepted, AdminRunView{RunRecord: started, Running: true})
+}
+
+func (api *AdminApi) wait() {
+	api.running.Wait()
+}
+
+func (api *AdminApi) listRuns(writer http.ResponseWriter, request *http.Request) {
+	filter := RunFilter{Limit: DefaultRunsLimit}
+	query := request.URL.Query()
+
+	var err error
+	if year := query.Get("year"); year != "" {
+		filter.Year, err = strconv.Atoi(year)
+	}
+	if limit := query.Get("limit"); err == nil && limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+	}
+	if date := query.Get("date"); err == nil && date != "" {
+		filter.Date, err = time.ParseInLocation(RunsDateFormat, date, api.location)
+	}
+	if err != nil {
+		http.Error(writer, "wrong filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := AdminRunsResponse{Active: api.activeRunViews(), Recent: []RunRecord{}}
+	if api.eventLoop.runHistory != nil {
+		recent, err := api.eventLoop.runHistory.list(filter)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if recent != nil {
+			response.Recent = recent
+		}
+	}
+	// the history may hold errors saved before a secret was known to the redactor
+	for i := range response.Recent {
+		response.Recent[i].Error = api.eventLoop.redactor.redact(response.Recent[i].Error)
+	}
+
+	writeJson(writer, http.StatusOK, response)
+}
+
+func (api *AdminApi) showRun(writer http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseUint(request.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(writer, "wrong run id", http.StatusBadRequest)
+		return
+	}
+
+	for _, active := range api.activeRunViews() {
+		if active.Id == id {
+			writeJson(writer, http.StatusOK, active)
+			return
+		}
+	}
+
+	if api.eventLoop.runHistory == nil {
+		http.Error(writer, "run history requires STATE_DIR", http.StatusNotFound)
+		return
+	}
+
+	record, err := api.eventLoop.runHistory.get(id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		http.Error(writer, "run "+strconv.FormatUint(id, 10)+" not found", http.StatusNotFound)
+		return
+	}
+
+	record.Error = api.eventLoop.redactor.redact(record.Error)
+	writeJson(writer, http.StatusOK, AdminRunView{RunRecord: *record})
+}
+
+func (api *AdminApi) showConsumer(writer http.ResponseWriter, request *http.Request) {
+	stats := api.consumerStats()
+	writeJson(writer, http.StatusOK, AdminConsumerResponse{
+		Topic:     stats.Topic,
+		Partition: stats.Partition,
+		Offset:    stats.Offset,
+		Lag:       stats.Lag,
+	})
+}
+
+func (api *AdminApi) showPause(writer http.ResponseWriter, request *http.Request) {
+	writeJson(writer, http.StatusOK, api.pause.status())
+}
+
+func (api *AdminApi) pauseConsumption(writer http.ResponseWriter, request *http.Request) {
+	var pauseRequest AdminPauseRequest
+	if err := json.NewDecoder(request.Body).Decode(&pauseRequest); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(writer, "wrong request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api.changePause(writer, api.pause.pause(pauseRequest.Reason, AdminRunTrigger))
+}
+
+func (api *AdminApi) resumeConsumption(writer http.ResponseWriter, request *http.Request) {
+	api.changePause(writer, api.pause.resume(AdminRunTrigger))
+}
+
+func (api *AdminApi) changePause(writer http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(writer, "failed to save pause state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(writer, http.StatusOK, api.pause.status())
+}
+
+func (api *AdminApi) activeRunViews() []AdminRunView {
+	views := []AdminRunView{}
+	api.eventLoop.activeRuns.Range(func(_, value any) bool {
+		active := value.(*ActiveRun)
+		views = append(views, AdminRunView{
+			RunRecord:      active.record,
+			Running:        true,
+			WrittenLessons: active.progress.lessons.Load(),
+			WrittenBatches: active.progress.batches.Load(),
+		})
+
+		return true
+	})
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].StartedAt.Before(views[j].StartedAt)
+	})
+
+	return views
+}
+
+func writeJson(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(value)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "secret"
+
+func adminRequest(server *http.Server, method string, target string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+	recorder := httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestAdminApi(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	lessonTypesList := []events.LessonType{{Id: 30, ShortName: "Лек", LongName: "Лекція"}}
+
+	newTestAdminServer := func(t *testing.T) (*http.Server, *EventLoop, *MockImporterInterface, *MockMetaEventbusInterface) {
+		stateDb, err := openStateDb(t.TempDir())
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = stateDb.Close() })
+
+		importer := NewMockImporterInterface(t)
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		eventLoop := &EventLoop{
+			out:          &bytes.Buffer{},
+			importer:     importer,
+			metaEventbus: metaEventbus,
+			runHistory:   &RunHistoryStore{db: stateDb},
+		}
+
+		pauseControl, err := newPauseControl(io.Discard, &PauseStore{db: stateDb})
+		assert.NoError(t, err)
+		eventLoop.pause = pauseControl
+
+		server := newAdminServer(":0", &AdminApi{
+			ctx:       context.Background(),
+			token:     testAdminToken,
+			eventLoop: eventLoop,
+			pause:     pauseControl,
+			location:  time.UTC,
+			consumerStats: func() kafka.ReaderStats {
+				return kafka.ReaderStats{Topic: events.MetaEventsTopic, Partition: "0", Offset: 120, Lag: 3}
+			},
+		})
+
+		return server, eventLoop, importer, metaEventbus
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		server, _, _, _ := newTestAdminServer(t)
+
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/runs", nil))
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+		request := httptest.NewRequest("GET", "/runs", nil)
+		request.Header.Set("Authorization", "Bearer wrong")
+		recorder = httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("trigger import", func(t *testing.T) {
+		server, eventLoop, importer, metaEventbus := newTestAdminServer(t)
+
+		event := events.SecondaryDbLoadedEvent{
+			PreviousSecondaryDatabaseDatetime: time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC),
+			CurrentSecondaryDatabaseDatetime:  time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC),
+			Year:                              2022,
+		}
+
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
+		importer.On("execute", matchContext, event.PreviousSecondaryDatabaseDatetime, event.CurrentSecondaryDatabaseDatetime, 2022).
+			Return(ImportSummary{Lessons: 42, Batches: 2}, nil)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, 2022).Return(nil)
+
+		recorder := adminRequest(
+			server, "POST", "/imports",
+			`{"Year": 2022, "Start": "2023-03-13T04:00:00Z", "End": "2023-03-14T04:00:00Z"}`,
+		)
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		var started AdminRunView
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &started))
+		assert.Equal(t, uint64(1), started.Id)
+		assert.Equal(t, AdminRunTrigger, started.Trigger)
+		assert.True(t, started.Running)
+
+		eventLoop.yearMutex(2022).Lock()
+		defer eventLoop.yearMutex(2022).Unlock()
+
+		recorder = adminRequest(server, "GET", "/runs/1", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		var finished AdminRunView
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &finished))
+		assert.Equal(t, ImportRunKind, finished.Kind)
+		assert.Equal(t, 42, finished.Rows)
+		assert.Equal(t, 2, finished.Batches)
+		assert.False(t, finished.Running)
+		assert.False(t, finished.FinishedAt.IsZero())
+
+		var runs AdminRunsResponse
+		recorder = adminRequest(server, "GET", "/runs", "")
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &runs))
+		assert.Empty(t, runs.Active)
+		assert.Equal(t, []uint64{1}, runIds(runs.Recent))
+	})
+
+	t.Run("runs stop with the service", func(t *testing.T) {
+		_, eventLoop, importer, _ := newTestAdminServer(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		api := &AdminApi{ctx: ctx, token: testAdminToken, eventLoop: eventLoop, location: time.UTC}
+		server := newAdminServer(":0", api)
+
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() != nil })).
+			Return(nil, context.Canceled)
+		importer.On("endReadTransaction").Return(nil)
+
+		recorder := adminRequest(server, "POST", "/lesson-types", `{"Year": 2023}`)
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		api.wait()
+
+		record, err := eventLoop.runHistory.get(1)
+		assert.NoError(t, err)
+		assert.Equal(t, "context canceled", record.Error)
+	})
+
+	t.Run("wait for runs", func(t *testing.T) {
+		_, eventLoop, importer, metaEventbus := newTestAdminServer(t)
+		api := &AdminApi{ctx: context.Background(), token: testAdminToken, eventLoop: eventLoop, location: time.UTC}
+		server := newAdminServer(":0", api)
+
+		release := make(chan time.Time)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).WaitUntil(release).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, 2023).Return(nil)
+
+		recorder := adminRequest(server, "POST", "/lesson-types", `{"Year": 2023}`)
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		waited := make(chan struct{})
+		go func() {
+			api.wait()
+			close(waited)
+		}()
+
+		select {
+		case <-waited:
+			t.Fatal("wait returned while the run is in progress")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		<-waited
+
+		record, err := eventLoop.runHistory.get(1)
+		assert.NoError(t, err)
+		assert.False(t, record.FinishedAt.IsZero())
+	})
+
+	t.Run("trigger lesson types", func(t *testing.T) {
+		server, eventLoop, importer, metaEventbus := newTestAdminServer(t)
+
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, 2023).Return(errors.New("kafka is down"))
+
+		recorder := adminRequest(server, "POST", "/lesson-types", `{"Year": 2023}`)
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		eventLoop.yearMutex(2023).Lock()
+		defer eventLoop.yearMutex(2023).Unlock()
+
+		record, err := eventLoop.runHistory.get(1)
+		assert.NoError(t, err)
+		assert.Equal(t, LessonTypesRunKind, record.Kind)
+		assert.Equal(t, 1, record.Rows)
+		assert.Equal(t, "kafka is down", record.Error)
+	})
+
+	t.Run("trigger reconcile", func(t *testing.T) {
+		server, eventLoop, importer, _ := newTestAdminServer(t)
+		importer.On("reconcile", matchContext, 2023).Return(2, nil)
+
+		recorder := adminRequest(server, "POST", "/reconcile", `{"Year": 2023}`)
+		assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+		eventLoop.yearMutex(2023).Lock()
+		defer eventLoop.yearMutex(2023).Unlock()
+
+		record, err := eventLoop.runHistory.get(1)
+		assert.NoError(t, err)
+		assert.Equal(t, ReconcileRunKind, record.Kind)
+		assert.Equal(t, AdminRunTrigger, record.Trigger)
+		assert.Equal(t, 2, record.Rows)
+
+		recorder = adminRequest(server, "POST", "/reconcile", `{"Year": 2022, "Trigger": "schedule"}`)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "wrong Trigger schedule\n", recorder.Body.String())
+	})
+
+	t.Run("wrong trigger", func(t *testing.T) {
+		server, _, _, _ := newTestAdminServer(t)
+
+		recorder := adminRequest(server, "POST", "/imports", `{"Start": "2023-03-13T04:00:00Z"}`)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Year is required\n", recorder.Body.String())
+
+		recorder = adminRequest(
+			server, "POST", "/imports",
+			`{"Year": 2022, "Start": "2023-03-14T04:00:00Z", "End": "2023-03-13T04:00:00Z"}`,
+		)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "End must be after Start\n", recorder.Body.String())
+
+		recorder = adminRequest(server, "POST", "/lesson-types", `year`)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("run in progress", func(t *testing.T) {
+		server, eventLoop, _, _ := newTestAdminServer(t)
+
+		eventLoop.yearMutex(2023).Lock()
+		defer eventLoop.yearMutex(2023).Unlock()
+
+		recorder := adminRequest(server, "POST", "/lesson-types", `{"Year": 2023}`)
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "another run of 2023 year is in progress\n", recorder.Body.String())
+	})
+
+	t.Run("list runs", func(t *testing.T) {
+		server, eventLoop, _, _ := newTestAdminServer(t)
+
+		finished := &RunRecord{Kind: ReconcileRunKind, Trigger: ScheduleRunTrigger, Year: 2022, Error: "login SYSDBA:masterkey failed"}
+		assert.NoError(t, eventLoop.runHistory.save(finished))
+		eventLoop.redactor = newRedactor("masterkey")
+
+		ctx, _ := eventLoop.startRun(context.Background(), &RunRecord{Kind: SnapshotRunKind, Trigger: CliRunTrigger, Year: 2023})
+		runProgressFromContext(ctx).add(500)
+		runProgressFromContext(ctx).add(20)
+
+		recorder := adminRequest(server, "GET", "/runs?year=2023", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		var response AdminRunsResponse
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		assert.Len(t, response.Active, 1)
+		assert.Equal(t, uint64(2), response.Active[0].Id)
+		assert.Equal(t, int64(520), response.Active[0].WrittenLessons)
+		assert.Equal(t, int64(2), response.Active[0].WrittenBatches)
+		assert.Equal(t, []uint64{2}, runIds(response.Recent))
+
+		recorder = adminRequest(server, "GET", "/runs/2", "")
+		assert.Contains(t, recorder.Body.String(), `"Running":true`)
+
+		recorder = adminRequest(server, "GET", "/runs", "")
+		assert.Contains(t, recorder.Body.String(), `"Error":"login SYSDBA:*** failed"`)
+		recorder = adminRequest(server, "GET", "/runs/1", "")
+		assert.Contains(t, recorder.Body.String(), `"Error":"login SYSDBA:*** failed"`)
+
+		recorder = adminRequest(server, "GET", "/runs?date=14.03.2023", "")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+		recorder = adminRequest(server, "GET", "/runs/7", "")
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "run 7 not found\n", recorder.Body.String())
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		server, _, _, _ := newTestAdminServer(t)
+
+		recorder := adminRequest(server, "POST", "/pause", `{"Reason": "Dekanat maintenance"}`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		var state PauseState
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &state))
+		assert.True(t, state.Paused)
+		assert.Equal(t, "Dekanat maintenance", state.Reason)
+		assert.Equal(t, AdminRunTrigger, state.Source)
+
+		recorder = adminRequest(server, "GET", "/pause", "")
+		assert.Contains(t, recorder.Body.String(), `"Paused":true`)
+
+		recorder = adminRequest(server, "POST", "/resume", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), `"Paused":false`)
+
+		recorder = adminRequest(server, "POST", "/pause", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		recorder = adminRequest(server, "POST", "/pause", "maintenance")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("consumer position", func(t *testing.T) {
+		server, _, _, _ := newTestAdminServer(t)
+
+		recorder := adminRequest(server, "GET", "/consumer", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(
+			t, `{"Topic": "`+events.MetaEventsTopic+`", "Partition": "0", "Offset": 120, "Lag": 3}`,
+			recorder.Body.String(),
+		)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminPollInterval = time.Second
+
+// AdminClient lets CLI commands go through the running importer, which holds the state DB open.
+type AdminClient struct {
+	baseUrl      string
+	token        string
+	client       *http.Client
+	pollInterval time.Duration
+}
+
+// AdminApiError is a response of the admin API with a non-2xx status.
+type AdminApiError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (err *AdminApiError) Error() string {
+	return "admin API responded with " + err.Status + ": " + err.Message
+}
+
+// newAdminClient reaches the admin API of the instance listening on ADMIN_LISTEN of the same config.
+func newAdminClient(listen string, token string, timeout time.Duration) *AdminClient {
+	host, port, _ := net.SplitHostPort(listen)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	return &AdminClient{
+		baseUrl:      "http://" + net.JoinHostPort(host, port),
+		token:        token,
+		client:       &http.Client{Timeout: timeout},
+		pollInterval: adminPollInterval,
+	}
+}
+
+func (client *AdminClient) reconcile(year int) (run AdminRunView, err error) {
+	err = client.do(http.MethodPost, "/reconcile", AdminReconcileRequest{Year: year, Trigger: CliRunTrigger}, &run)
+	return
+}
+
+func (client *AdminClient) run(id uint64) (run AdminRunView, err error) {
+	err = client.do(http.MethodGet, "/runs/"+strconv.FormatUint(id, 10), nil, &run)
+	return
+}
+
+// list and get make the client a RunHistoryReader for the runs command.
+func (client *AdminClient) list(filter RunFilter) ([]RunRecord, error) {
+	query := url.Values{}
+	if filter.Year != 0 {
+		query.Set("year", strconv.Itoa(filter.Year))
+	}
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	if !filter.Date.IsZero() {
+		query.Set("date", filter.Date.Format(RunsDateFormat))
+	}
+
+	var response AdminRunsResponse
+	err := client.do(http.MethodGet, "/runs?"+query.Encode(), nil, &response)
+
+	return response.Recent, err
+}
+
+func (client *AdminClient) get(id uint64) (*RunRecord, error) {
+	run, err := client.run(id)
+
+	var apiErr *AdminApiError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &run.RunRecord, nil
+}
+
+// wait polls the run until it is finished.
+func (client *AdminClient) wait(run AdminRunView) (AdminRunView, error) {
+	var err error
+	for err == nil && (run.Running || run.FinishedAt.IsZero()) {
+		time.Sleep(client.pollInterval)
+		run, err = client.run(run.Id)
+	}
+
+	return run, err
+}
+
+func (client *AdminClient) do(method string, path string, body any, result any) error {
+	var requestBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(payload)
+	}
+
+	request, err := http.NewRequest(method, client.baseUrl+path, requestBody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+client.token)
+
+	response, err := client.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := io.ReadAll(response.Body)
+		return &AdminApiError{StatusCode: response.StatusCode, Status: response.Status, Message: strings.TrimSpace(string(message))}
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// isAdminUnreachable tells that no importer listens on the admin address, so the CLI may work on its own.
+func isAdminUnreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// reconcileThroughAdmin starts the reconcile in the running importer and waits for its result.
+func reconcileThroughAdmin(out io.Writer, client *AdminClient, year int) error {
+	run, err := client.reconcile(year)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Reconcile of %d year started as run %d in the running importer\n", year, run.Id)
+
+	if run, err = client.wait(run); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Finish reconcile of %d year: %d lessons deleted. Error: %s \n", year, run.Rows, run.Error)
+
+	if run.Error != "" {
+		return errors.New(run.Error)
+	}
+
+	return nil
+}
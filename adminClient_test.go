@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestAdminClient serves the admin API of an importer that holds its state DB open, as the running service does.
+func newTestAdminClient(t *testing.T, importer ImporterInterface) (*AdminClient, *RunHistoryStore) {
+	stateDb, err := openStateDb(t.TempDir())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = stateDb.Close() })
+
+	runHistory := &RunHistoryStore{db: stateDb}
+	eventLoop := &EventLoop{
+		out:        &bytes.Buffer{},
+		importer:   importer,
+		runHistory: runHistory,
+	}
+	server := httptest.NewServer(newAdminServer(":0", &AdminApi{
+		ctx:       context.Background(),
+		token:     testAdminToken,
+		eventLoop: eventLoop,
+		location:  time.UTC,
+	}).Handler)
+	t.Cleanup(server.Close)
+
+	client := &AdminClient{baseUrl: server.URL, token: testAdminToken, client: server.Client(), pollInterval: time.Millisecond}
+
+	return client, runHistory
+}
+
+func TestNewAdminClient(t *testing.T) {
+	assert.Equal(t, "http://127.0.0.1:9200", newAdminClient(":9200", "token", time.Second).baseUrl)
+	assert.Equal(t, "http://10.0.0.5:9200", newAdminClient("10.0.0.5:9200", "token", time.Second).baseUrl)
+}
+
+func TestReconcileThroughAdmin(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	t.Run("deleted lessons", func(t *testing.T) {
+		importer := NewMockImporterInterface(t)
+		importer.On("reconcile", matchContext, 2023).Return(3, nil).
+			Run(func(args mock.Arguments) { time.Sleep(5 * time.Millisecond) })
+
+		var out bytes.Buffer
+		client, runHistory := newTestAdminClient(t, importer)
+		err := reconcileThroughAdmin(&out, client, 2023)
+
+		assert.NoError(t, err)
+		record, err := runHistory.get(1)
+		assert.NoError(t, err)
+		assert.Equal(t, CliRunTrigger, record.Trigger)
+		assert.Contains(t, out.String(), "Reconcile of 2023 year started as run 1 in the running importer")
+		assert.Contains(t, out.String(), "Finish reconcile of 2023 year: 3 lessons deleted. Error:  \n")
+	})
+
+	t.Run("failed run", func(t *testing.T) {
+		importer := NewMockImporterInterface(t)
+		importer.On("reconcile", matchContext, 2023).Return(0, errors.New("refuse to delete all lessons"))
+
+		client, _ := newTestAdminClient(t, importer)
+		err := reconcileThroughAdmin(&bytes.Buffer{}, client, 2023)
+
+		assert.EqualError(t, err, "refuse to delete all lessons")
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		client, _ := newTestAdminClient(t, NewMockImporterInterface(t))
+		client.token = "wrong"
+
+		err := reconcileThroughAdmin(&bytes.Buffer{}, client, 2023)
+
+		assert.EqualError(t, err, "admin API responded with 401 Unauthorized: unauthorized")
+		assert.False(t, isAdminUnreachable(err))
+	})
+
+	t.Run("no running importer", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		client := &AdminClient{baseUrl: server.URL, client: &http.Client{}, pollInterval: time.Millisecond}
+		err := reconcileThroughAdmin(&bytes.Buffer{}, client, 2023)
+
+		assert.True(t, isAdminUnreachable(err))
+	})
+}
+
+func TestRunsThroughAdmin(t *testing.T) {
+	client, runHistory := newTestAdminClient(t, NewMockImporterInterface(t))
+
+	startedAt := time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC)
+	assert.NoError(t, runHistory.save(&RunRecord{
+		Kind: ImportRunKind, Trigger: MetaEventRunTrigger, Year: 2022,
+		Start: startedAt.Add(-time.Hour), End: startedAt, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second), Rows: 12,
+	}))
+	assert.NoError(t, runHistory.save(&RunRecord{Kind: SnapshotRunKind, Trigger: CliRunTrigger, Year: 2023, StartedAt: startedAt}))
+
+	t.Run("list while the importer holds the state DB", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCommand(&out, client, Command{action: RunsListAction, year: 2022}, time.UTC)
+
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "1   import  meta-event  2022  2023-03-14 03:00:00 - 2023-03-14 04:00:00")
+		assert.NotContains(t, out.String(), "snapshot")
+	})
+
+	t.Run("show", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCommand(&out, client, Command{action: RunsShowAction, runId: 2}, time.UTC)
+
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "Kind:     snapshot\n")
+		assert.Contains(t, out.String(), "Duration: unfinished\n")
+	})
+
+	t.Run("show missing run", func(t *testing.T) {
+		err := runCommand(&bytes.Buffer{}, client, Command{action: RunsShowAction, runId: 9}, time.UTC)
+
+		assert.EqualError(t, err, "run 9 not found")
+	})
+}
@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/kneu-messenger-pigeon/events"
 	_ "github.com/nakagami/firebirdsql"
 	"github.com/segmentio/kafka-go"
 	"io"
+	_ "modernc.org/sqlite"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
 const ExitCodeMainError = 1
 const dateFormat = "2006-01-02 15:04:05"
 
-func runApp(out io.Writer) error {
+func runApp(out io.Writer, args []string) (err error) {
+	command, err := parseCommand(args, out)
+	if err != nil {
+		return errors.New("Wrong command: " + err.Error())
+	}
+
 	envFilename := ""
 	if _, err := os.Stat(".env"); err == nil {
 		envFilename = ".env"
@@ -26,59 +37,329 @@ func runApp(out io.Writer) error {
 		return errors.New("Failed to load config: " + err.Error())
 	}
 
+	// DB drivers and Kafka clients may quote the DSN or URLs in their errors
+	redactor := newRedactor(config.secrets()...)
+	out = redactor.writer(out)
+	defer func() { err = redactor.redactError(err) }()
+
+	// parallel year workers, their importers and year locks share the output
+	if config.importWorkers > 1 {
+		out = newLockedWriter(out)
+	}
+
+	if command.name == RunsCommandName {
+		// the running importer holds the state DB, so its history is read through the admin API
+		if config.adminListen != "" {
+			err = runCommand(out, newAdminClient(config.adminListen, config.adminToken, config.kafkaTimeout), command, config.sourceLocation)
+			if !isAdminUnreachable(err) {
+				return err
+			}
+			fmt.Fprintf(out, "No running importer at %s, read the state DB\n", config.adminListen)
+		}
+
+		if config.stateDir == "" {
+			return errors.New("runs command requires STATE_DIR")
+		}
+
+		stateDb, err := openStateDbReadOnly(config.stateDir)
+		if err != nil {
+			return errors.New("Failed to open state DB: " + err.Error())
+		}
+		defer stateDb.Close()
+
+		return runCommand(out, &RunHistoryStore{db: stateDb}, command, config.sourceLocation)
+	}
+
+	// the running importer holds the state DB, so the CLI reconcile goes through its admin API
+	if command.name == ReconcileCommandName && config.adminListen != "" {
+		err = reconcileThroughAdmin(out, newAdminClient(config.adminListen, config.adminToken, config.kafkaTimeout), command.year)
+		if !isAdminUnreachable(err) {
+			return err
+		}
+		fmt.Fprintf(out, "No running importer at %s, reconcile on its own\n", config.adminListen)
+	}
+
+	// meta events are consumed only from Kafka; the output sink alone may run without it
+	consumesKafka := command.name == RunCommandName || command.name == ReplayCommandName
+	if consumesKafka && config.kafkaHost == "" {
+		return errors.New(
+			command.name + " command consumes meta events from Kafka and requires KAFKA_HOST; OUTPUT_SINK " +
+				config.outputSink + " only replaces the writers, the snapshot and reconcile commands run without Kafka",
+		)
+	}
+
+	if command.name == ReplayCommandName {
+		kafkaClient := &kafka.Client{
+			Addr:    kafka.TCP(config.kafkaHost),
+			Timeout: config.kafkaTimeout,
+		}
+
+		return replayCommand(out, kafkaClient, command, config.sourceLocation)
+	}
+
+	shutdownTracing, err := setupTracing(out, config.tracesExporter, redactor)
+	if err != nil {
+		return errors.New("Failed to set up tracing: " + err.Error())
+	}
+	defer shutdownTracing(context.Background())
+
 	db, err := sql.Open(config.dekanatDbDriverName, config.secondaryDekanatDbDSN)
 	if err != nil {
 		return errors.New("Wrong connection configuration for secondary Dekanat DB: " + err.Error())
 	}
 
+	kafkaDialer := &kafka.Dialer{
+		Timeout:   config.kafkaTimeout,
+		DualStack: kafka.DefaultDialer.DualStack,
+	}
+
+	err = waitFor(out, "secondary Dekanat DB", db.Ping, config.startupTimeout, startupInitialDelay)
+	if err == nil && (consumesKafka || config.outputSink == DefaultOutputSink) {
+		err = waitFor(out, "Kafka", kafkaReachable(kafkaDialer, config.kafkaHost), config.startupTimeout, startupInitialDelay)
+	}
+	if err != nil {
+		_ = db.Close()
+		return errors.New("Startup failed: " + err.Error())
+	}
+
+	dialect := dialects[config.dekanatDbDialect]
+	if config.lessonQueriesFile == "" {
+		if err = checkSchema(db, dialect); err != nil {
+			_ = db.Close()
+			return errors.New("Secondary Dekanat DB schema check failed: " + err.Error())
+		}
+	}
+
+	if config.lessonQueriesFile != "" || config.lessonPayloadVersion == ExtendedLessonSchemaVersion {
+		queries := dialect.queries
+		if config.lessonQueriesFile != "" {
+			queries, err = loadQuerySet(config.lessonQueriesFile, queries)
+		}
+		if err == nil && config.lessonPayloadVersion == ExtendedLessonSchemaVersion {
+			queries = withExtendedPayload(queries, config.lessonExtraColumns)
+		}
+		if err == nil {
+			dialect = dialect.withQueries(queries)
+			err = validateQuerySet(db, dialect)
+		}
+		if err != nil {
+			return errors.New("Invalid lesson queries: " + err.Error())
+		}
+	}
+
+	lessonsWriter, err := newSink(config, events.RawLessonsTopic)
+	if err != nil {
+		return errors.New("Failed to create output sink: " + err.Error())
+	}
+
+	rateLimiter := newRateLimiter(config.writeMessagesPerSecond, config.writeBytesPerSecond)
+	if config.spoolDir != "" {
+		lessonsWriter, err = newSpoolWriter(
+			out, lessonsWriter,
+			filepath.Join(config.spoolDir, events.RawLessonsTopic+".spool"), config.spoolMaxBytes, rateLimiter,
+		)
+		if err != nil {
+			return errors.New("Failed to open spool: " + err.Error())
+		}
+	}
+
+	var publishedStore PublishedLessonsStoreInterface
+	var checkpointStore ImportCheckpointStoreInterface
+	var runHistory RunHistoryStoreInterface
+	var pauseStore PauseStoreInterface
+	if config.stateDir != "" {
+		stateDb, err := openStateDb(config.stateDir)
+		if err != nil {
+			return errors.New("Failed to open state DB: " + err.Error())
+		}
+		defer stateDb.Close()
+
+		publishedStore = &PublishedLessonsStore{db: stateDb}
+		checkpointStore = &ImportCheckpointStore{db: stateDb}
+		runHistory = &RunHistoryStore{db: stateDb}
+		pauseStore = &PauseStore{db: stateDb}
+	}
+
+	var schemaRegistry SchemaRegistryInterface
+	if config.schemaRegistryUrl != "" {
+		schemaRegistry = newSchemaRegistry(config.schemaRegistryUrl, config.kafkaTimeout)
+	}
+	codec := newPayloadCodec(config.payloadEncoding, schemaRegistry)
+
+	var stateWriter *LessonsStateWriter
+	if config.lessonsStateTopic != "" {
+		kafkaClient := &kafka.Client{
+			Addr:    kafka.TCP(config.kafkaHost),
+			Timeout: config.kafkaTimeout,
+		}
+		if err = ensureCompactedTopic(kafkaClient, config.lessonsStateTopic); err != nil {
+			_ = lessonsWriter.Close()
+			return errors.New("Failed to prepare lessons state topic: " + err.Error())
+		}
+
+		stateWriter = newLessonsStateWriter(config, codec)
+		defer stateWriter.Close()
+	}
+
+	var yearLock *YearLock
+	if config.yearLock == FileYearLockBackend {
+		yearLock = newYearLock(out, &FileYearLock{dir: config.yearLockDir}, config.yearLockLease, config.yearLockWait)
+	}
+	if config.yearLock == DbYearLockBackend {
+		yearLock = newYearLock(out, &DbYearLock{db: db, dialect: dialect}, config.yearLockLease, config.yearLockWait)
+	}
+
 	importer := &LessonsImporter{
-		out: out,
-		db:  db,
-		writer: &kafka.Writer{
-			Addr:     kafka.TCP(config.kafkaHost),
-			Topic:    events.RawLessonsTopic,
-			Balancer: &kafka.LeastBytes{},
-		},
-		writeThreshold: 500,
+		out:                out,
+		db:                 db,
+		dialect:            dialect,
+		writer:             lessonsWriter,
+		writeThreshold:     500,
+		payloadVersion:     config.lessonPayloadVersion,
+		publishedStore:     publishedStore,
+		stateWriter:        stateWriter,
+		chunkMode:          config.importChunkMode,
+		chunkDays:          config.importChunkDays,
+		chunkSize:          config.importChunkSize,
+		checkpointStore:    checkpointStore,
+		location:           config.sourceLocation,
+		yearMismatchPolicy: config.yearMismatchPolicy,
+		rateLimiter:        rateLimiter,
+		codec:              codec,
+	}
+
+	metaEventsWriter, err := newSink(config, events.MetaEventsTopic)
+	if err != nil {
+		_ = lessonsWriter.Close()
+		return errors.New("Failed to create output sink: " + err.Error())
 	}
 
 	metaEventbus := &MetaEventbus{
-		writer: &kafka.Writer{
-			Addr:     kafka.TCP(config.kafkaHost),
-			Topic:    events.MetaEventsTopic,
-			Balancer: &kafka.LeastBytes{},
-		},
+		writer:      metaEventsWriter,
+		codec:       codec,
+		rateLimiter: rateLimiter,
 	}
 
-	eventLoop := &EventLoop{
-		out:          out,
-		importer:     importer,
-		metaEventbus: metaEventbus,
-		reader: kafka.NewReader(
+	var watchdog *Watchdog
+	var readiness func() error
+	if config.maxQuietPeriod > 0 && command.name == RunCommandName {
+		watchdog = newWatchdog(out, metaEventbus, config.maxQuietPeriod)
+		readiness = watchdog.readiness
+
+		watchdogDone := make(chan struct{})
+		go watchdog.run(watchdogDone)
+		defer close(watchdogDone)
+	}
+
+	if config.metricsListen != "" {
+		metricsServer := newMetricsServer(config.metricsListen, readiness)
+		go func() {
+			_ = metricsServer.ListenAndServe()
+		}()
+		defer metricsServer.Close()
+	}
+
+	var reader *kafka.Reader
+	var pauseControl *PauseControl
+	if command.name == RunCommandName {
+		reader = kafka.NewReader(
 			kafka.ReaderConfig{
 				Brokers:     []string{config.kafkaHost},
-				GroupID:     "secondary-db-lessons-importer",
+				GroupID:     MetaGroupId,
 				Topic:       events.MetaEventsTopic,
 				MinBytes:    10,
 				MaxBytes:    10e3,
 				MaxWait:     time.Second,
 				MaxAttempts: config.kafkaAttempts,
-				Dialer: &kafka.Dialer{
-					Timeout:   config.kafkaTimeout,
-					DualStack: kafka.DefaultDialer.DualStack,
-				},
+				Dialer:      kafkaDialer,
 			},
-		),
+		)
+		defer reader.Close()
+
+		pauseControl, err = newPauseControl(out, pauseStore)
+		if err != nil {
+			return errors.New("Failed to load pause state: " + err.Error())
+		}
+
+		controlReader := kafka.NewReader(
+			kafka.ReaderConfig{
+				Brokers:     []string{config.kafkaHost},
+				GroupID:     controlGroupId(config.instanceId),
+				Topic:       events.MetaEventsTopic,
+				StartOffset: kafka.LastOffset,
+				MinBytes:    10,
+				MaxBytes:    10e3,
+				MaxWait:     time.Second,
+				MaxAttempts: config.kafkaAttempts,
+				Dialer:      kafkaDialer,
+			},
+		)
+		go func() {
+			if err := pauseControl.listen(controlReader); err != nil && !errors.Is(err, io.EOF) {
+				fmt.Fprintf(out, "Control messages listener stopped: %v\n", err)
+			}
+		}()
+		defer controlReader.Close()
+	}
+
+	// runs of the event loop, the admin API and the CLI commands stop with the service
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	eventLoop := &EventLoop{
+		out:               out,
+		importer:          importer,
+		newImporter:       func() ImporterInterface { return importer.clone() },
+		workers:           config.importWorkers,
+		metaEventbus:      metaEventbus,
+		reconcileInterval: config.reconcileInterval,
+		watchdog:          watchdog,
+		runHistory:        runHistory,
+		pause:             pauseControl,
+		yearLock:          yearLock,
+		redactor:          redactor,
+	}
+	if reader != nil {
+		eventLoop.reader = reader
 	}
 
 	defer func() {
-		_ = eventLoop.reader.Close()
 		_ = metaEventbus.writer.Close()
 		_ = importer.writer.Close()
 		_ = db.Close()
 	}()
 
-	return eventLoop.execute()
+	if config.adminListen != "" && command.name == RunCommandName {
+		adminApi := &AdminApi{
+			ctx:           ctx,
+			token:         config.adminToken,
+			eventLoop:     eventLoop,
+			pause:         pauseControl,
+			location:      config.sourceLocation,
+			consumerStats: reader.Stats,
+		}
+		adminServer := newAdminServer(config.adminListen, adminApi)
+		go func() {
+			_ = adminServer.ListenAndServe()
+		}()
+		// admin runs use the database and the writers, so they are stopped and awaited before those are closed
+		defer func() {
+			_ = adminServer.Close()
+			stop()
+			adminApi.wait()
+		}()
+	}
+
+	if command.name == SnapshotCommandName {
+		return eventLoop.runSnapshot(ctx, command.year, CliRunTrigger)
+	}
+
+	if command.name == ReconcileCommandName {
+		_, err = eventLoop.runReconcile(ctx, command.year, CliRunTrigger)
+		return err
+	}
+
+	return eventLoop.execute(ctx)
 }
 
 func handleExitError(errStream io.Writer, err error) int {
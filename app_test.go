@@ -38,12 +38,16 @@ func TestRunApp(t *testing.T) {
 		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", expectedConfig.secondaryDekanatDbDSN)
 		_ = os.Setenv("KAFKA_TIMEOUT", "1")
 		_ = os.Setenv("KAFKA_ATTEMPTS", "1")
+		_ = os.Setenv("STARTUP_TIMEOUT", "1s")
+		defer os.Unsetenv("STARTUP_TIMEOUT")
 
 		var out bytes.Buffer
-		err := runApp(&out)
+		err := runApp(&out, []string{})
 
 		assert.Error(t, err, "Expected for error, got %s")
-		assert.ErrorContains(t, err, "failed to dial: failed to open connection t")
+		assert.ErrorContains(t, err, "Startup failed: secondary Dekanat DB is not reachable after 1s")
+		assert.NotContains(t, err.Error(), "PASSOWORD")
+		assert.NotContains(t, out.String(), "PASSOWORD")
 	})
 
 	t.Run("Run with wrong sql driver", func(t *testing.T) {
@@ -53,7 +57,7 @@ func TestRunApp(t *testing.T) {
 		defer os.Unsetenv("DEKANAT_DB_DRIVER_NAME")
 
 		var out bytes.Buffer
-		err := runApp(&out)
+		err := runApp(&out, []string{})
 
 		expectedError := "Wrong connection configuration for secondary Dekanat DB: sql: unknown driver \"dummy-not-exist\" (forgotten import?)"
 
@@ -61,6 +65,31 @@ func TestRunApp(t *testing.T) {
 		assert.Equalf(t, expectedError, err.Error(), "Expected for another error, got %s", err)
 	})
 
+	t.Run("Run with wrong command", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runApp(&out, []string{"snapshot"})
+
+		assert.EqualError(t, err, "Wrong command: snapshot command requires --year")
+	})
+
+	t.Run("Run with file sink and without Kafka", func(t *testing.T) {
+		_ = os.Setenv("KAFKA_HOST", "")
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", expectedConfig.secondaryDekanatDbDSN)
+		_ = os.Setenv("OUTPUT_SINK", "file")
+		_ = os.Setenv("OUTPUT_SINK_URL", tmpDir)
+		defer os.Unsetenv("OUTPUT_SINK")
+		defer os.Unsetenv("OUTPUT_SINK_URL")
+
+		var out bytes.Buffer
+		err := runApp(&out, []string{})
+
+		assert.EqualError(
+			t, err,
+			"run command consumes meta events from Kafka and requires KAFKA_HOST; OUTPUT_SINK "+
+				"file only replaces the writers, the snapshot and reconcile commands run without Kafka",
+		)
+	})
+
 	t.Run("Run with wrong env file", func(t *testing.T) {
 		_ = os.Setenv("DEKANAT_DB_DRIVER_NAME", "")
 		_ = os.Setenv("KAFKA_HOST", "")
@@ -76,7 +105,7 @@ func TestRunApp(t *testing.T) {
 		}
 
 		var out bytes.Buffer
-		err := runApp(&out)
+		err := runApp(&out, []string{})
 		assert.Error(t, err, "Expected for error")
 		assert.Containsf(
 			t, err.Error(), "Failed to load config",
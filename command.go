@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"time"
+)
+
+const RunCommandName = "run"
+const SnapshotCommandName = "snapshot"
+const ReconcileCommandName = "reconcile"
+const RunsCommandName = "runs"
+const ReplayCommandName = "replay"
+
+const RunsListAction = "list"
+const RunsShowAction = "show"
+
+const RunsDateFormat = "2006-01-02"
+const DefaultRunsLimit = 20
+
+type Command struct {
+	name       string
+	year       int
+	action     string
+	date       string
+	limit      int
+	runId      uint64
+	since      string
+	fromOffset int64
+	partition  int
+	dryRun     bool
+}
+
+func parseCommand(args []string, out io.Writer) (command Command, err error) {
+	command.name = RunCommandName
+	if len(args) != 0 {
+		command.name = args[0]
+		args = args[1:]
+	}
+
+	flagSet := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	flagSet.SetOutput(out)
+
+	switch command.name {
+	case RunCommandName:
+	case SnapshotCommandName:
+		flagSet.IntVar(&command.year, "year", 0, "academic year to snapshot, e.g. 2023 for 2023/2024")
+	case ReconcileCommandName:
+		flagSet.IntVar(&command.year, "year", 0, "academic year to reconcile, e.g. 2023 for 2023/2024")
+	case RunsCommandName:
+		if len(args) == 0 || (args[0] != RunsListAction && args[0] != RunsShowAction) {
+			return Command{}, errors.New("runs command requires list or show")
+		}
+		command.action = args[0]
+		args = args[1:]
+
+		if command.action == RunsListAction {
+			flagSet.IntVar(&command.year, "year", 0, "only runs of this academic year")
+			flagSet.StringVar(&command.date, "date", "", "only runs whose import window covers this day, YYYY-MM-DD")
+			flagSet.IntVar(&command.limit, "limit", DefaultRunsLimit, "maximum number of runs to list")
+		} else {
+			flagSet.Uint64Var(&command.runId, "id", 0, "ID of the run to show")
+		}
+	case ReplayCommandName:
+		flagSet.StringVar(&command.since, "since", "", "replay meta events from this time, YYYY-MM-DD[ HH:MM:SS]")
+		flagSet.Int64Var(&command.fromOffset, "from-offset", -1, "replay meta events from this offset of --partition")
+		flagSet.IntVar(&command.partition, "partition", 0, "partition of --from-offset")
+		flagSet.BoolVar(&command.dryRun, "dry-run", false, "only list the meta events that would be reprocessed")
+	default:
+		return Command{}, errors.New("unknown command " + command.name)
+	}
+
+	if err = flagSet.Parse(args); err != nil {
+		return Command{}, err
+	}
+
+	if (command.name == SnapshotCommandName || command.name == ReconcileCommandName) && command.year == 0 {
+		return Command{}, errors.New(command.name + " command requires --year")
+	}
+
+	if command.date != "" {
+		if _, err = time.Parse(RunsDateFormat, command.date); err != nil {
+			return Command{}, errors.New("wrong --date " + command.date + ", expected YYYY-MM-DD")
+		}
+	}
+
+	if command.name == ReplayCommandName && (command.since == "") == (command.fromOffset < 0) {
+		return Command{}, errors.New("replay command requires either --since or --from-offset")
+	}
+
+	if command.since != "" {
+		if _, err = parseReplaySince(command.since, time.UTC); err != nil {
+			return Command{}, errors.New("wrong --since " + command.since + ", expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
+		}
+	}
+
+	if command.action == RunsShowAction && command.runId == 0 {
+		return Command{}, errors.New("runs show command requires --id")
+	}
+
+	return
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	var out bytes.Buffer
+
+	t.Run("default run", func(t *testing.T) {
+		command, err := parseCommand([]string{}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: RunCommandName}, command)
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		command, err := parseCommand([]string{"snapshot", "--year", "2023"}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: SnapshotCommandName, year: 2023}, command)
+	})
+
+	t.Run("reconcile", func(t *testing.T) {
+		command, err := parseCommand([]string{"reconcile", "-year=2022"}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: ReconcileCommandName, year: 2022}, command)
+	})
+
+	t.Run("snapshot without year", func(t *testing.T) {
+		_, err := parseCommand([]string{"snapshot"}, &out)
+
+		assert.EqualError(t, err, "snapshot command requires --year")
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		_, err := parseCommand([]string{"snapshot", "--semester", "1"}, &out)
+
+		assert.ErrorContains(t, err, "flag provided but not defined: -semester")
+	})
+
+	t.Run("runs list", func(t *testing.T) {
+		command, err := parseCommand([]string{"runs", "list", "--year", "2022", "--date", "2023-03-14"}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: RunsCommandName, action: RunsListAction, year: 2022, date: "2023-03-14", limit: DefaultRunsLimit}, command)
+	})
+
+	t.Run("runs show", func(t *testing.T) {
+		command, err := parseCommand([]string{"runs", "show", "--id", "7"}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: RunsCommandName, action: RunsShowAction, runId: 7}, command)
+	})
+
+	t.Run("runs errors", func(t *testing.T) {
+		_, err := parseCommand([]string{"runs"}, &out)
+		assert.EqualError(t, err, "runs command requires list or show")
+
+		_, err = parseCommand([]string{"runs", "list", "--date", "14.03.2023"}, &out)
+		assert.EqualError(t, err, "wrong --date 14.03.2023, expected YYYY-MM-DD")
+
+		_, err = parseCommand([]string{"runs", "show"}, &out)
+		assert.EqualError(t, err, "runs show command requires --id")
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		command, err := parseCommand([]string{"replay", "--since", "2023-09-01 08:00:00", "--dry-run"}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: ReplayCommandName, since: "2023-09-01 08:00:00", fromOffset: -1, dryRun: true}, command)
+
+		command, err = parseCommand([]string{"replay", "--from-offset", "120", "--partition", "1"}, &out)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Command{name: ReplayCommandName, fromOffset: 120, partition: 1}, command)
+	})
+
+	t.Run("replay errors", func(t *testing.T) {
+		_, err := parseCommand([]string{"replay"}, &out)
+		assert.EqualError(t, err, "replay command requires either --since or --from-offset")
+
+		_, err = parseCommand([]string{"replay", "--since", "2023-09-01", "--from-offset", "3"}, &out)
+		assert.EqualError(t, err, "replay command requires either --since or --from-offset")
+
+		_, err = parseCommand([]string{"replay", "--since", "01.09.2023"}, &out)
+		assert.EqualError(t, err, "wrong --since 01.09.2023, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		_, err := parseCommand([]string{"import"}, &out)
+
+		assert.EqualError(t, err, "unknown command import")
+	})
+}
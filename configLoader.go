@@ -6,15 +6,48 @@ import (
 	"github.com/joho/godotenv"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	dekanatDbDriverName   string
-	kafkaHost             string
-	secondaryDekanatDbDSN string
-	kafkaTimeout          time.Duration
-	kafkaAttempts         int
+	dekanatDbDriverName    string
+	dekanatDbDialect       string
+	lessonQueriesFile      string
+	lessonPayloadVersion   int
+	lessonExtraColumns     []string
+	kafkaHost              string
+	secondaryDekanatDbDSN  string
+	kafkaTimeout           time.Duration
+	kafkaAttempts          int
+	spoolDir               string
+	spoolMaxBytes          int64
+	metricsListen          string
+	outputSink             string
+	outputSinkUrl          string
+	stateDir               string
+	reconcileInterval      time.Duration
+	lessonsStateTopic      string
+	importChunkMode        string
+	importChunkDays        int
+	importChunkSize        int
+	startupTimeout         time.Duration
+	sourceLocation         *time.Location
+	yearMismatchPolicy     string
+	maxQuietPeriod         time.Duration
+	writeMessagesPerSecond int
+	writeBytesPerSecond    int
+	payloadEncoding        string
+	schemaRegistryUrl      string
+	tracesExporter         string
+	adminListen            string
+	adminToken             string
+	yearLock               string
+	yearLockDir            string
+	yearLockLease          time.Duration
+	yearLockWait           time.Duration
+	importWorkers          int
+	instanceId             string
 }
 
 func loadConfig(envFilename string) (Config, error) {
@@ -25,6 +58,11 @@ func loadConfig(envFilename string) (Config, error) {
 		}
 	}
 
+	secrets, err := loadSecretEnvs()
+	if err != nil {
+		return Config{}, err
+	}
+
 	kafkaTimeout, err := strconv.Atoi(os.Getenv("KAFKA_TIMEOUT"))
 	if kafkaTimeout == 0 || err != nil {
 		kafkaTimeout = 10
@@ -35,25 +73,217 @@ func loadConfig(envFilename string) (Config, error) {
 		kafkaAttempts = 0
 	}
 
+	spoolMaxBytes, err := strconv.ParseInt(os.Getenv("SPOOL_MAX_BYTES"), 10, 64)
+	if spoolMaxBytes <= 0 || err != nil {
+		spoolMaxBytes = DefaultSpoolMaxBytes
+	}
+
+	lessonPayloadVersion, err := strconv.Atoi(os.Getenv("LESSON_PAYLOAD_VERSION"))
+	if lessonPayloadVersion == 0 || err != nil {
+		lessonPayloadVersion = LessonSchemaVersion
+	}
+
+	var lessonExtraColumns []string
+	for _, column := range strings.Split(os.Getenv("LESSON_EXTRA_COLUMNS"), ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			lessonExtraColumns = append(lessonExtraColumns, column)
+		}
+	}
+
+	importChunkDays, err := strconv.Atoi(os.Getenv("IMPORT_CHUNK_DAYS"))
+	if importChunkDays <= 0 || err != nil {
+		importChunkDays = DefaultImportChunkDays
+	}
+
+	importChunkSize, err := strconv.Atoi(os.Getenv("IMPORT_CHUNK_SIZE"))
+	if importChunkSize <= 0 || err != nil {
+		importChunkSize = DefaultImportChunkSize
+	}
+
+	writeMessagesPerSecond, err := strconv.Atoi(os.Getenv("KAFKA_WRITE_MESSAGES_PER_SECOND"))
+	if writeMessagesPerSecond < 0 || err != nil {
+		writeMessagesPerSecond = 0
+	}
+
+	writeBytesPerSecond, err := strconv.Atoi(os.Getenv("KAFKA_WRITE_BYTES_PER_SECOND"))
+	if writeBytesPerSecond < 0 || err != nil {
+		writeBytesPerSecond = 0
+	}
+
+	startupTimeout, err := time.ParseDuration(os.Getenv("STARTUP_TIMEOUT"))
+	if startupTimeout <= 0 || err != nil {
+		startupTimeout = DefaultStartupTimeout
+	}
+
+	reconcileInterval, err := time.ParseDuration(os.Getenv("RECONCILE_INTERVAL"))
+	if err != nil {
+		reconcileInterval = 0
+	}
+
+	importWorkers, err := strconv.Atoi(os.Getenv("IMPORT_WORKERS"))
+	if importWorkers <= 0 || err != nil {
+		importWorkers = DefaultImportWorkers
+	}
+
+	yearLockLease, err := time.ParseDuration(os.Getenv("YEAR_LOCK_LEASE"))
+	if yearLockLease <= 0 || err != nil {
+		yearLockLease = DefaultYearLockLease
+	}
+
+	yearLockWait, err := time.ParseDuration(os.Getenv("YEAR_LOCK_WAIT"))
+	if yearLockWait < 0 || err != nil {
+		yearLockWait = 0
+	}
+
+	var maxQuietPeriod time.Duration
+	if value := os.Getenv("MAX_QUIET_PERIOD"); value != "" {
+		maxQuietPeriod, err = time.ParseDuration(value)
+		if maxQuietPeriod <= 0 || err != nil {
+			return Config{}, errors.New("wrong MAX_QUIET_PERIOD " + value + ", expected a positive duration")
+		}
+	}
+
 	config := Config{
-		dekanatDbDriverName:   os.Getenv("DEKANAT_DB_DRIVER_NAME"),
-		secondaryDekanatDbDSN: os.Getenv("SECONDARY_DEKANAT_DB_DSN"),
-		kafkaHost:             os.Getenv("KAFKA_HOST"),
-		kafkaTimeout:          time.Second * time.Duration(kafkaTimeout),
-		kafkaAttempts:         kafkaAttempts,
+		dekanatDbDriverName:    os.Getenv("DEKANAT_DB_DRIVER_NAME"),
+		dekanatDbDialect:       os.Getenv("DEKANAT_DB_DIALECT"),
+		lessonQueriesFile:      os.Getenv("LESSON_QUERIES_FILE"),
+		lessonPayloadVersion:   lessonPayloadVersion,
+		lessonExtraColumns:     lessonExtraColumns,
+		secondaryDekanatDbDSN:  secrets["SECONDARY_DEKANAT_DB_DSN"],
+		kafkaHost:              os.Getenv("KAFKA_HOST"),
+		kafkaTimeout:           time.Second * time.Duration(kafkaTimeout),
+		kafkaAttempts:          kafkaAttempts,
+		spoolDir:               os.Getenv("SPOOL_DIR"),
+		spoolMaxBytes:          spoolMaxBytes,
+		metricsListen:          os.Getenv("METRICS_LISTEN"),
+		outputSink:             os.Getenv("OUTPUT_SINK"),
+		outputSinkUrl:          secrets["OUTPUT_SINK_URL"],
+		stateDir:               os.Getenv("STATE_DIR"),
+		reconcileInterval:      reconcileInterval,
+		lessonsStateTopic:      os.Getenv("LESSONS_STATE_TOPIC"),
+		importChunkMode:        os.Getenv("IMPORT_CHUNK_MODE"),
+		importChunkDays:        importChunkDays,
+		importChunkSize:        importChunkSize,
+		startupTimeout:         startupTimeout,
+		yearMismatchPolicy:     os.Getenv("YEAR_MISMATCH_POLICY"),
+		maxQuietPeriod:         maxQuietPeriod,
+		writeMessagesPerSecond: writeMessagesPerSecond,
+		writeBytesPerSecond:    writeBytesPerSecond,
+		payloadEncoding:        os.Getenv("PAYLOAD_ENCODING"),
+		schemaRegistryUrl:      secrets["SCHEMA_REGISTRY_URL"],
+		tracesExporter:         os.Getenv("TRACES_EXPORTER"),
+		adminListen:            os.Getenv("ADMIN_LISTEN"),
+		adminToken:             secrets["ADMIN_TOKEN"],
+		yearLock:               os.Getenv("YEAR_LOCK"),
+		yearLockDir:            os.Getenv("YEAR_LOCK_DIR"),
+		yearLockLease:          yearLockLease,
+		yearLockWait:           yearLockWait,
+		importWorkers:          importWorkers,
+		instanceId:             os.Getenv("INSTANCE_ID"),
 	}
 
 	if config.dekanatDbDriverName == "" {
 		config.dekanatDbDriverName = "firebirdsql"
 	}
 
+	if config.dekanatDbDialect == "" {
+		config.dekanatDbDialect = dialectNameByDriver(config.dekanatDbDriverName)
+	}
+
+	if _, exists := dialects[config.dekanatDbDialect]; !exists {
+		return Config{}, errors.New("unknown DEKANAT_DB_DIALECT " + config.dekanatDbDialect)
+	}
+
 	if config.secondaryDekanatDbDSN == "" {
 		return Config{}, errors.New("empty SECONDARY_DEKANAT_DB_DSN")
 	}
 
-	if config.kafkaHost == "" {
+	// other sinks still need Kafka for the run command, which runApp checks
+	if config.kafkaHost == "" && (config.outputSink == "" || config.outputSink == DefaultOutputSink) {
 		return Config{}, errors.New("empty KAFKA_HOST")
 	}
 
+	if config.lessonPayloadVersion != LessonSchemaVersion && config.lessonPayloadVersion != ExtendedLessonSchemaVersion {
+		return Config{}, errors.New("unsupported LESSON_PAYLOAD_VERSION " + strconv.Itoa(config.lessonPayloadVersion))
+	}
+
+	if len(config.lessonExtraColumns) != 0 && config.lessonPayloadVersion != ExtendedLessonSchemaVersion {
+		return Config{}, errors.New("LESSON_EXTRA_COLUMNS requires LESSON_PAYLOAD_VERSION=" + strconv.Itoa(ExtendedLessonSchemaVersion))
+	}
+
+	sourceTimeZone := os.Getenv("SOURCE_TIME_ZONE")
+	if sourceTimeZone == "" {
+		sourceTimeZone = DefaultSourceTimeZone
+	}
+
+	if config.sourceLocation, err = time.LoadLocation(sourceTimeZone); err != nil {
+		return Config{}, errors.New("unknown SOURCE_TIME_ZONE " + sourceTimeZone)
+	}
+
+	if config.yearMismatchPolicy == "" {
+		config.yearMismatchPolicy = DefaultYearMismatchPolicy
+	}
+
+	if config.yearMismatchPolicy != TrustEventYearPolicy && config.yearMismatchPolicy != TrustRowYearPolicy &&
+		config.yearMismatchPolicy != QuarantineYearPolicy {
+		return Config{}, errors.New("unknown YEAR_MISMATCH_POLICY " + config.yearMismatchPolicy)
+	}
+
+	if config.payloadEncoding == "" {
+		config.payloadEncoding = DefaultPayloadEncoding
+	}
+
+	if _, exists := payloadEncoders[config.payloadEncoding]; !exists {
+		return Config{}, errors.New("unknown PAYLOAD_ENCODING " + config.payloadEncoding)
+	}
+
+	if config.schemaRegistryUrl != "" && config.payloadEncoding == JsonPayloadEncoding {
+		return Config{}, errors.New("SCHEMA_REGISTRY_URL requires PAYLOAD_ENCODING " + ProtobufPayloadEncoding + " or " + AvroPayloadEncoding)
+	}
+
+	if config.tracesExporter != "" && config.tracesExporter != OtlpTracesExporter && config.tracesExporter != StdoutTracesExporter {
+		return Config{}, errors.New("unknown TRACES_EXPORTER " + config.tracesExporter)
+	}
+
+	if config.adminListen != "" && config.adminToken == "" {
+		return Config{}, errors.New("ADMIN_LISTEN requires ADMIN_TOKEN")
+	}
+
+	if config.yearLock != "" && config.yearLock != FileYearLockBackend && config.yearLock != DbYearLockBackend {
+		return Config{}, errors.New("unknown YEAR_LOCK " + config.yearLock)
+	}
+
+	if config.yearLockDir == "" {
+		config.yearLockDir = config.stateDir
+	}
+
+	if config.yearLock == FileYearLockBackend && config.yearLockDir == "" {
+		return Config{}, errors.New("YEAR_LOCK=file requires YEAR_LOCK_DIR or STATE_DIR")
+	}
+
+	if config.importChunkMode != "" && config.importChunkMode != DayChunkMode && config.importChunkMode != IdChunkMode {
+		return Config{}, errors.New("unknown IMPORT_CHUNK_MODE " + config.importChunkMode)
+	}
+
+	if config.reconcileInterval > 0 && config.stateDir == "" {
+		return Config{}, errors.New("RECONCILE_INTERVAL requires STATE_DIR")
+	}
+
+	if config.outputSink == "" {
+		config.outputSink = DefaultOutputSink
+	}
+
+	if _, exists := sinkRegistry[config.outputSink]; !exists {
+		return Config{}, errors.New("unknown OUTPUT_SINK " + config.outputSink)
+	}
+
+	if config.outputSink != DefaultOutputSink && config.outputSinkUrl == "" {
+		return Config{}, errors.New("empty OUTPUT_SINK_URL for OUTPUT_SINK " + config.outputSink)
+	}
+
+	if config.lessonsStateTopic != "" && config.outputSink != DefaultOutputSink {
+		return Config{}, errors.New("LESSONS_STATE_TOPIC requires OUTPUT_SINK=" + DefaultOutputSink)
+	}
+
 	return config, nil
 }
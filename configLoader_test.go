@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -12,9 +13,33 @@ import (
 var expectedConfig = Config{
 	kafkaHost:             "KAFKA:9999",
 	dekanatDbDriverName:   "firebird-test",
+	dekanatDbDialect:      "firebird",
+	lessonQueriesFile:     "",
+	lessonPayloadVersion:  1,
 	secondaryDekanatDbDSN: "USER:PASSOWORD@HOST/DATABASE",
 	kafkaTimeout:          time.Second * 10,
 	kafkaAttempts:         0,
+	spoolDir:              "",
+	spoolMaxBytes:         DefaultSpoolMaxBytes,
+	metricsListen:         "",
+	outputSink:            "kafka",
+	outputSinkUrl:         "",
+	importChunkDays:       DefaultImportChunkDays,
+	importChunkSize:       DefaultImportChunkSize,
+	startupTimeout:        DefaultStartupTimeout,
+	sourceLocation:        mustLoadLocation(DefaultSourceTimeZone),
+	yearMismatchPolicy:    DefaultYearMismatchPolicy,
+	payloadEncoding:       DefaultPayloadEncoding,
+	yearLockLease:         DefaultYearLockLease,
+	importWorkers:         DefaultImportWorkers,
+}
+
+func mustLoadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return location
 }
 
 func TestLoadConfigFromEnvVars(t *testing.T) {
@@ -99,6 +124,334 @@ func TestLoadConfigFromEnvVars(t *testing.T) {
 
 	})
 
+	t.Run("DialectConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("DEKANAT_DB_DRIVER_NAME", "pgx")
+		defer os.Unsetenv("DEKANAT_DB_DRIVER_NAME")
+		defer os.Unsetenv("DEKANAT_DB_DIALECT")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "postgres", config.dekanatDbDialect)
+
+		_ = os.Setenv("DEKANAT_DB_DIALECT", "sqlite")
+		config, err = loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "sqlite", config.dekanatDbDialect)
+
+		_ = os.Setenv("DEKANAT_DB_DIALECT", "oracle")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown DEKANAT_DB_DIALECT oracle")
+	})
+
+	t.Run("LessonPayloadConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("LESSON_EXTRA_COLUMNS", " NUM_AUD, ,TEACHER ")
+		defer os.Unsetenv("LESSON_PAYLOAD_VERSION")
+		defer os.Unsetenv("LESSON_EXTRA_COLUMNS")
+
+		_, err := loadConfig("")
+		assert.EqualError(t, err, "LESSON_EXTRA_COLUMNS requires LESSON_PAYLOAD_VERSION=2")
+
+		_ = os.Setenv("LESSON_PAYLOAD_VERSION", "2")
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, config.lessonPayloadVersion)
+		assert.Equal(t, []string{"NUM_AUD", "TEACHER"}, config.lessonExtraColumns)
+
+		_ = os.Setenv("LESSON_PAYLOAD_VERSION", "3")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unsupported LESSON_PAYLOAD_VERSION 3")
+	})
+
+	t.Run("ReconcileConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("RECONCILE_INTERVAL", "24h")
+		defer os.Unsetenv("RECONCILE_INTERVAL")
+		defer os.Unsetenv("STATE_DIR")
+
+		_, err := loadConfig("")
+		assert.EqualError(t, err, "RECONCILE_INTERVAL requires STATE_DIR")
+
+		_ = os.Setenv("STATE_DIR", "/var/lib/importer")
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "/var/lib/importer", config.stateDir)
+		assert.Equal(t, time.Hour*24, config.reconcileInterval)
+	})
+
+	t.Run("LessonsStateTopicConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("LESSONS_STATE_TOPIC", "lessons-state")
+		defer os.Unsetenv("LESSONS_STATE_TOPIC")
+		defer os.Unsetenv("OUTPUT_SINK")
+		defer os.Unsetenv("OUTPUT_SINK_URL")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "lessons-state", config.lessonsStateTopic)
+
+		_ = os.Setenv("OUTPUT_SINK", "file")
+		_ = os.Setenv("OUTPUT_SINK_URL", "/tmp")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "LESSONS_STATE_TOPIC requires OUTPUT_SINK=kafka")
+	})
+
+	t.Run("ImportChunkConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("IMPORT_CHUNK_MODE", "id")
+		_ = os.Setenv("IMPORT_CHUNK_SIZE", "1000")
+		defer os.Unsetenv("IMPORT_CHUNK_MODE")
+		defer os.Unsetenv("IMPORT_CHUNK_SIZE")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, IdChunkMode, config.importChunkMode)
+		assert.Equal(t, DefaultImportChunkDays, config.importChunkDays)
+		assert.Equal(t, 1000, config.importChunkSize)
+
+		_ = os.Setenv("IMPORT_CHUNK_MODE", "week")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown IMPORT_CHUNK_MODE week")
+	})
+
+	t.Run("SourceTimeZoneConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("SOURCE_TIME_ZONE", "Europe/Warsaw")
+		defer os.Unsetenv("SOURCE_TIME_ZONE")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "Europe/Warsaw", config.sourceLocation.String())
+
+		_ = os.Setenv("SOURCE_TIME_ZONE", "Mars/Olympus")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown SOURCE_TIME_ZONE Mars/Olympus")
+	})
+
+	t.Run("YearMismatchPolicyConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("YEAR_MISMATCH_POLICY", "quarantine")
+		defer os.Unsetenv("YEAR_MISMATCH_POLICY")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, QuarantineYearPolicy, config.yearMismatchPolicy)
+
+		_ = os.Setenv("YEAR_MISMATCH_POLICY", "guess")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown YEAR_MISMATCH_POLICY guess")
+	})
+
+	t.Run("WriteRateConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("KAFKA_WRITE_MESSAGES_PER_SECOND", "2000")
+		_ = os.Setenv("KAFKA_WRITE_BYTES_PER_SECOND", "-5")
+		defer os.Unsetenv("KAFKA_WRITE_MESSAGES_PER_SECOND")
+		defer os.Unsetenv("KAFKA_WRITE_BYTES_PER_SECOND")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, 2000, config.writeMessagesPerSecond)
+		assert.Equal(t, 0, config.writeBytesPerSecond)
+	})
+
+	t.Run("ImportWorkersConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("IMPORT_WORKERS", "3")
+		defer os.Unsetenv("IMPORT_WORKERS")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, config.importWorkers)
+
+		_ = os.Setenv("IMPORT_WORKERS", "-2")
+		config, err = loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultImportWorkers, config.importWorkers)
+	})
+
+	t.Run("InstanceIdConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("INSTANCE_ID", "importer-1")
+		defer os.Unsetenv("INSTANCE_ID")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "importer-1", config.instanceId)
+	})
+
+	t.Run("YearLockConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("YEAR_LOCK", "file")
+		defer os.Unsetenv("YEAR_LOCK")
+		defer os.Unsetenv("STATE_DIR")
+		defer os.Unsetenv("YEAR_LOCK_WAIT")
+
+		_, err := loadConfig("")
+		assert.EqualError(t, err, "YEAR_LOCK=file requires YEAR_LOCK_DIR or STATE_DIR")
+
+		_ = os.Setenv("STATE_DIR", "/var/lib/importer")
+		_ = os.Setenv("YEAR_LOCK_WAIT", "5m")
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "/var/lib/importer", config.yearLockDir)
+		assert.Equal(t, DefaultYearLockLease, config.yearLockLease)
+		assert.Equal(t, 5*time.Minute, config.yearLockWait)
+
+		_ = os.Setenv("YEAR_LOCK", "redis")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown YEAR_LOCK redis")
+	})
+
+	t.Run("AdminConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("ADMIN_LISTEN", ":9200")
+		defer os.Unsetenv("ADMIN_LISTEN")
+		defer os.Unsetenv("ADMIN_TOKEN")
+
+		_, err := loadConfig("")
+		assert.EqualError(t, err, "ADMIN_LISTEN requires ADMIN_TOKEN")
+
+		_ = os.Setenv("ADMIN_TOKEN", "secret")
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, ":9200", config.adminListen)
+		assert.Equal(t, "secret", config.adminToken)
+	})
+
+	t.Run("KafkaHostConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "")
+		_ = os.Setenv("OUTPUT_SINK", "file")
+		_ = os.Setenv("OUTPUT_SINK_URL", "/tmp")
+		defer os.Unsetenv("OUTPUT_SINK")
+		defer os.Unsetenv("OUTPUT_SINK_URL")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Empty(t, config.kafkaHost)
+
+		_ = os.Setenv("OUTPUT_SINK", "kafka")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "empty KAFKA_HOST")
+	})
+
+	t.Run("SecretFilesConfig", func(t *testing.T) {
+		dsnFilename := filepath.Join(t.TempDir(), "dsn")
+		_ = os.WriteFile(dsnFilename, []byte("SYSDBA:masterkey@db/dekanat\n"), 0600)
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "")
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN_FILE", dsnFilename)
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		defer os.Unsetenv("SECONDARY_DEKANAT_DB_DSN_FILE")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, "SYSDBA:masterkey@db/dekanat", config.secondaryDekanatDbDSN)
+
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "both SECONDARY_DEKANAT_DB_DSN and SECONDARY_DEKANAT_DB_DSN_FILE are set")
+	})
+
+	t.Run("PayloadEncodingConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("SCHEMA_REGISTRY_URL", "file:///tmp/schemas.json")
+		defer os.Unsetenv("PAYLOAD_ENCODING")
+		defer os.Unsetenv("SCHEMA_REGISTRY_URL")
+
+		_, err := loadConfig("")
+		assert.EqualError(t, err, "SCHEMA_REGISTRY_URL requires PAYLOAD_ENCODING protobuf or avro")
+
+		_ = os.Setenv("PAYLOAD_ENCODING", "avro")
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, AvroPayloadEncoding, config.payloadEncoding)
+		assert.Equal(t, "file:///tmp/schemas.json", config.schemaRegistryUrl)
+
+		_ = os.Setenv("PAYLOAD_ENCODING", "xml")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown PAYLOAD_ENCODING xml")
+	})
+
+	t.Run("MaxQuietPeriodConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("MAX_QUIET_PERIOD", "6h")
+		defer os.Unsetenv("MAX_QUIET_PERIOD")
+
+		config, err := loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, 6*time.Hour, config.maxQuietPeriod)
+
+		_ = os.Setenv("MAX_QUIET_PERIOD", "-1h")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "wrong MAX_QUIET_PERIOD -1h, expected a positive duration")
+
+		_ = os.Setenv("MAX_QUIET_PERIOD", "0")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "wrong MAX_QUIET_PERIOD 0, expected a positive duration")
+
+		_ = os.Unsetenv("MAX_QUIET_PERIOD")
+		config, err = loadConfig("")
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), config.maxQuietPeriod)
+	})
+
+	t.Run("SpoolConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("SPOOL_DIR", "/var/spool/importer")
+		_ = os.Setenv("SPOOL_MAX_BYTES", "1024")
+		_ = os.Setenv("METRICS_LISTEN", ":9100")
+		defer os.Unsetenv("SPOOL_DIR")
+		defer os.Unsetenv("SPOOL_MAX_BYTES")
+		defer os.Unsetenv("METRICS_LISTEN")
+
+		config, err := loadConfig("")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "/var/spool/importer", config.spoolDir)
+		assert.Equal(t, int64(1024), config.spoolMaxBytes)
+		assert.Equal(t, ":9100", config.metricsListen)
+	})
+
+	t.Run("OutputSinkConfig", func(t *testing.T) {
+		_ = os.Setenv("SECONDARY_DEKANAT_DB_DSN", "dummy")
+		_ = os.Setenv("KAFKA_HOST", "dummy")
+		_ = os.Setenv("OUTPUT_SINK", "redis")
+		_ = os.Setenv("OUTPUT_SINK_URL", "redis://localhost:6379/0")
+		defer os.Unsetenv("OUTPUT_SINK")
+		defer os.Unsetenv("OUTPUT_SINK_URL")
+
+		config, err := loadConfig("")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "redis", config.outputSink)
+		assert.Equal(t, "redis://localhost:6379/0", config.outputSinkUrl)
+
+		_ = os.Setenv("OUTPUT_SINK_URL", "")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "empty OUTPUT_SINK_URL for OUTPUT_SINK redis")
+
+		_ = os.Setenv("OUTPUT_SINK", "carrier-pigeon")
+		_, err = loadConfig("")
+		assert.EqualError(t, err, "unknown OUTPUT_SINK carrier-pigeon")
+	})
+
 	t.Run("NotExistConfigFile", func(t *testing.T) {
 		os.Setenv("SECONDARY_DEKANAT_DB_DSN", "")
 
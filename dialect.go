@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const LessonQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (case FSTATUS when 0 then 1 else 0 end) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE BETWEEN ? AND ?%FILTERS% ORDER BY ID DESC`
+
+const LessonsRangeQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (case FSTATUS when 0 then 1 else 0 end) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE >= ? AND REGDATE < ?%FILTERS% ORDER BY ID DESC`
+
+const LessonsPageQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (case FSTATUS when 0 then 1 else 0 end) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE BETWEEN ? AND ? AND ID < ?%FILTERS% ORDER BY ID DESC ROWS ?`
+
+const SqliteLessonsPageQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (case FSTATUS when 0 then 1 else 0 end) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE BETWEEN ? AND ? AND ID < ?%FILTERS% ORDER BY ID DESC LIMIT ?`
+
+const SnapshotLessonQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (case FSTATUS when 0 then 1 else 0 end) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE DATEZAN >= ? AND DATEZAN < ?%FILTERS% ORDER BY ID DESC`
+
+const LessonIdsQuery = `SELECT ID FROM T_PRJURN WHERE DATEZAN >= ? AND DATEZAN < ?%FILTERS%`
+
+const LessonTypesQuery = `SELECT ID, SHIRTNAME, LONGNAME FROM T_VARZAN WHERE 1=1%FILTERS%`
+
+const PostgresLessonQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (FSTATUS = 0) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE BETWEEN $1 AND $2%FILTERS% ORDER BY ID DESC`
+
+const PostgresLessonsRangeQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (FSTATUS = 0) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE >= $1 AND REGDATE < $2%FILTERS% ORDER BY ID DESC`
+
+const PostgresLessonsPageQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (FSTATUS = 0) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE REGDATE BETWEEN $1 AND $2 AND ID < $3%FILTERS% ORDER BY ID DESC LIMIT $4`
+
+const PostgresSnapshotLessonQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
+    (FSTATUS = 0) as isDeleted%EXTRA_COLUMNS%
+FROM T_PRJURN WHERE DATEZAN >= $1 AND DATEZAN < $2%FILTERS% ORDER BY ID DESC`
+
+const PostgresLessonIdsQuery = `SELECT ID FROM T_PRJURN WHERE DATEZAN >= $1 AND DATEZAN < $2%FILTERS%`
+
+const FirebirdColumnsCatalogQuery = `SELECT TRIM(rf.RDB$FIELD_NAME),
+    (case f.RDB$FIELD_TYPE when 7 then 'SMALLINT' when 8 then 'INTEGER' when 16 then 'BIGINT'
+        when 12 then 'DATE' when 35 then 'TIMESTAMP' when 14 then 'CHAR' when 37 then 'VARCHAR' else 'OTHER' end)
+FROM RDB$RELATION_FIELDS rf JOIN RDB$FIELDS f ON f.RDB$FIELD_NAME = rf.RDB$FIELD_SOURCE
+WHERE rf.RDB$RELATION_NAME = ?`
+
+const PostgresColumnsCatalogQuery = `SELECT upper(column_name), upper(data_type)
+FROM information_schema.columns WHERE upper(table_name) = $1`
+
+const SqliteColumnsCatalogQuery = `SELECT upper(name), upper(type) FROM pragma_table_info(?)`
+
+const DefaultDialectName = "firebird"
+
+// Dialect holds the query set, the parameter binding and the read transaction setup of one Dekanat DB engine.
+type Dialect struct {
+	name                 string
+	queries              QuerySet
+	bindDatetime         func(datetime time.Time) any
+	readTxOptions        *sql.TxOptions
+	transactionIdQuery   string
+	columnsCatalogQuery  string
+	numberedPlaceholders bool
+}
+
+// FirebirdDialect reads in a concurrency (snapshot) transaction: firebirdsql only starts read-only transactions
+// at read committed, so the read transaction is writable but never runs anything except SELECT.
+var FirebirdDialect = &Dialect{
+	name:                "firebird",
+	queries:             newQuerySet(LessonQuery, LessonsRangeQuery, LessonsPageQuery, SnapshotLessonQuery, LessonIdsQuery, LessonTypesQuery),
+	bindDatetime:        formatDatetime,
+	readTxOptions:       &sql.TxOptions{Isolation: sql.LevelRepeatableRead},
+	transactionIdQuery:  "SELECT CURRENT_TRANSACTION FROM RDB$DATABASE",
+	columnsCatalogQuery: FirebirdColumnsCatalogQuery,
+}
+
+var PostgresDialect = &Dialect{
+	name:                 "postgres",
+	queries:              newQuerySet(PostgresLessonQuery, PostgresLessonsRangeQuery, PostgresLessonsPageQuery, PostgresSnapshotLessonQuery, PostgresLessonIdsQuery, LessonTypesQuery),
+	bindDatetime:         wallClockDatetime,
+	readTxOptions:        &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true},
+	transactionIdQuery:   "SELECT txid_current()",
+	columnsCatalogQuery:  PostgresColumnsCatalogQuery,
+	numberedPlaceholders: true,
+}
+
+var SqliteDialect = &Dialect{
+	name:                "sqlite",
+	queries:             newQuerySet(LessonQuery, LessonsRangeQuery, SqliteLessonsPageQuery, SnapshotLessonQuery, LessonIdsQuery, LessonTypesQuery),
+	bindDatetime:        formatDatetime,
+	readTxOptions:       &sql.TxOptions{ReadOnly: true},
+	columnsCatalogQuery: SqliteColumnsCatalogQuery,
+}
+
+var dialects = map[string]*Dialect{
+	FirebirdDialect.name: FirebirdDialect,
+	PostgresDialect.name: PostgresDialect,
+	SqliteDialect.name:   SqliteDialect,
+}
+
+var driverDialectNames = map[string]string{
+	"firebirdsql": FirebirdDialect.name,
+	"pgx":         PostgresDialect.name,
+	"postgres":    PostgresDialect.name,
+	"sqlite":      SqliteDialect.name,
+	"sqlite3":     SqliteDialect.name,
+}
+
+func newQuerySet(
+	lessonQuery string, lessonsRangeQuery string, lessonsPageQuery string,
+	snapshotQuery string, lessonIdsQuery string, lessonTypesQuery string,
+) QuerySet {
+	return QuerySet{
+		Lessons: QueryDefinition{
+			Query:   lessonQuery,
+			Columns: defaultLessonColumns,
+		},
+		LessonsRange: QueryDefinition{
+			Query:   lessonsRangeQuery,
+			Columns: defaultLessonColumns,
+		},
+		LessonsPage: QueryDefinition{
+			Query:   lessonsPageQuery,
+			Columns: defaultLessonColumns,
+		},
+		Snapshot: QueryDefinition{
+			Query:   snapshotQuery,
+			Columns: defaultLessonColumns,
+		},
+		LessonIds: QueryDefinition{
+			Query:   lessonIdsQuery,
+			Columns: map[string]string{"Id": "ID"},
+		},
+		LessonTypes: QueryDefinition{
+			Query:   lessonTypesQuery,
+			Columns: defaultLessonTypeColumns,
+		},
+	}
+}
+
+func (dialect Dialect) withQueries(queries QuerySet) *Dialect {
+	dialect.queries = queries
+	return &dialect
+}
+
+func dialectNameByDriver(driverName string) string {
+	if name, exists := driverDialectNames[driverName]; exists {
+		return name
+	}
+
+	return DefaultDialectName
+}
+
+func formatDatetime(datetime time.Time) any {
+	return datetime.Format(dateFormat)
+}
+
+func wallClockDatetime(datetime time.Time) any {
+	return time.Date(
+		datetime.Year(), datetime.Month(), datetime.Day(),
+		datetime.Hour(), datetime.Minute(), datetime.Second(), datetime.Nanosecond(), time.UTC,
+	)
+}
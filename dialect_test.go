@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	_ "modernc.org/sqlite"
+	"testing"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE T_PRJURN (
+    ID INTEGER PRIMARY KEY,
+    NUM_PREDM INTEGER,
+    DATEZAN TIMESTAMP,
+    NUM_VARZAN INTEGER,
+    HALF INTEGER,
+    FSTATUS INTEGER,
+    REGDATE TIMESTAMP
+);
+CREATE TABLE T_VARZAN (
+    ID INTEGER PRIMARY KEY,
+    SHIRTNAME TEXT,
+    LONGNAME TEXT
+);
+INSERT INTO T_VARZAN VALUES (1, 'Лек', 'Лекція');
+INSERT INTO T_PRJURN VALUES (10, 100, '2023-03-01 00:00:00', 1, 2, 1, '2023-03-04 10:00:00');
+INSERT INTO T_PRJURN VALUES (11, 100, '2023-03-02 00:00:00', 1, 2, 0, '2023-03-05 03:00:00');
+INSERT INTO T_PRJURN VALUES (12, 100, '2023-03-02 00:00:00', 1, 2, 1, '2023-02-01 03:00:00');
+INSERT INTO T_PRJURN VALUES (13, 100, '2023-03-02 00:00:00', 1, 2, 1, '2023-03-07 03:00:00');
+`
+
+func TestDialectNameByDriver(t *testing.T) {
+	assert.Equal(t, "firebird", dialectNameByDriver("firebirdsql"))
+	assert.Equal(t, "postgres", dialectNameByDriver("pgx"))
+	assert.Equal(t, "sqlite", dialectNameByDriver("sqlite"))
+	assert.Equal(t, DefaultDialectName, dialectNameByDriver("unknown"))
+}
+
+func TestDialectBindDatetime(t *testing.T) {
+	kyiv := time.FixedZone("EET", 2*60*60)
+	datetime := time.Date(2023, 3, 5, 4, 30, 0, 0, kyiv)
+
+	assert.Equal(t, "2023-03-05 04:30:00", FirebirdDialect.bindDatetime(datetime))
+	assert.Equal(t, "2023-03-05 04:30:00", SqliteDialect.bindDatetime(datetime))
+	assert.Equal(t, time.Date(2023, 3, 5, 4, 30, 0, 0, time.UTC), PostgresDialect.bindDatetime(datetime))
+}
+
+func TestSqliteDialect(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	db, err := sql.Open("sqlite", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(sqliteSchema)
+	assert.NoError(t, err)
+
+	t.Run("import lessons", func(t *testing.T) {
+		var actualEvents []events.LessonEvent
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			for _, arg := range args[1:] {
+				var event events.LessonEvent
+				assert.NoError(t, json.Unmarshal(arg.(kafka.Message).Value, &event))
+				actualEvents = append(actualEvents, event)
+			}
+		}).Return(nil).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        SqliteDialect,
+			writer:         writer,
+			writeThreshold: 10,
+		}
+
+		_, err := importer.execute(context.Background(),
+			time.Date(2023, 3, 6, 4, 0, 0, 0, time.UTC),
+			time.Date(2023, 3, 6, 4, 0, 0, 0, time.UTC),
+			2022,
+		)
+
+		assert.NoError(t, err)
+		assert.Len(t, actualEvents, 2)
+		assert.Equal(t, uint(11), actualEvents[0].Id)
+		assert.Equal(t, uint(10), actualEvents[1].Id)
+		assert.True(t, actualEvents[0].IsDeleted)
+		assert.False(t, actualEvents[1].IsDeleted)
+		assert.Equal(t, uint(100), actualEvents[0].DisciplineId)
+		assert.Equal(t, uint8(2), actualEvents[0].Semester)
+		assert.Equal(t, 2022, actualEvents[0].Year)
+		assert.True(t, time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC).Equal(actualEvents[0].Date))
+	})
+
+	t.Run("import lesson types", func(t *testing.T) {
+		importer := LessonsImporter{
+			out:     &out,
+			db:      db,
+			dialect: SqliteDialect,
+		}
+
+		list, err := importer.importLessonTypes(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, []events.LessonType{{Id: 1, ShortName: "Лек", LongName: "Лекція"}}, list)
+	})
+}
@@ -6,65 +6,437 @@ import (
 	"fmt"
 	"github.com/kneu-messenger-pigeon/events"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
-	"os/signal"
-	"syscall"
+	"sync"
+	"time"
 )
 
 type EventLoop struct {
-	out          io.Writer
-	metaEventbus MetaEventbusInterface
-	reader       events.ReaderInterface
-	importer     ImporterInterface
+	out               io.Writer
+	metaEventbus      MetaEventbusInterface
+	reader            events.ReaderInterface
+	importer          ImporterInterface
+	newImporter       func() ImporterInterface
+	workers           int
+	reconcileInterval time.Duration
+	watchdog          *Watchdog
+	runHistory        RunHistoryStoreInterface
+	pause             *PauseControl
+	yearLock          *YearLock
+	redactor          *Redactor
+	yearsMutex        sync.Mutex
+	yearMutexes       map[int]*sync.Mutex
+	yearImporters     map[int]ImporterInterface
+	activeRuns        sync.Map
 }
 
-func (eventLoop EventLoop) execute() (err error) {
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	defer stop()
+// ActiveRun is the run in progress, as started; its progress grows while the run writes batches.
+type ActiveRun struct {
+	record   RunRecord
+	progress *RunProgress
+}
 
-	var event events.SecondaryDbLoadedEvent
-	var m kafka.Message
-	var lessonTypesList []events.LessonType
+// execute fetches meta events and hands them to the year workers: events of one year are processed in order,
+// different years in parallel, and a backlog of one year is coalesced. A failed event stops fetching;
+// offsets are committed only up to it. It stops fetching when ctx is done.
+func (eventLoop *EventLoop) execute(ctx context.Context) (err error) {
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
 
-	for err == nil {
-		m, err = eventLoop.reader.FetchMessage(ctx)
+	committer := &OrderedCommitter{reader: eventLoop.reader}
+	schedule := &reconcileSchedule{}
 
-		if err == nil && string(m.Key) == events.SecondaryDbLoadedEventName {
-			_ = json.Unmarshal(m.Value, &event)
-			fmt.Fprintf(
-				eventLoop.out, "Receive %s %s - %s\n", string(m.Key),
-				event.PreviousSecondaryDatabaseDatetime.Format(dateFormat),
-				event.CurrentSecondaryDatabaseDatetime.Format(dateFormat),
-			)
+	var failure error
+	var failureOnce sync.Once
+	fail := func(err error) {
+		failureOnce.Do(func() {
+			failure = err
+			stopFetching()
+		})
+	}
 
-			lessonTypesList, err = eventLoop.importer.importLessonTypes()
-			if err == nil && len(lessonTypesList) > 0 {
-				err = eventLoop.metaEventbus.sendLessonTypesList(lessonTypesList, event.Year)
+	var workers *YearWorkers
+	workers = newYearWorkers(eventLoop.workers, func(batch []QueuedMessage) error {
+		defer func() {
+			for range batch {
+				workers.release()
 			}
+		}()
 
-			if err == nil {
-				err = eventLoop.importer.execute(
-					event.PreviousSecondaryDatabaseDatetime, event.CurrentSecondaryDatabaseDatetime,
-					event.Year,
-				)
+		processErr := eventLoop.processMessages(ctx, batch, schedule)
+		for _, queued := range batch {
+			if processErr == nil {
+				processErr = committer.finish(queued.pending)
 			}
+		}
+		if processErr != nil {
+			fail(processErr)
+		}
+
+		return processErr
+	})
 
+	for err == nil {
+		var m kafka.Message
+		err = eventLoop.pause.wait(fetchCtx)
+		if err == nil {
+			err = workers.reserve(fetchCtx)
+		}
+		if err == nil {
+			m, err = eventLoop.reader.FetchMessage(fetchCtx)
+		}
+		// a pause requested during the fetch holds the message until resume
+		if err == nil {
+			err = eventLoop.pause.wait(fetchCtx)
+		}
+		if err != nil {
+			break
+		}
+
+		pending := committer.add(m)
+		year, hasWork := messageYear(m)
+		if !hasWork {
+			workers.release()
+			if commitErr := committer.finish(pending); commitErr != nil {
+				fail(commitErr)
+			}
+			continue
+		}
+
+		workers.submit(eventLoop.yearKey(year), QueuedMessage{message: m, pending: pending, year: year})
+	}
+
+	workers.wait()
+	if failure != nil {
+		return failure
+	}
+
+	return
+}
+
+func messageYear(m kafka.Message) (int, bool) {
+	switch string(m.Key) {
+	case events.SecondaryDbLoadedEventName:
+		var event events.SecondaryDbLoadedEvent
+		_ = json.Unmarshal(m.Value, &event)
+		return event.Year, true
+	case LessonsSnapshotRequestedEventName:
+		var snapshotRequest LessonsSnapshotRequestedEvent
+		_ = json.Unmarshal(m.Value, &snapshotRequest)
+		return snapshotRequest.Year, true
+	}
+
+	return 0, false
+}
+
+// processMessages handles one message, or several SecondaryDbLoadedEvents of one year coalesced by the workers:
+// their windows are imported in one run and every event is confirmed with its own processed event.
+func (eventLoop *EventLoop) processMessages(ctx context.Context, batch []QueuedMessage, schedule *reconcileSchedule) (err error) {
+	m := batch[0].message
+	links := make([]trace.Link, 0, len(batch)-1)
+	for _, queued := range batch[1:] {
+		links = append(links, trace.LinkFromContext(extractTraceContext(ctx, &queued.message)))
+	}
+	messageCtx, messageSpan := tracer.Start(
+		extractTraceContext(ctx, &m), "process "+string(m.Key),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("messaging.kafka.message.key", string(m.Key)),
+			attribute.Int("messaging.kafka.destination.partition", m.Partition),
+			attribute.Int64("messaging.kafka.message.offset", m.Offset),
+			attribute.Int("messaging.batch.message_count", len(batch)),
+		),
+	)
+	defer func() { endSpan(messageSpan, err) }()
+
+	if string(m.Key) == events.SecondaryDbLoadedEventName {
+		loadedEvents := make([]events.SecondaryDbLoadedEvent, len(batch))
+		for i, queued := range batch {
+			_ = json.Unmarshal(queued.message.Value, &loadedEvents[i])
 			fmt.Fprintf(
-				eventLoop.out, "Finish processing %s %s - %s. Error: %v \n", string(m.Key),
+				eventLoop.out, "Receive %s %s - %s\n", string(queued.message.Key),
+				loadedEvents[i].PreviousSecondaryDatabaseDatetime.Format(dateFormat),
+				loadedEvents[i].CurrentSecondaryDatabaseDatetime.Format(dateFormat),
+			)
+		}
+		event := coalesceLoadedEvents(loadedEvents)
+		messageSpan.SetAttributes(attribute.Int("year", event.Year))
+		if len(loadedEvents) > 1 {
+			fmt.Fprintf(
+				eventLoop.out, "Coalesce %d %s of %d year into %s - %s\n",
+				len(loadedEvents), events.SecondaryDbLoadedEventName, event.Year,
 				event.PreviousSecondaryDatabaseDatetime.Format(dateFormat),
 				event.CurrentSecondaryDatabaseDatetime.Format(dateFormat),
-				err,
 			)
+		}
 
+		yearMutex := eventLoop.yearMutex(event.Year)
+		yearMutex.Lock()
+		defer yearMutex.Unlock()
+
+		runCtx, run := eventLoop.startRun(messageCtx, &RunRecord{
+			Kind:    ImportRunKind,
+			Trigger: MetaEventRunTrigger,
+			Year:    event.Year,
+			Start:   event.PreviousSecondaryDatabaseDatetime,
+			End:     event.CurrentSecondaryDatabaseDatetime,
+		})
+
+		var summary ImportSummary
+		summary, err = eventLoop.importWindow(runCtx, event)
+		for _, loadedEvent := range loadedEvents {
 			if err == nil {
-				err = eventLoop.metaEventbus.sendSecondaryDbLessonProcessedEventName(event)
+				err = eventLoop.metaEventbus.sendSecondaryDbLessonProcessedEventName(runCtx, loadedEvent)
 			}
 		}
+		eventLoop.finishRun(run, summary.Lessons, summary.Batches, err)
+		if err == nil && eventLoop.watchdog != nil {
+			eventLoop.watchdog.markImported(time.Now())
+		}
+
+		if err == nil && eventLoop.reconcileInterval > 0 && schedule.due(event.Year, eventLoop.reconcileInterval, time.Now()) {
+			if _, reconcileErr := eventLoop.runReconcile(messageCtx, event.Year, ScheduleRunTrigger); reconcileErr != nil {
+				fmt.Fprintf(eventLoop.out, "Scheduled reconcile of %d year failed: %v \n", event.Year, reconcileErr)
+			}
+		}
+	}
+
+	if string(m.Key) == LessonsSnapshotRequestedEventName {
+		var snapshotRequest LessonsSnapshotRequestedEvent
+		_ = json.Unmarshal(m.Value, &snapshotRequest)
+		messageSpan.SetAttributes(attribute.Int("year", snapshotRequest.Year))
+		fmt.Fprintf(eventLoop.out, "Receive %s %d\n", string(m.Key), snapshotRequest.Year)
+
+		yearMutex := eventLoop.yearMutex(snapshotRequest.Year)
+		yearMutex.Lock()
+		defer yearMutex.Unlock()
+
+		err = eventLoop.runSnapshot(messageCtx, snapshotRequest.Year, MetaEventRunTrigger)
+	}
+
+	return
+}
 
+// yearKey groups work that must not run at once. Without newImporter every year shares one importer
+// and so one key.
+func (eventLoop *EventLoop) yearKey(year int) int {
+	if eventLoop.newImporter == nil {
+		return 0
+	}
+
+	return year
+}
+
+// yearMutex serializes the runs of one year between the workers and the admin API.
+func (eventLoop *EventLoop) yearMutex(year int) *sync.Mutex {
+	eventLoop.yearsMutex.Lock()
+	defer eventLoop.yearsMutex.Unlock()
+
+	if eventLoop.yearMutexes == nil {
+		eventLoop.yearMutexes = make(map[int]*sync.Mutex)
+	}
+	key := eventLoop.yearKey(year)
+	if eventLoop.yearMutexes[key] == nil {
+		eventLoop.yearMutexes[key] = &sync.Mutex{}
+	}
+
+	return eventLoop.yearMutexes[key]
+}
+
+// importerFor gives every year its own importer, so parallel years do not share a read transaction.
+func (eventLoop *EventLoop) importerFor(year int) ImporterInterface {
+	if eventLoop.newImporter == nil {
+		return eventLoop.importer
+	}
+
+	eventLoop.yearsMutex.Lock()
+	defer eventLoop.yearsMutex.Unlock()
+
+	if eventLoop.yearImporters == nil {
+		eventLoop.yearImporters = make(map[int]ImporterInterface)
+	}
+	if eventLoop.yearImporters[year] == nil {
+		eventLoop.yearImporters[year] = eventLoop.newImporter()
+	}
+
+	return eventLoop.yearImporters[year]
+}
+
+func (eventLoop *EventLoop) runSnapshot(ctx context.Context, year int, trigger string) (err error) {
+	startedAt := time.Now()
+	ctx, run := eventLoop.startRun(ctx, &RunRecord{Kind: SnapshotRunKind, Trigger: trigger, Year: year})
+	importer := eventLoop.importerFor(year)
+
+	lessonsCount := 0
+	err = eventLoop.withYearLock(ctx, year, func(ctx context.Context) (err error) {
+		err = importer.beginReadTransaction()
+		if err == nil {
+			_, err = eventLoop.sendLessonTypes(ctx, importer, year)
+		}
+
+		if err == nil {
+			err = eventLoop.metaEventbus.sendLessonsSnapshotStartedEvent(ctx, year, startedAt)
+		}
+
+		if err == nil {
+			lessonsCount, err = importer.snapshot(ctx, year)
+		}
+		if endErr := importer.endReadTransaction(); err == nil {
+			err = endErr
+		}
+
+		return
+	})
+
+	if err == nil {
+		err = eventLoop.metaEventbus.sendLessonsSnapshotFinishedEvent(ctx, year, lessonsCount, startedAt)
+	}
+
+	fmt.Fprintf(eventLoop.out, "Finish snapshot of %d year: %d lessons. Error: %v \n", year, lessonsCount, err)
+	eventLoop.finishRun(run, lessonsCount, 0, err)
+
+	return
+}
+
+// withYearLock holds the year lock around the read transaction of a run and everything the run publishes from it.
+// A lost lease fails the run even if the work got through, so the meta event is processed again.
+func (eventLoop *EventLoop) withYearLock(ctx context.Context, year int, work func(ctx context.Context) error) error {
+	lockCtx, release, err := eventLoop.yearLock.acquire(ctx, year)
+	if err != nil {
+		return err
+	}
+
+	err = work(lockCtx)
+	if lost := context.Cause(lockCtx); lost != nil && ctx.Err() == nil {
+		err = lost
+	}
+	release()
+
+	return err
+}
+
+func (eventLoop *EventLoop) runReconcile(ctx context.Context, year int, trigger string) (deletedCount int, err error) {
+	ctx, run := eventLoop.startRun(ctx, &RunRecord{Kind: ReconcileRunKind, Trigger: trigger, Year: year})
+	deletedCount, err = eventLoop.importerFor(year).reconcile(ctx, year)
+	eventLoop.finishRun(run, deletedCount, 0, err)
+
+	return
+}
+
+// importWindow imports the lessons changed within the window in one pass. Only meta events are confirmed with
+// a processed event, which processMessages sends; an admin import of an arbitrary window is not a secondary DB load.
+func (eventLoop *EventLoop) importWindow(ctx context.Context, event events.SecondaryDbLoadedEvent) (summary ImportSummary, err error) {
+	importer := eventLoop.importerFor(event.Year)
+
+	err = eventLoop.withYearLock(ctx, event.Year, func(ctx context.Context) (err error) {
+		err = importer.beginReadTransaction()
+		if err == nil {
+			_, err = eventLoop.sendLessonTypes(ctx, importer, event.Year)
+		}
 		if err == nil {
-			err = eventLoop.reader.CommitMessages(context.Background(), m)
+			summary, err = importer.execute(
+				ctx, event.PreviousSecondaryDatabaseDatetime, event.CurrentSecondaryDatabaseDatetime, event.Year,
+			)
+		}
+		if endErr := importer.endReadTransaction(); err == nil {
+			err = endErr
 		}
+
+		return
+	})
+	if summary.Throttled > 0 {
+		fmt.Fprintf(eventLoop.out, "Kafka writes throttled for %s\n", summary.Throttled.Round(time.Millisecond))
+	}
+	if summary.TransactionId != 0 {
+		fmt.Fprintf(
+			eventLoop.out, "Read in transaction %d started at %s\n",
+			summary.TransactionId, summary.TransactionStartedAt.Format(dateFormat),
+		)
+	}
+
+	fmt.Fprintf(
+		eventLoop.out, "Finish processing %s %s - %s. Error: %v \n", events.SecondaryDbLoadedEventName,
+		event.PreviousSecondaryDatabaseDatetime.Format(dateFormat),
+		event.CurrentSecondaryDatabaseDatetime.Format(dateFormat),
+		err,
+	)
+
+	return
+}
+
+// coalesceLoadedEvents spans the earliest previous and the latest current datetime of the events.
+func coalesceLoadedEvents(loadedEvents []events.SecondaryDbLoadedEvent) events.SecondaryDbLoadedEvent {
+	window := loadedEvents[0]
+	for _, loadedEvent := range loadedEvents[1:] {
+		if loadedEvent.PreviousSecondaryDatabaseDatetime.Before(window.PreviousSecondaryDatabaseDatetime) {
+			window.PreviousSecondaryDatabaseDatetime = loadedEvent.PreviousSecondaryDatabaseDatetime
+		}
+		if loadedEvent.CurrentSecondaryDatabaseDatetime.After(window.CurrentSecondaryDatabaseDatetime) {
+			window.CurrentSecondaryDatabaseDatetime = loadedEvent.CurrentSecondaryDatabaseDatetime
+		}
+	}
+
+	return window
+}
+
+func (eventLoop *EventLoop) refreshLessonTypes(ctx context.Context, year int) (count int, err error) {
+	importer := eventLoop.importerFor(year)
+
+	err = importer.beginReadTransaction()
+	if err == nil {
+		count, err = eventLoop.sendLessonTypes(ctx, importer, year)
 	}
+	if endErr := importer.endReadTransaction(); err == nil {
+		err = endErr
+	}
+	fmt.Fprintf(eventLoop.out, "Finish lesson types refresh of %d year: %d types. Error: %v \n", year, count, err)
 
 	return
 }
+
+// sendLessonTypes expects the read transaction to be open.
+func (eventLoop *EventLoop) sendLessonTypes(ctx context.Context, importer ImporterInterface, year int) (count int, err error) {
+	lessonTypesList, err := importer.importLessonTypes(ctx)
+	if err == nil && len(lessonTypesList) > 0 {
+		err = eventLoop.metaEventbus.sendLessonTypesList(ctx, lessonTypesList, year)
+	}
+
+	return len(lessonTypesList), err
+}
+
+// startRun records the run and adds it to the active ones; the returned context carries its progress.
+func (eventLoop *EventLoop) startRun(ctx context.Context, run *RunRecord) (context.Context, *RunRecord) {
+	run.StartedAt = time.Now()
+	eventLoop.saveRun(run)
+
+	progress := &RunProgress{}
+	eventLoop.activeRuns.Store(run, &ActiveRun{record: *run, progress: progress})
+
+	return withRunProgress(ctx, progress), run
+}
+
+// finishRun saves the run before it leaves the active ones, so a reader never sees it unfinished in both.
+func (eventLoop *EventLoop) finishRun(run *RunRecord, rows int, batches int, err error) {
+	run.FinishedAt = time.Now()
+	run.Rows = rows
+	run.Batches = batches
+	if err != nil {
+		run.Error = eventLoop.redactor.redact(err.Error())
+	}
+	eventLoop.saveRun(run)
+	eventLoop.activeRuns.Delete(run)
+}
+
+// saveRun only logs store failures: the run history must not stop imports.
+func (eventLoop *EventLoop) saveRun(run *RunRecord) {
+	if eventLoop.runHistory == nil {
+		return
+	}
+
+	if err := eventLoop.runHistory.save(run); err != nil {
+		fmt.Fprintf(eventLoop.out, "Failed to save %s run history: %v\n", run.Kind, err)
+	}
+}
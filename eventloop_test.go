@@ -47,8 +47,8 @@ func TestEventLoopExecute(t *testing.T) {
 		}
 
 		metaEventbus := NewMockMetaEventbusInterface(t)
-		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", event).Return(nil)
-		metaEventbus.On("sendLessonTypesList", lessonTypesList, expectedYear).Return(nil)
+		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, event).Return(nil)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, expectedYear).Return(nil)
 
 		reader := mocks.NewReaderInterface(t)
 		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
@@ -56,8 +56,10 @@ func TestEventLoopExecute(t *testing.T) {
 		reader.On("CommitMessages", matchContext, message).Return(nil)
 
 		importer := NewMockImporterInterface(t)
-		importer.On("execute", expectedStartDatetime, expectedEndDatetime, expectedYear).Return(nil)
-		importer.On("importLessonTypes").Return(lessonTypesList, nil)
+		importer.On("execute", matchContext, expectedStartDatetime, expectedEndDatetime, expectedYear).Return(ImportSummary{}, nil)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
 
 		eventLoop := EventLoop{
 			out:          &out,
@@ -66,7 +68,7 @@ func TestEventLoopExecute(t *testing.T) {
 			importer:     importer,
 		}
 
-		err := eventLoop.execute()
+		err := eventLoop.execute(context.Background())
 
 		assert.Equal(t, breakLoopError, err)
 		metaEventbus.AssertExpectations(t)
@@ -80,16 +82,19 @@ func TestEventLoopExecute(t *testing.T) {
 		lessonTypesList := make([]events.LessonType, 1)
 
 		metaEventbus := NewMockMetaEventbusInterface(t)
-		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", event).Return(nil)
-		metaEventbus.On("sendLessonTypesList", lessonTypesList, expectedYear).Return(nil)
+		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, event).Return(nil)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, expectedYear).Return(nil)
 
 		reader := mocks.NewReaderInterface(t)
 		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, context.Canceled).Run(waitFetchCancel).Maybe()
 		reader.On("CommitMessages", matchContext, message).Return(expectedError)
 
 		importer := NewMockImporterInterface(t)
-		importer.On("execute", expectedStartDatetime, expectedEndDatetime, expectedYear).Return(nil)
-		importer.On("importLessonTypes").Return(lessonTypesList, nil)
+		importer.On("execute", matchContext, expectedStartDatetime, expectedEndDatetime, expectedYear).Return(ImportSummary{}, nil)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
 
 		eventLoop := EventLoop{
 			out:          &out,
@@ -98,7 +103,7 @@ func TestEventLoopExecute(t *testing.T) {
 			importer:     importer,
 		}
 
-		err := eventLoop.execute()
+		err := eventLoop.execute(context.Background())
 
 		assert.Error(t, err)
 		assert.Equal(t, expectedError, err)
@@ -107,7 +112,6 @@ func TestEventLoopExecute(t *testing.T) {
 		importer.AssertExpectations(t)
 
 		metaEventbus.AssertNotCalled(t, "sendSecondaryDbLessonProcessedEventName")
-		reader.AssertNumberOfCalls(t, "FetchMessage", 1)
 		reader.AssertNumberOfCalls(t, "CommitMessages", 1)
 	})
 
@@ -115,14 +119,17 @@ func TestEventLoopExecute(t *testing.T) {
 		lessonTypesList := make([]events.LessonType, 1)
 
 		metaEventbus := NewMockMetaEventbusInterface(t)
-		metaEventbus.On("sendLessonTypesList", lessonTypesList, expectedYear).Return(nil)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, expectedYear).Return(nil)
 
 		reader := mocks.NewReaderInterface(t)
 		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, context.Canceled).Run(waitFetchCancel).Maybe()
 
 		importer := NewMockImporterInterface(t)
-		importer.On("execute", expectedStartDatetime, expectedEndDatetime, expectedYear).Return(expectedError)
-		importer.On("importLessonTypes").Return(lessonTypesList, nil)
+		importer.On("execute", matchContext, expectedStartDatetime, expectedEndDatetime, expectedYear).Return(ImportSummary{}, expectedError)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
 
 		eventLoop := EventLoop{
 			out:          &out,
@@ -131,7 +138,7 @@ func TestEventLoopExecute(t *testing.T) {
 			importer:     importer,
 		}
 
-		err := eventLoop.execute()
+		err := eventLoop.execute(context.Background())
 
 		assert.Error(t, err)
 		assert.Equal(t, expectedError, err)
@@ -139,7 +146,6 @@ func TestEventLoopExecute(t *testing.T) {
 		importer.AssertExpectations(t)
 
 		metaEventbus.AssertNotCalled(t, "sendSecondaryDbLessonProcessedEventName")
-		reader.AssertNumberOfCalls(t, "FetchMessage", 1)
 		reader.AssertNotCalled(t, "CommitMessages")
 	})
 
@@ -169,7 +175,7 @@ func TestEventLoopExecute(t *testing.T) {
 			importer:     importer,
 		}
 
-		err := eventLoop.execute()
+		err := eventLoop.execute(context.Background())
 
 		importer.AssertNotCalled(t, "execute")
 
@@ -207,7 +213,7 @@ func TestEventLoopExecute(t *testing.T) {
 			importer:     importer,
 		}
 
-		err := eventLoop.execute()
+		err := eventLoop.execute(context.Background())
 
 		importer.AssertNotCalled(t, "execute")
 
@@ -219,4 +225,188 @@ func TestEventLoopExecute(t *testing.T) {
 		metaEventbus.AssertNotCalled(t, "sendSecondaryDbLessonProcessedEventName")
 	})
 
+	t.Run("process snapshot request", func(t *testing.T) {
+		expectedYear := 2022
+		lessonTypesList := make([]events.LessonType, 1)
+		matchTime := mock.AnythingOfType("time.Time")
+
+		payload, _ := json.Marshal(LessonsSnapshotRequestedEvent{Year: expectedYear})
+		message := kafka.Message{
+			Key:   []byte(LessonsSnapshotRequestedEventName),
+			Value: payload,
+		}
+
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendLessonTypesList", matchContext, lessonTypesList, expectedYear).Return(nil).Once()
+		metaEventbus.On("sendLessonsSnapshotStartedEvent", matchContext, expectedYear, matchTime).Return(nil).Once()
+		metaEventbus.On("sendLessonsSnapshotFinishedEvent", matchContext, expectedYear, 1500, matchTime).Return(nil).Once()
+
+		reader := mocks.NewReaderInterface(t)
+		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError)
+		reader.On("CommitMessages", matchContext, message).Return(nil)
+
+		importer := NewMockImporterInterface(t)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
+		importer.On("snapshot", matchContext, expectedYear).Return(1500, nil).Once()
+
+		eventLoop := EventLoop{
+			out:          &out,
+			metaEventbus: metaEventbus,
+			reader:       reader,
+			importer:     importer,
+		}
+
+		err := eventLoop.execute(context.Background())
+
+		assert.Equal(t, breakLoopError, err)
+		importer.AssertNotCalled(t, "execute")
+	})
+
+	t.Run("snapshot error", func(t *testing.T) {
+		expectedYear := 2022
+		matchTime := mock.AnythingOfType("time.Time")
+
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendLessonsSnapshotStartedEvent", matchContext, expectedYear, matchTime).Return(nil).Once()
+
+		importer := NewMockImporterInterface(t)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return([]events.LessonType{}, nil)
+		importer.On("endReadTransaction").Return(nil)
+		importer.On("snapshot", matchContext, expectedYear).Return(10, expectedError).Once()
+
+		eventLoop := EventLoop{
+			out:          &out,
+			metaEventbus: metaEventbus,
+			importer:     importer,
+		}
+
+		err := eventLoop.runSnapshot(context.Background(), expectedYear, CliRunTrigger)
+
+		assert.Equal(t, expectedError, err)
+		metaEventbus.AssertNotCalled(t, "sendLessonsSnapshotFinishedEvent")
+		metaEventbus.AssertNotCalled(t, "sendLessonTypesList")
+	})
+
+	t.Run("scheduled reconcile", func(t *testing.T) {
+		lessonTypesList := make([]events.LessonType, 0)
+		payload, _ := json.Marshal(event)
+		message := kafka.Message{
+			Key:   []byte(events.SecondaryDbLoadedEventName),
+			Value: payload,
+		}
+
+		// the second event comes after the first one is processed, so the two are not coalesced
+		firstProcessed := make(chan struct{})
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, event).Return(nil).Once().
+			Run(func(args mock.Arguments) { close(firstProcessed) })
+		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, event).Return(nil).Once()
+
+		reader := mocks.NewReaderInterface(t)
+		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(message, nil).Once().Run(func(args mock.Arguments) { <-firstProcessed })
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError)
+		reader.On("CommitMessages", matchContext, message).Return(nil).Twice()
+
+		importer := NewMockImporterInterface(t)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return(lessonTypesList, nil)
+		importer.On("endReadTransaction").Return(nil)
+		importer.On("execute", matchContext, expectedStartDatetime, expectedEndDatetime, expectedYear).Return(ImportSummary{}, nil).Twice()
+		importer.On("reconcile", matchContext, expectedYear).Return(0, expectedError).Once()
+
+		eventLoop := EventLoop{
+			out:               &out,
+			metaEventbus:      metaEventbus,
+			reader:            reader,
+			importer:          importer,
+			reconcileInterval: time.Hour,
+		}
+
+		err := eventLoop.execute(context.Background())
+
+		assert.Equal(t, breakLoopError, err)
+		importer.AssertNumberOfCalls(t, "reconcile", 1)
+	})
+
+	t.Run("mark watchdog import", func(t *testing.T) {
+		payload, _ := json.Marshal(event)
+		message := kafka.Message{
+			Key:   []byte(events.SecondaryDbLoadedEventName),
+			Value: payload,
+		}
+
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, event).Return(nil).Once()
+
+		reader := mocks.NewReaderInterface(t)
+		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError)
+		reader.On("CommitMessages", matchContext, message).Return(nil).Once()
+
+		importer := NewMockImporterInterface(t)
+		importer.On("beginReadTransaction").Return(nil)
+		importer.On("importLessonTypes", matchContext).Return([]events.LessonType{}, nil)
+		importer.On("endReadTransaction").Return(nil)
+		importer.On("execute", matchContext, expectedStartDatetime, expectedEndDatetime, expectedYear).Return(ImportSummary{}, nil)
+
+		watchdog := &Watchdog{out: &out, maxQuietPeriod: time.Hour}
+		watchdog.lastImportAt.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+		watchdog.degraded.Store(true)
+
+		eventLoop := EventLoop{
+			out:          &out,
+			metaEventbus: metaEventbus,
+			reader:       reader,
+			importer:     importer,
+			watchdog:     watchdog,
+		}
+
+		err := eventLoop.execute(context.Background())
+
+		assert.Equal(t, breakLoopError, err)
+		assert.NoError(t, watchdog.readiness())
+		assert.WithinDuration(t, time.Now(), time.Unix(0, watchdog.lastImportAt.Load()), time.Minute)
+	})
+
+	t.Run("read transaction error", func(t *testing.T) {
+		payload, _ := json.Marshal(event)
+		message := kafka.Message{
+			Key:   []byte(events.SecondaryDbLoadedEventName),
+			Value: payload,
+		}
+
+		metaEventbus := NewMockMetaEventbusInterface(t)
+
+		reader := mocks.NewReaderInterface(t)
+		reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, context.Canceled).Run(waitFetchCancel).Maybe()
+
+		importer := NewMockImporterInterface(t)
+		importer.On("beginReadTransaction").Return(expectedError).Once()
+		importer.On("endReadTransaction").Return(nil).Once()
+
+		eventLoop := EventLoop{
+			out:          &out,
+			metaEventbus: metaEventbus,
+			reader:       reader,
+			importer:     importer,
+		}
+
+		err := eventLoop.execute(context.Background())
+
+		assert.Equal(t, expectedError, err)
+		importer.AssertNotCalled(t, "importLessonTypes")
+		importer.AssertNotCalled(t, "execute")
+		reader.AssertNotCalled(t, "CommitMessages")
+	})
+}
+
+// waitFetchCancel blocks a fetched-ahead FetchMessage until the event loop stops fetching.
+func waitFetchCancel(args mock.Arguments) {
+	<-args.Get(0).(context.Context).Done()
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"time"
+)
+
+var importCheckpointsBucket = []byte("import-checkpoints")
+
+// ImportCheckpoint is the position after the last completed chunk of an import window ending at End.
+type ImportCheckpoint struct {
+	End       time.Time
+	NextStart time.Time
+	BeforeId  uint
+}
+
+type ImportCheckpointStoreInterface interface {
+	load(year int, startDatetime time.Time) (*ImportCheckpoint, error)
+	save(year int, startDatetime time.Time, checkpoint ImportCheckpoint) error
+	remove(year int, startDatetime time.Time) error
+}
+
+// ImportCheckpointStore keeps the checkpoint of an import window by its year and start, which a retried meta event
+// keeps even when it is coalesced with a different set of later events. Saving a checkpoint drops the other ones
+// of the year, left by windows that are never retried.
+type ImportCheckpointStore struct {
+	db *bbolt.DB
+}
+
+func importYearPrefix(year int) []byte {
+	return []byte(fmt.Sprintf("%d/", year))
+}
+
+func importWindowKey(year int, startDatetime time.Time) []byte {
+	return append(importYearPrefix(year), startDatetime.Format(time.RFC3339)...)
+}
+
+func (store *ImportCheckpointStore) load(year int, startDatetime time.Time) (checkpoint *ImportCheckpoint, err error) {
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(importCheckpointsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get(importWindowKey(year, startDatetime))
+		if value == nil {
+			return nil
+		}
+
+		checkpoint = &ImportCheckpoint{}
+		return json.Unmarshal(value, checkpoint)
+	})
+
+	return
+}
+
+func (store *ImportCheckpointStore) save(year int, startDatetime time.Time, checkpoint ImportCheckpoint) error {
+	value, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(importCheckpointsBucket)
+		if err != nil {
+			return err
+		}
+
+		key := importWindowKey(year, startDatetime)
+		prefix := importYearPrefix(year)
+		var stale [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			if !bytes.Equal(k, key) {
+				stale = append(stale, append([]byte{}, k...))
+			}
+		}
+		for _, k := range stale {
+			if err = bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return bucket.Put(key, value)
+	})
+}
+
+func (store *ImportCheckpointStore) remove(year int, startDatetime time.Time) error {
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(importCheckpointsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete(importWindowKey(year, startDatetime))
+	})
+}
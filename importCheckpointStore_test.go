@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestImportCheckpointStore(t *testing.T) {
+	stateDb, err := openStateDb(t.TempDir())
+	assert.NoError(t, err)
+	defer stateDb.Close()
+
+	store := &ImportCheckpointStore{db: stateDb}
+	startDatetime := time.Date(2023, 3, 4, 0, 0, 0, 0, time.UTC)
+	endDatetime := time.Date(2023, 3, 7, 4, 0, 0, 0, time.UTC)
+
+	checkpoint, err := store.load(2022, startDatetime)
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	expected := ImportCheckpoint{End: endDatetime, NextStart: time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC), BeforeId: 150}
+	assert.NoError(t, store.save(2022, startDatetime, expected))
+
+	checkpoint, err = store.load(2022, startDatetime)
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, checkpoint)
+
+	checkpoint, err = store.load(2022, startDatetime.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	assert.NoError(t, store.remove(2022, startDatetime))
+	checkpoint, err = store.load(2022, startDatetime)
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	t.Run("new window drops stale checkpoints of the year", func(t *testing.T) {
+		otherStart := startDatetime.AddDate(0, 0, 1)
+		assert.NoError(t, store.save(2022, startDatetime, expected))
+		assert.NoError(t, store.save(2023, startDatetime, expected))
+		assert.NoError(t, store.save(2022, otherStart, expected))
+
+		checkpoint, err := store.load(2022, startDatetime)
+		assert.NoError(t, err)
+		assert.Nil(t, checkpoint)
+
+		checkpoint, err = store.load(2022, otherStart)
+		assert.NoError(t, err)
+		assert.Equal(t, &expected, checkpoint)
+
+		checkpoint, err = store.load(2023, startDatetime)
+		assert.NoError(t, err)
+		assert.Equal(t, &expected, checkpoint)
+	})
+}
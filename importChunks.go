@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+const DayChunkMode = "day"
+const IdChunkMode = "id"
+const DefaultImportChunkDays = 1
+const DefaultImportChunkSize = 5000
+
+// importChunks splits the import window into day ranges or ID keyset pages. Each chunk is read in its own
+// short read-only transaction and checkpointed once published, so a retried window resumes after the last chunk.
+// Day ranges exclude their end, which the next range starts from; only the last one includes the window end.
+// A retry coalesced up to another end still resumes the day ranges, but ID pages only for the same window.
+func (importer *LessonsImporter) importChunks(ctx context.Context, summary *ImportSummary) (err error) {
+	checkpoint := ImportCheckpoint{End: summary.End, NextStart: summary.Start, BeforeId: math.MaxInt32}
+	if importer.checkpointStore != nil {
+		var saved *ImportCheckpoint
+		if saved, err = importer.checkpointStore.load(summary.Year, summary.Start); err != nil {
+			return
+		}
+		if saved != nil && importer.resumable(*saved, summary.End) {
+			checkpoint = *saved
+			checkpoint.End = summary.End
+			summary.Resumed = true
+			fmt.Fprintf(
+				importer.out, "Resume import from checkpoint %s, ID < %d \n",
+				checkpoint.NextStart.Format(dateFormat), checkpoint.BeforeId,
+			)
+		}
+	}
+
+	for done := false; err == nil && !done; {
+		chunk := ChunkSummary{Start: checkpoint.NextStart, End: summary.End}
+		if importer.chunkMode == IdChunkMode {
+			chunk.BeforeId = checkpoint.BeforeId
+		} else if chunkEnd := chunk.Start.AddDate(0, 0, importer.chunkDays); chunkEnd.Before(summary.End) {
+			chunk.End = chunkEnd
+		}
+
+		var lastId uint
+		if lastId, err = importer.publishChunk(ctx, &chunk, summary.Year, !chunk.End.Before(summary.End)); err != nil {
+			break
+		}
+		summary.addChunk(chunk)
+
+		if importer.chunkMode == IdChunkMode {
+			checkpoint.BeforeId = lastId
+			done = chunk.Lessons < importer.chunkSize
+		} else {
+			checkpoint.NextStart = chunk.End
+			done = !chunk.End.Before(summary.End)
+		}
+
+		if !done && importer.checkpointStore != nil {
+			err = importer.checkpointStore.save(summary.Year, summary.Start, checkpoint)
+		}
+	}
+
+	if err == nil && importer.checkpointStore != nil {
+		err = importer.checkpointStore.remove(summary.Year, summary.Start)
+	}
+
+	return
+}
+
+func (importer *LessonsImporter) resumable(checkpoint ImportCheckpoint, endDatetime time.Time) bool {
+	if checkpoint.End.Equal(endDatetime) {
+		return true
+	}
+
+	return importer.chunkMode != IdChunkMode && checkpoint.BeforeId == math.MaxInt32 &&
+		checkpoint.NextStart.Before(endDatetime)
+}
+
+func (importer *LessonsImporter) publishChunk(
+	ctx context.Context, chunk *ChunkSummary, year int, last bool,
+) (lastId uint, err error) {
+	startedAt := time.Now()
+	tx, err := importer.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return
+	}
+
+	startDatetime := importer.bindDatetime(chunk.Start)
+	endDatetime := importer.bindDatetime(chunk.End)
+	if importer.chunkMode == IdChunkMode {
+		chunk.Lessons, lastId, err = importer.publishLessons(
+			ctx, tx, importer.dialect.queries.LessonsPage, year, &chunk.YearChecks,
+			startDatetime, endDatetime, chunk.BeforeId, importer.chunkSize,
+		)
+	} else {
+		query := importer.dialect.queries.LessonsRange
+		if last {
+			query = importer.dialect.queries.Lessons
+		}
+		chunk.Lessons, lastId, err = importer.publishLessons(
+			ctx, tx, query, year, &chunk.YearChecks, startDatetime, endDatetime,
+		)
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		_ = tx.Rollback()
+	}
+	chunk.Duration = time.Since(startedAt)
+
+	return
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestImporterChunks(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	startDatetime := time.Date(2023, 3, 6, 4, 0, 0, 0, time.UTC)
+	endDatetime := time.Date(2023, 3, 7, 4, 0, 0, 0, time.UTC)
+
+	newImporter := func(t *testing.T, writer events.WriterInterface, chunkMode string) *LessonsImporter {
+		db, err := sql.Open("sqlite", ":memory:")
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		_, err = db.Exec(sqliteSchema)
+		assert.NoError(t, err)
+
+		return &LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        SqliteDialect,
+			writer:         writer,
+			writeThreshold: 10,
+			chunkMode:      chunkMode,
+			chunkDays:      1,
+			chunkSize:      1,
+		}
+	}
+
+	collectIds := func(writer *mocks.WriterInterface) (ids []uint) {
+		for _, call := range writer.Calls {
+			for _, argument := range call.Arguments[1:] {
+				var event events.LessonEvent
+				_ = json.Unmarshal(argument.(kafka.Message).Value, &event)
+				ids = append(ids, event.Id)
+			}
+		}
+		return
+	}
+
+	t.Run("day chunks", func(t *testing.T) {
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Times(3)
+
+		importer := newImporter(t, writer, DayChunkMode)
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, summary.Lessons)
+		assert.Len(t, summary.Chunks, 4)
+		assert.Equal(t, time.Date(2023, 3, 4, 0, 0, 0, 0, time.UTC), summary.Chunks[0].Start)
+		assert.Equal(t, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC), summary.Chunks[0].End)
+		assert.Equal(t, summary.Chunks[0].End, summary.Chunks[1].Start)
+		assert.Zero(t, summary.Chunks[2].Lessons)
+		assert.Equal(t, endDatetime, summary.Chunks[3].End)
+		assert.Equal(t, []uint{10, 11, 13}, collectIds(writer))
+		assert.Contains(t, out.String(), " Chunk 4: 2023-03-07 00:00:00 - 2023-03-07 04:00:00: 1 lessons in ")
+	})
+
+	t.Run("lesson on chunk boundary", func(t *testing.T) {
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Times(4)
+
+		importer := newImporter(t, writer, DayChunkMode)
+		importer.writeThreshold = 1
+		_, err := importer.db.Exec(
+			"INSERT INTO T_PRJURN VALUES (14, 100, '2023-03-02 00:00:00', 1, 2, 1, '2023-03-05 00:00:00')",
+		)
+		assert.NoError(t, err)
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 4, summary.Lessons)
+		assert.Equal(t, 1, summary.Chunks[0].Lessons)
+		assert.Equal(t, 2, summary.Chunks[1].Lessons)
+		assert.Equal(t, []uint{10, 14, 11, 13}, collectIds(writer))
+	})
+
+	t.Run("id chunks", func(t *testing.T) {
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Times(3)
+
+		importer := newImporter(t, writer, IdChunkMode)
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, summary.Lessons)
+		assert.Len(t, summary.Chunks, 4)
+		assert.Equal(t, uint(13), summary.Chunks[1].BeforeId)
+		assert.Equal(t, uint(10), summary.Chunks[3].BeforeId)
+		assert.Zero(t, summary.Chunks[3].Lessons)
+		assert.Equal(t, []uint{13, 11, 10}, collectIds(writer))
+	})
+
+	t.Run("resume from checkpoint", func(t *testing.T) {
+		expectedError := errors.New("expected test error")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(expectedError).Once()
+
+		importer := newImporter(t, writer, DayChunkMode)
+		importer.checkpointStore = &ImportCheckpointStore{db: newTestPublishedLessonsStore(t).db}
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+
+		assert.Equal(t, expectedError, err)
+		assert.Len(t, summary.Chunks, 1)
+
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Twice()
+
+		summary, err = importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+
+		assert.NoError(t, err)
+		assert.True(t, summary.Resumed)
+		assert.Len(t, summary.Chunks, 3)
+		assert.Equal(t, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC), summary.Chunks[0].Start)
+		assert.Equal(t, []uint{10, 11, 11, 13}, collectIds(writer))
+
+		checkpoint, err := importer.checkpointStore.load(2022, summary.Start)
+		assert.NoError(t, err)
+		assert.Nil(t, checkpoint)
+	})
+
+	t.Run("resume coalesced retry", func(t *testing.T) {
+		expectedError := errors.New("expected test error")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(expectedError).Once()
+
+		importer := newImporter(t, writer, DayChunkMode)
+		importer.checkpointStore = &ImportCheckpointStore{db: newTestPublishedLessonsStore(t).db}
+
+		_, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+		assert.Equal(t, expectedError, err)
+
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Twice()
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime.Add(time.Hour), 2022)
+
+		assert.NoError(t, err)
+		assert.True(t, summary.Resumed)
+		assert.Equal(t, time.Date(2023, 3, 5, 0, 0, 0, 0, time.UTC), summary.Chunks[0].Start)
+		assert.Equal(t, []uint{10, 11, 11, 13}, collectIds(writer))
+
+		checkpoint, err := importer.checkpointStore.load(2022, summary.Start)
+		assert.NoError(t, err)
+		assert.Nil(t, checkpoint)
+	})
+
+	t.Run("id pages restart on another window", func(t *testing.T) {
+		expectedError := errors.New("expected test error")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(expectedError).Once()
+
+		importer := newImporter(t, writer, IdChunkMode)
+		importer.checkpointStore = &ImportCheckpointStore{db: newTestPublishedLessonsStore(t).db}
+
+		_, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+		assert.Equal(t, expectedError, err)
+
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Times(3)
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime.Add(time.Hour), 2022)
+
+		assert.NoError(t, err)
+		assert.False(t, summary.Resumed)
+		assert.Equal(t, []uint{13, 11, 13, 11, 10}, collectIds(writer))
+	})
+}
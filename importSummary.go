@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ChunkSummary describes one chunk of an import window: a day range or an ID keyset page (lessons with ID below BeforeId).
+type ChunkSummary struct {
+	Start      time.Time
+	End        time.Time
+	BeforeId   uint
+	Lessons    int
+	YearChecks YearCheckCounts
+	Duration   time.Duration
+}
+
+type ImportSummary struct {
+	Year                 int
+	Start                time.Time
+	End                  time.Time
+	Lessons              int
+	Batches              int
+	Resumed              bool
+	StartedAt            time.Time
+	Duration             time.Duration
+	Throttled            time.Duration
+	TransactionId        int64
+	TransactionStartedAt time.Time
+	YearChecks           YearCheckCounts
+	Chunks               []ChunkSummary
+}
+
+func (summary *ImportSummary) addChunk(chunk ChunkSummary) {
+	summary.Chunks = append(summary.Chunks, chunk)
+	summary.Lessons += chunk.Lessons
+	summary.YearChecks.add(chunk.YearChecks)
+}
+
+func (summary *ImportSummary) report(out io.Writer) {
+	for i, chunk := range summary.Chunks {
+		fmt.Fprintf(
+			out, " Chunk %d: %s - %s", i+1,
+			chunk.Start.Format(dateFormat), chunk.End.Format(dateFormat),
+		)
+		if chunk.BeforeId != 0 {
+			fmt.Fprintf(out, ", ID < %d", chunk.BeforeId)
+		}
+		fmt.Fprintf(out, ": %d lessons in %s \n", chunk.Lessons, chunk.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(
+		out, "Imported %d lessons in %d chunks in %s \n",
+		summary.Lessons, len(summary.Chunks), summary.Duration.Round(time.Millisecond),
+	)
+	if summary.YearChecks.mismatches() != 0 {
+		fmt.Fprintf(
+			out, "Academic year mismatches: %d trusted row, %d trusted event, %d quarantined \n",
+			summary.YearChecks.TrustedRow, summary.YearChecks.TrustedEvent, summary.YearChecks.Quarantined,
+		)
+	}
+}
@@ -3,63 +3,196 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kneu-messenger-pigeon/events"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"time"
 )
 
-const LessonQuery = `SELECT ID, NUM_PREDM, DATEZAN, NUM_VARZAN, HALF,
-    (case FSTATUS when 0 then 1 else 0 end) as isDeleted
-FROM T_PRJURN WHERE REGDATE BETWEEN ? AND ? ORDER BY ID DESC`
-
-const LessonTypesQuery = `SELECT ID, SHIRTNAME, LONGNAME FROM T_VARZAN`
-
 const AdditionalDateRangeInDays = 2
 
 type ImporterInterface interface {
-	execute(startDatetime time.Time, endDatetime time.Time, year int) error
-	importLessonTypes() ([]events.LessonType, error)
+	execute(ctx context.Context, startDatetime time.Time, endDatetime time.Time, year int) (ImportSummary, error)
+	snapshot(ctx context.Context, year int) (int, error)
+	reconcile(ctx context.Context, year int) (int, error)
+	importLessonTypes(ctx context.Context) ([]events.LessonType, error)
+	beginReadTransaction() error
+	endReadTransaction() error
 }
 
 type LessonsImporter struct {
-	out            io.Writer
-	db             *sql.DB
-	writer         events.WriterInterface
-	writeThreshold int
+	out                io.Writer
+	db                 *sql.DB
+	dialect            *Dialect
+	writer             events.WriterInterface
+	writeThreshold     int
+	payloadVersion     int
+	publishedStore     PublishedLessonsStoreInterface
+	stateWriter        *LessonsStateWriter
+	chunkMode          string
+	chunkDays          int
+	chunkSize          int
+	checkpointStore    ImportCheckpointStoreInterface
+	readTransaction    *ReadTransaction
+	location           *time.Location
+	yearMismatchPolicy string
+	rateLimiter        *RateLimiter
+	codec              *PayloadCodec
+	writtenBatches     int
+	throttled          time.Duration
 }
 
-func (importer *LessonsImporter) execute(startDatetime time.Time, endDatetime time.Time, year int) (err error) {
-	if err = importer.db.Ping(); err != nil {
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// clone returns an importer that shares the DB pool, writers and stores but keeps its own read transaction.
+func (importer *LessonsImporter) clone() *LessonsImporter {
+	clone := *importer
+	clone.readTransaction = nil
+	clone.writtenBatches = 0
+	clone.throttled = 0
+
+	return &clone
+}
+
+func (importer *LessonsImporter) execute(
+	ctx context.Context, startDatetime time.Time, endDatetime time.Time, year int,
+) (summary ImportSummary, err error) {
+	ctx, span := tracer.Start(ctx, "import lessons", trace.WithAttributes(attribute.Int("year", year)))
+	defer func() {
+		span.SetAttributes(attribute.Int("lessons", summary.Lessons))
+		endSpan(span, err)
+	}()
+
+	if err = importer.prepare(ctx); err != nil {
 		return
 	}
 
+	startDatetime = importer.inSourceLocation(startDatetime)
 	startDatetime = time.Date(
 		startDatetime.Year(), startDatetime.Month(), startDatetime.Day()-AdditionalDateRangeInDays,
 		0, 0, 0, 0, startDatetime.Location(),
 	)
+	endDatetime = importer.inSourceLocation(endDatetime)
+
+	summary = ImportSummary{
+		Year:      year,
+		Start:     startDatetime,
+		End:       endDatetime,
+		StartedAt: time.Now(),
+	}
+	throttledBefore := importer.throttled
+	batchesBefore := importer.writtenBatches
+	if importer.readTransaction != nil {
+		summary.TransactionId = importer.readTransaction.Id
+		summary.TransactionStartedAt = importer.readTransaction.StartedAt
+	}
 
-	startedAt := time.Now()
 	fmt.Fprintf(importer.out, "Start import lessons: \n")
-	rows, err := importer.db.Query(
-		LessonQuery,
-		startDatetime.Format(dateFormat),
-		endDatetime.Format(dateFormat),
+	if importer.chunkMode == DayChunkMode || importer.chunkMode == IdChunkMode {
+		err = importer.importChunks(ctx, &summary)
+		summary.Duration = time.Since(summary.StartedAt)
+		summary.Throttled = importer.throttled - throttledBefore
+		summary.Batches = importer.writtenBatches - batchesBefore
+		summary.report(importer.out)
+		return
+	}
+
+	chunk := ChunkSummary{Start: startDatetime, End: endDatetime}
+	chunk.Lessons, _, err = importer.publishLessons(
+		ctx, importer.source(), importer.dialect.queries.Lessons, year, &chunk.YearChecks,
+		importer.bindDatetime(startDatetime),
+		importer.bindDatetime(endDatetime),
 	)
+	chunk.Duration = time.Since(summary.StartedAt)
+	summary.addChunk(chunk)
+	summary.Duration = chunk.Duration
+	summary.Throttled = importer.throttled - throttledBefore
+	summary.Batches = importer.writtenBatches - batchesBefore
+
+	return
+}
+
+func (importer *LessonsImporter) snapshot(ctx context.Context, year int) (count int, err error) {
+	ctx, span := tracer.Start(ctx, "snapshot lessons", trace.WithAttributes(attribute.Int("year", year)))
+	defer func() {
+		span.SetAttributes(attribute.Int("lessons", count))
+		endSpan(span, err)
+	}()
+
+	if err = importer.prepare(ctx); err != nil {
+		return
+	}
+
+	startDatetime, endDatetime := importer.academicYearRange(year)
+
+	fmt.Fprintf(importer.out, "Start snapshot of lessons for %d year: \n", year)
+	count, _, err = importer.publishLessons(
+		ctx, importer.source(), importer.dialect.queries.Snapshot, year, &YearCheckCounts{},
+		importer.bindDatetime(startDatetime),
+		importer.bindDatetime(endDatetime),
+	)
+
+	return
+}
+
+func (importer *LessonsImporter) prepare(ctx context.Context) (err error) {
+	if err = importer.db.Ping(); err != nil {
+		return
+	}
+
+	if spool, ok := importer.writer.(flusher); ok {
+		if err = spool.flush(ctx); err != nil {
+			fmt.Fprintf(importer.out, "Spool replay postponed: %v\n", err)
+			err = nil
+		}
+	}
+
+	return
+}
+
+func (importer *LessonsImporter) publishLessons(
+	ctx context.Context, source queryer, definition QueryDefinition, year int, yearChecks *YearCheckCounts, args ...any,
+) (i int, lastId uint, err error) {
+	startedAt := time.Now()
+	_, querySpan := tracer.Start(ctx, "query lessons", trace.WithAttributes(
+		attribute.String("db.system", importer.dialect.name),
+		attribute.String("db.statement", definition.build()),
+	))
+	defer func() {
+		querySpan.SetAttributes(attribute.Int("db.rows", i))
+		endSpan(querySpan, err)
+	}()
+
+	rows, err := source.QueryContext(ctx, definition.build(), args...)
 	if err != nil {
 		return
 	}
 
 	defer rows.Close()
 
+	var event ExtendedLessonEvent
+	targets, extraTargets, err := bindColumns(rows, definition, lessonEventBindings, &event)
+	if err != nil {
+		return
+	}
+
 	var messages []kafka.Message
+	var lessons []events.LessonEvent
 	var nextErr error
 	writeMessages := func(threshold int) bool {
 		if len(messages) != 0 && len(messages) >= threshold {
-			nextErr = importer.writer.WriteMessages(context.Background(), messages...)
+			nextErr = importer.writeLessons(ctx, messages, lessons)
+			if nextErr == nil && importer.publishedStore != nil {
+				nextErr = importer.addPublished(year, lessons)
+			}
 			messages = []kafka.Message{}
+			lessons = []events.LessonEvent{}
 			fmt.Fprintf(importer.out, ".")
 			if err == nil && nextErr != nil {
 				err = nextErr
@@ -68,39 +201,199 @@ func (importer *LessonsImporter) execute(startDatetime time.Time, endDatetime ti
 		return err == nil
 	}
 
-	var event events.LessonEvent
-	i := 0
 	for rows.Next() && writeMessages(importer.writeThreshold) {
 		i++
-		err = rows.Scan(&event.Id, &event.DisciplineId, &event.Date, &event.TypeId, &event.Semester, &event.IsDeleted)
+		err = rows.Scan(targets...)
 		if err == nil {
-			event.Year = year
-			payload, _ := json.Marshal(event)
-			messages = append(messages, kafka.Message{
-				Key:   []byte(events.LessonEventName),
-				Value: payload,
-			})
+			event.Date = importer.normalizeDatetime(event.Date)
+			event.RegDate = importer.normalizeDatetime(event.RegDate)
+			lastId = event.Id
+			if importer.checkAcademicYear(&event, year, yearChecks) {
+				event.Extra = readExtraColumns(extraTargets)
+				var message kafka.Message
+				if message, err = importer.newLessonMessage(&event); err == nil {
+					messages = append(messages, message)
+					lessons = append(lessons, event.LessonEvent)
+				}
+			}
 		}
 	}
+	if err == nil {
+		err = rows.Err()
+	}
 	writeMessages(0)
 	fmt.Fprintf(
 		importer.out, " finished.\n Send %d lessons. Error: %v. Done in %d seconds \n",
 		i, err, int(time.Now().Sub(startedAt).Seconds()),
 	)
+	if yearChecks.mismatches() != 0 {
+		fmt.Fprintf(
+			importer.out, " Academic year mismatches: %d trusted row, %d trusted event, %d quarantined \n",
+			yearChecks.TrustedRow, yearChecks.TrustedEvent, yearChecks.Quarantined,
+		)
+	}
+
+	return
+}
+
+func (importer *LessonsImporter) writeLessons(ctx context.Context, messages []kafka.Message, lessons []events.LessonEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "write lessons batch", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.Int("messaging.batch.message_count", len(messages)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	injectTraceContext(ctx, messages)
+	delay, err := importer.rateLimiter.wait(ctx, messages)
+	importer.throttled += delay
+	if err == nil {
+		err = importer.writer.WriteMessages(ctx, messages...)
+	}
+
+	if err == nil && importer.stateWriter != nil {
+		var stateMessages []kafka.Message
+		stateMessages, err = importer.stateWriter.messages(lessons)
+		if err == nil {
+			injectTraceContext(ctx, stateMessages)
+			delay, err = importer.rateLimiter.wait(ctx, stateMessages)
+			importer.throttled += delay
+		}
+		if err == nil {
+			err = importer.stateWriter.writer.WriteMessages(ctx, stateMessages...)
+		}
+	}
+	if err == nil {
+		importer.writtenBatches++
+		runProgressFromContext(ctx).add(len(messages))
+	}
+
+	return
+}
+
+// reconcile emits a deleted LessonEvent for every lesson published earlier for the year that no longer exists in the secondary DB.
+func (importer *LessonsImporter) reconcile(ctx context.Context, year int) (deletedCount int, err error) {
+	ctx, span := tracer.Start(ctx, "reconcile lessons", trace.WithAttributes(attribute.Int("year", year)))
+	defer func() {
+		span.SetAttributes(attribute.Int("deleted", deletedCount))
+		endSpan(span, err)
+	}()
+
+	if importer.publishedStore == nil {
+		return 0, errors.New("reconciliation requires STATE_DIR to keep published lessons")
+	}
+
+	if err = importer.prepare(ctx); err != nil {
+		return
+	}
+
+	published, err := importer.publishedStore.list(year)
+	if err != nil || len(published) == 0 {
+		return
+	}
+
+	existingIds, err := importer.lessonIds(ctx, year)
+	if err != nil {
+		return
+	}
+
+	if len(existingIds) == 0 {
+		return 0, fmt.Errorf("secondary DB has no lessons for %d year, refusing to delete %d published lessons", year, len(published))
+	}
+
+	var vanishedIds []uint
+	var vanishedLessons []events.LessonEvent
+	var messages []kafka.Message
+	var message kafka.Message
+	for id, lesson := range published {
+		if _, exists := existingIds[id]; err == nil && !exists {
+			lesson.IsDeleted = true
+			vanishedIds = append(vanishedIds, id)
+			vanishedLessons = append(vanishedLessons, lesson)
+			message, err = importer.newLessonMessage(&ExtendedLessonEvent{LessonEvent: lesson})
+			messages = append(messages, message)
+		}
+	}
+
+	for start := 0; err == nil && start < len(messages); start += importer.writeThreshold {
+		end := min(start+importer.writeThreshold, len(messages))
+		err = importer.writeLessons(ctx, messages[start:end], vanishedLessons[start:end])
+		if err == nil {
+			err = importer.publishedStore.remove(year, vanishedIds[start:end])
+		}
+		if err == nil {
+			deletedCount = end
+		}
+	}
+
+	fmt.Fprintf(
+		importer.out, "Reconcile %d year: %d published, %d exist, %d deleted. Error: %v \n",
+		year, len(published), len(existingIds), deletedCount, err,
+	)
 
 	return
 }
 
-func (importer *LessonsImporter) importLessonTypes() (list []events.LessonType, err error) {
-	rows, err := importer.db.Query(LessonTypesQuery)
+func (importer *LessonsImporter) lessonIds(ctx context.Context, year int) (ids map[uint]struct{}, err error) {
+	startDatetime, endDatetime := importer.academicYearRange(year)
+	definition := importer.dialect.queries.LessonIds
+
+	rows, err := importer.db.QueryContext(ctx,
+		definition.build(),
+		importer.bindDatetime(startDatetime),
+		importer.bindDatetime(endDatetime),
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var event ExtendedLessonEvent
+	targets, _, err := bindColumns(rows, definition, lessonEventBindings, &event)
+
+	ids = make(map[uint]struct{})
+	for err == nil && rows.Next() {
+		if err = rows.Scan(targets...); err == nil {
+			ids[event.Id] = struct{}{}
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+// academicYearRange returns the [start, end) DATEZAN range of the academic year that begins in September of the year.
+func (importer *LessonsImporter) academicYearRange(year int) (time.Time, time.Time) {
+	location := importer.location
+	if location == nil {
+		location = time.Local
+	}
+
+	return time.Date(year, time.September, 1, 0, 0, 0, 0, location),
+		time.Date(year+1, time.September, 1, 0, 0, 0, 0, location)
+}
+
+func (importer *LessonsImporter) importLessonTypes(ctx context.Context) (list []events.LessonType, err error) {
+	ctx, span := tracer.Start(ctx, "import lesson types")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := importer.source().QueryContext(ctx, importer.dialect.queries.LessonTypes.build())
 	if rows != nil {
 		defer rows.Close()
 	}
 
 	var lessonType events.LessonType
+	var targets []any
+	if err == nil {
+		targets, _, err = bindColumns(rows, importer.dialect.queries.LessonTypes, lessonTypeBindings, &lessonType)
+	}
+
 	for err == nil && rows.Next() {
-		err = rows.Scan(&lessonType.Id, &lessonType.ShortName, &lessonType.LongName)
+		err = rows.Scan(targets...)
 		list = append(list, lessonType)
 	}
+	if err == nil {
+		err = rows.Err()
+	}
 	return
 }
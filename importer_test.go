@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
@@ -29,9 +30,9 @@ func TestImporterExecute(t *testing.T) {
 	var matchContext = mock.MatchedBy(func(ctx context.Context) bool { return true })
 
 	t.Run("valid lessons", func(t *testing.T) {
-		startDatetime = time.Date(2023, 3, 5, 4, 0, 0, 0, time.Local)
-		endDatetime = time.Date(2023, 3, 5, 4, 0, 0, 0, time.Local)
-		expectedSqlStartDatetime := time.Date(2023, 3, 5-AdditionalDateRangeInDays, 0, 0, 0, 0, time.Local)
+		startDatetime = time.Date(2023, 3, 5, 4, 0, 0, 0, time.UTC)
+		endDatetime = time.Date(2023, 3, 5, 4, 0, 0, 0, time.UTC)
+		expectedSqlStartDatetime := time.Date(2023, 3, 5-AdditionalDateRangeInDays, 0, 0, 0, 0, time.UTC)
 
 		// Start  Init DB Mock
 		db, dbMock, err := sqlmock.New()
@@ -46,7 +47,7 @@ func TestImporterExecute(t *testing.T) {
 				Id:           i,
 				DisciplineId: 99,
 				TypeId:       uint8(rand.Intn(10) + 1),
-				Date:         time.Date(2022, 12, 20, 14, 36, 0, 0, time.Local),
+				Date:         time.Date(2022, 12, 20, 14, 36, 0, 0, time.UTC),
 				Year:         year,
 				Semester:     uint8(rand.Intn(2) + 1),
 				IsDeleted:    i%7 == 3,
@@ -60,7 +61,7 @@ func TestImporterExecute(t *testing.T) {
 			expectedEvents = append(expectedEvents, event)
 		}
 
-		dbMock.ExpectQuery(regexp.QuoteMeta(LessonQuery)).WithArgs(
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WithArgs(
 			expectedSqlStartDatetime.Format(dateFormat), endDatetime.Format(dateFormat),
 		).WillReturnRows(rows)
 		// End Init DB Mock
@@ -100,13 +101,18 @@ func TestImporterExecute(t *testing.T) {
 		importer := LessonsImporter{
 			out:            &out,
 			db:             db,
+			dialect:        FirebirdDialect,
 			writer:         writer,
 			writeThreshold: chunkSize,
+			location:       time.UTC,
 		}
 
-		err = importer.execute(startDatetime, endDatetime, year)
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, year)
 
 		assert.NoError(t, err)
+		assert.Equal(t, 15, summary.Lessons)
+		assert.Len(t, summary.Chunks, 1)
+		assert.Equal(t, expectedSqlStartDatetime, summary.Start)
 
 		err = dbMock.ExpectationsWereMet()
 		assert.NoErrorf(t, err, "there were unfulfilled expectations: %s", err)
@@ -128,7 +134,7 @@ func TestImporterExecute(t *testing.T) {
 			log.Fatalf("an error '%s' was not expected when opening a mock database connection", err)
 		}
 
-		dbMock.ExpectQuery(regexp.QuoteMeta(LessonQuery)).WithArgs(
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WithArgs(
 			expectedSqlStartDatetime.Format(dateFormat), endDatetime.Format(dateFormat),
 		).WillReturnError(expectedError)
 		// End Init DB Mock
@@ -140,11 +146,12 @@ func TestImporterExecute(t *testing.T) {
 		importer := LessonsImporter{
 			out:            &out,
 			db:             db,
+			dialect:        FirebirdDialect,
 			writer:         writer,
 			writeThreshold: 3,
 		}
 
-		err = importer.execute(startDatetime, endDatetime, year)
+		_, err = importer.execute(context.Background(), startDatetime, endDatetime, year)
 
 		assert.Error(t, err)
 		assert.Equal(t, expectedError, err)
@@ -172,7 +179,7 @@ func TestImporterExecute(t *testing.T) {
 			21, nil, time.Time{}, 1, nil, false,
 		)
 
-		dbMock.ExpectQuery(regexp.QuoteMeta(LessonQuery)).WithArgs(
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WithArgs(
 			expectedSqlStartDatetime.Format(dateFormat), endDatetime.Format(dateFormat),
 		).WillReturnRows(rows)
 		// End Init DB Mock
@@ -198,11 +205,12 @@ func TestImporterExecute(t *testing.T) {
 		importer := LessonsImporter{
 			out:            &out,
 			db:             db,
+			dialect:        FirebirdDialect,
 			writer:         writer,
 			writeThreshold: 3,
 		}
 
-		err = importer.execute(startDatetime, endDatetime, year)
+		_, err = importer.execute(context.Background(), startDatetime, endDatetime, year)
 
 		assert.Error(t, err)
 		assert.ErrorContains(t, err, "sql: Scan error on column index ")
@@ -214,6 +222,39 @@ func TestImporterExecute(t *testing.T) {
 		writer.AssertExpectations(t)
 	})
 
+	t.Run("rows iteration error", func(t *testing.T) {
+		startDatetime = time.Date(2023, 3, 5, 0, 0, 0, 0, time.Local)
+		endDatetime = time.Date(2023, 3, 5, 4, 0, 0, 0, time.Local)
+		expectedError := errors.New("connection lost")
+
+		db, dbMock, err := sqlmock.New()
+		if err != nil {
+			log.Fatalf("an error '%s' was not expected when opening a mock database connection", err)
+		}
+
+		rows := sqlmock.NewRows(expectedColumns).
+			AddRow(20, 999, time.Time{}, 1, 1, false).
+			AddRow(21, 999, time.Time{}, 1, 1, false).
+			RowError(1, expectedError)
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WillReturnRows(rows)
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Maybe()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        FirebirdDialect,
+			writer:         writer,
+			writeThreshold: 500,
+		}
+
+		_, err = importer.execute(context.Background(), startDatetime, endDatetime, year)
+
+		assert.Equal(t, expectedError, err)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
 	t.Run("writer error", func(t *testing.T) {
 		startDatetime = time.Date(2023, 3, 5, 0, 0, 0, 0, time.Local)
 		endDatetime = time.Date(2023, 3, 5, 4, 0, 0, 0, time.Local)
@@ -231,7 +272,7 @@ func TestImporterExecute(t *testing.T) {
 			expectedId, 999, time.Time{}, 1, 1, false,
 		)
 
-		dbMock.ExpectQuery(regexp.QuoteMeta(LessonQuery)).WithArgs(
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WithArgs(
 			expectedSqlStartDatetime.Format(dateFormat), endDatetime.Format(dateFormat),
 		).WillReturnRows(rows)
 		// End Init DB Mock
@@ -249,11 +290,12 @@ func TestImporterExecute(t *testing.T) {
 		importer := LessonsImporter{
 			out:            &out,
 			db:             db,
+			dialect:        FirebirdDialect,
 			writer:         writer,
 			writeThreshold: 1,
 		}
 
-		err = importer.execute(startDatetime, endDatetime, year)
+		_, err = importer.execute(context.Background(), startDatetime, endDatetime, year)
 
 		assert.Error(t, err)
 		assert.Equal(t, expectedError, err)
@@ -274,11 +316,12 @@ func TestImporterExecute(t *testing.T) {
 		importer := LessonsImporter{
 			out:            &out,
 			db:             db,
+			dialect:        FirebirdDialect,
 			writer:         nil,
 			writeThreshold: 3,
 		}
 
-		err := importer.execute(startDatetime, endDatetime, year)
+		_, err := importer.execute(context.Background(), startDatetime, endDatetime, year)
 
 		assert.Error(t, err)
 		assert.Equal(t, expectedErr, err)
@@ -286,8 +329,217 @@ func TestImporterExecute(t *testing.T) {
 
 }
 
+func TestImporterSnapshot(t *testing.T) {
+	var out bytes.Buffer
+	var matchContext = mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	t.Run("academic year lessons", func(t *testing.T) {
+		db, dbMock, _ := sqlmock.New()
+
+		rows := sqlmock.NewRows(expectedColumns).
+			AddRow(10, 99, time.Date(2023, 9, 4, 8, 0, 0, 0, time.Local), 1, 1, false).
+			AddRow(11, 99, time.Date(2024, 5, 4, 8, 0, 0, 0, time.Local), 1, 2, true)
+
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Snapshot.build())).WithArgs(
+			"2023-09-01 00:00:00", "2024-09-01 00:00:00",
+		).WillReturnRows(rows)
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything, mock.Anything).Return(nil).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        FirebirdDialect,
+			writer:         writer,
+			writeThreshold: 3,
+		}
+
+		count, err := importer.snapshot(context.Background(), 2023)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("db ping fails", func(t *testing.T) {
+		expectedErr := errors.New("ping error")
+
+		db, dbMock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		dbMock.ExpectPing().WillReturnError(expectedErr)
+
+		importer := LessonsImporter{
+			out:     &out,
+			db:      db,
+			dialect: FirebirdDialect,
+		}
+
+		count, err := importer.snapshot(context.Background(), 2023)
+
+		assert.Equal(t, expectedErr, err)
+		assert.Zero(t, count)
+	})
+}
+
+func TestImporterReconcile(t *testing.T) {
+	var out bytes.Buffer
+	var matchContext = mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	newDb := func(t *testing.T) *sql.DB {
+		db, err := sql.Open("sqlite", ":memory:")
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		_, err = db.Exec(sqliteSchema)
+		assert.NoError(t, err)
+		return db
+	}
+
+	t.Run("emit deleted lessons for vanished ids", func(t *testing.T) {
+		db := newDb(t)
+		store := newTestPublishedLessonsStore(t)
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        SqliteDialect,
+			writer:         writer,
+			writeThreshold: 10,
+			publishedStore: store,
+		}
+
+		count, err := importer.snapshot(context.Background(), 2022)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, count)
+
+		_, err = db.Exec("DELETE FROM T_PRJURN WHERE ID IN (11, 13)")
+		assert.NoError(t, err)
+
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Twice()
+		importer.writeThreshold = 1
+
+		deletedCount, err := importer.reconcile(context.Background(), 2022)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, deletedCount)
+
+		var deletedIds []uint
+		for _, call := range writer.Calls[1:] {
+			var event events.LessonEvent
+			_ = json.Unmarshal(call.Arguments.Get(1).(kafka.Message).Value, &event)
+			assert.True(t, event.IsDeleted)
+			assert.Equal(t, uint(100), event.DisciplineId)
+			assert.Equal(t, 2022, event.Year)
+			deletedIds = append(deletedIds, event.Id)
+		}
+		assert.ElementsMatch(t, []uint{11, 13}, deletedIds)
+
+		published, _ := store.list(2022)
+		assert.Len(t, published, 2)
+
+		deletedCount, err = importer.reconcile(context.Background(), 2022)
+		assert.NoError(t, err)
+		assert.Zero(t, deletedCount)
+	})
+
+	t.Run("write lessons state and tombstones", func(t *testing.T) {
+		db := newDb(t)
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Once()
+
+		stateWriter := mocks.NewWriterInterface(t)
+		stateWriter.On("WriteMessages", matchContext, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+		stateWriter.On("WriteMessages", matchContext, kafka.Message{Key: []byte("12")}).Return(nil).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        SqliteDialect,
+			writer:         writer,
+			writeThreshold: 10,
+			publishedStore: newTestPublishedLessonsStore(t),
+			stateWriter:    &LessonsStateWriter{writer: stateWriter},
+		}
+
+		_, err := importer.snapshot(context.Background(), 2022)
+		assert.NoError(t, err)
+		for _, argument := range stateWriter.Calls[0].Arguments[1:] {
+			stateMessage := argument.(kafka.Message)
+			assert.Equal(t, string(stateMessage.Key) == "11", stateMessage.Value == nil, string(stateMessage.Key))
+		}
+
+		_, err = db.Exec("DELETE FROM T_PRJURN WHERE ID = 12")
+		assert.NoError(t, err)
+
+		deletedCount, err := importer.reconcile(context.Background(), 2022)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deletedCount)
+	})
+
+	t.Run("refuse to delete everything", func(t *testing.T) {
+		db := newDb(t)
+		store := newTestPublishedLessonsStore(t)
+		_ = store.add(2022, []events.LessonEvent{{Id: 10}})
+
+		_, err := db.Exec("DELETE FROM T_PRJURN")
+		assert.NoError(t, err)
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        SqliteDialect,
+			writer:         mocks.NewWriterInterface(t),
+			writeThreshold: 10,
+			publishedStore: store,
+		}
+
+		deletedCount, err := importer.reconcile(context.Background(), 2022)
+
+		assert.EqualError(t, err, "secondary DB has no lessons for 2022 year, refusing to delete 1 published lessons")
+		assert.Zero(t, deletedCount)
+	})
+
+	t.Run("writer error keeps lessons in store", func(t *testing.T) {
+		expectedError := errors.New("expected test error")
+		db := newDb(t)
+		store := newTestPublishedLessonsStore(t)
+		_ = store.add(2022, []events.LessonEvent{{Id: 10}, {Id: 999}})
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(expectedError).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        SqliteDialect,
+			writer:         writer,
+			writeThreshold: 10,
+			publishedStore: store,
+		}
+
+		deletedCount, err := importer.reconcile(context.Background(), 2022)
+
+		assert.Equal(t, expectedError, err)
+		assert.Zero(t, deletedCount)
+		published, _ := store.list(2022)
+		assert.Len(t, published, 2)
+	})
+
+	t.Run("without store", func(t *testing.T) {
+		importer := LessonsImporter{out: &out, dialect: SqliteDialect}
+
+		_, err := importer.reconcile(context.Background(), 2022)
+
+		assert.EqualError(t, err, "reconciliation requires STATE_DIR to keep published lessons")
+	})
+}
+
 func TestImportLessonsType(t *testing.T) {
-	columns := []string{"ID", "NUM_PREDM", "DATEZAN"}
+	columns := []string{"ID", "SHIRTNAME", "LONGNAME"}
 	t.Run("valid lesson types", func(t *testing.T) {
 		var out bytes.Buffer
 
@@ -295,8 +547,9 @@ func TestImportLessonsType(t *testing.T) {
 		db, dbMock, _ := sqlmock.New()
 
 		importer := LessonsImporter{
-			out: &out,
-			db:  db,
+			out:     &out,
+			db:      db,
+			dialect: FirebirdDialect,
 		}
 
 		expectedLessonType := events.LessonType{
@@ -309,9 +562,9 @@ func TestImportLessonsType(t *testing.T) {
 			expectedLessonType.Id, expectedLessonType.ShortName, expectedLessonType.LongName,
 		)
 
-		dbMock.ExpectQuery(regexp.QuoteMeta(LessonTypesQuery)).WillReturnRows(rows)
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.LessonTypes.build())).WillReturnRows(rows)
 
-		actualLessonTypes, actualErr := importer.importLessonTypes()
+		actualLessonTypes, actualErr := importer.importLessonTypes(context.Background())
 
 		assert.Equal(t, []events.LessonType{expectedLessonType}, actualLessonTypes)
 		assert.NoError(t, actualErr)
@@ -323,12 +576,13 @@ func TestImportLessonsType(t *testing.T) {
 		// Start  Init DB Mock
 		db, dbMock, _ := sqlmock.New()
 		importer := LessonsImporter{
-			out: &out,
-			db:  db,
+			out:     &out,
+			db:      db,
+			dialect: FirebirdDialect,
 		}
 
-		dbMock.ExpectQuery(regexp.QuoteMeta(LessonTypesQuery)).WillReturnError(expectedError)
-		actualLessonTypes, actualErr := importer.importLessonTypes()
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.LessonTypes.build())).WillReturnError(expectedError)
+		actualLessonTypes, actualErr := importer.importLessonTypes(context.Background())
 
 		assert.Nil(t, actualLessonTypes)
 		assert.Error(t, actualErr)
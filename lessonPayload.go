@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+const LessonSchemaVersion = 1
+const ExtendedLessonSchemaVersion = 2
+
+const SchemaVersionHeader = "schema-version"
+
+// ExtendedLessonEvent embeds events.LessonEvent, so its JSON is a superset of the current payload
+// and consumers that only know LessonEvent keep decoding it.
+type ExtendedLessonEvent struct {
+	events.LessonEvent
+	SchemaVersion int
+	RegDate       time.Time
+	Status        int
+	Extra         map[string]any `json:",omitempty"`
+}
+
+func withExtendedPayload(queries QuerySet, extraColumns []string) QuerySet {
+	extended := QueryDefinition{
+		Columns: map[string]string{
+			"RegDate": "REGDATE",
+			"Status":  "FSTATUS",
+		},
+		ExtraColumns: append([]string{"REGDATE", "FSTATUS"}, extraColumns...),
+	}
+	queries.Lessons = queries.Lessons.merge(extended)
+	queries.LessonsRange = queries.LessonsRange.merge(extended)
+	queries.LessonsPage = queries.LessonsPage.merge(extended)
+	queries.Snapshot = queries.Snapshot.merge(extended)
+
+	return queries
+}
+
+func (importer *LessonsImporter) newLessonMessage(event *ExtendedLessonEvent) (kafka.Message, error) {
+	version := LessonSchemaVersion
+	if importer.payloadVersion == ExtendedLessonSchemaVersion {
+		version = ExtendedLessonSchemaVersion
+		event.SchemaVersion = ExtendedLessonSchemaVersion
+	}
+
+	return importer.codec.message(events.LessonEventName, event, version)
+}
+
+func readExtraColumns(extraTargets map[string]*any) map[string]any {
+	if len(extraTargets) == 0 {
+		return nil
+	}
+
+	extra := make(map[string]any, len(extraTargets))
+	for column, target := range extraTargets {
+		if value, isBytes := (*target).([]byte); isBytes {
+			extra[column] = string(value)
+		} else {
+			extra[column] = *target
+		}
+	}
+
+	return extra
+}
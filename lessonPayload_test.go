@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWithExtendedPayload(t *testing.T) {
+	queries := withExtendedPayload(FirebirdDialect.queries, []string{"NUM_AUD"})
+
+	assert.Contains(t, queries.Lessons.build(), "as isDeleted, REGDATE, FSTATUS, NUM_AUD\nFROM T_PRJURN")
+	assert.Equal(t, "REGDATE", queries.Lessons.Columns["RegDate"])
+	assert.Equal(t, "FSTATUS", queries.Lessons.Columns["Status"])
+	assert.NotContains(t, FirebirdDialect.queries.Lessons.build(), "REGDATE, FSTATUS")
+	assert.NotContains(t, FirebirdDialect.queries.Lessons.Columns, "RegDate")
+}
+
+func TestNewLessonMessage(t *testing.T) {
+	event := ExtendedLessonEvent{
+		LessonEvent: events.LessonEvent{
+			Id:           10,
+			DisciplineId: 100,
+			TypeId:       1,
+			Date:         time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+			Year:         2022,
+			Semester:     2,
+		},
+		RegDate: time.Date(2023, 3, 4, 10, 0, 0, 0, time.UTC),
+		Status:  1,
+		Extra:   map[string]any{"NUM_AUD": "101"},
+	}
+
+	t.Run("current payload", func(t *testing.T) {
+		importer := LessonsImporter{payloadVersion: LessonSchemaVersion}
+
+		message, err := importer.newLessonMessage(&event)
+		assert.NoError(t, err)
+		expectedPayload, _ := json.Marshal(event.LessonEvent)
+
+		assert.Equal(t, events.LessonEventName, string(message.Key))
+		assert.Equal(t, expectedPayload, message.Value)
+		assert.Empty(t, message.Headers)
+	})
+
+	t.Run("extended payload is readable as LessonEvent", func(t *testing.T) {
+		importer := LessonsImporter{payloadVersion: ExtendedLessonSchemaVersion}
+
+		message, err := importer.newLessonMessage(&event)
+
+		assert.NoError(t, err)
+		assert.Equal(t, events.LessonEventName, string(message.Key))
+		assert.Equal(t, []kafka.Header{{Key: SchemaVersionHeader, Value: []byte("2")}}, message.Headers)
+
+		var currentEvent events.LessonEvent
+		assert.NoError(t, json.Unmarshal(message.Value, &currentEvent))
+		assert.Equal(t, event.LessonEvent, currentEvent)
+
+		var extendedEvent ExtendedLessonEvent
+		assert.NoError(t, json.Unmarshal(message.Value, &extendedEvent))
+		assert.Equal(t, ExtendedLessonSchemaVersion, extendedEvent.SchemaVersion)
+		assert.Equal(t, event.RegDate, extendedEvent.RegDate)
+		assert.Equal(t, 1, extendedEvent.Status)
+		assert.Equal(t, map[string]any{"NUM_AUD": "101"}, extendedEvent.Extra)
+	})
+
+	t.Run("marshal error", func(t *testing.T) {
+		importer := LessonsImporter{payloadVersion: ExtendedLessonSchemaVersion}
+		brokenEvent := event
+		brokenEvent.Extra = map[string]any{"NUM_AUD": math.NaN()}
+
+		_, err := importer.newLessonMessage(&brokenEvent)
+
+		assert.ErrorContains(t, err, "failed to encode LessonEvent: json: unsupported value: NaN")
+	})
+
+	t.Run("binary payload", func(t *testing.T) {
+		importer := LessonsImporter{
+			payloadVersion: LessonSchemaVersion,
+			codec:          newPayloadCodec(ProtobufPayloadEncoding, nil),
+		}
+
+		message, err := importer.newLessonMessage(&event)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []kafka.Header{
+			{Key: SchemaVersionHeader, Value: []byte("1")},
+			{Key: ContentTypeHeader, Value: []byte("application/x-protobuf")},
+		}, message.Headers)
+	})
+}
+
+func TestImportExtendedPayload(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	db, err := sql.Open("sqlite", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(sqliteSchema + `
+		ALTER TABLE T_PRJURN ADD COLUMN NUM_AUD TEXT;
+		UPDATE T_PRJURN SET NUM_AUD = '10' || ID;
+	`)
+	assert.NoError(t, err)
+
+	dialect := SqliteDialect.withQueries(withExtendedPayload(SqliteDialect.queries, []string{"NUM_AUD"}))
+	assert.NoError(t, validateQuerySet(db, dialect))
+
+	var actualEvents []ExtendedLessonEvent
+	writer := mocks.NewWriterInterface(t)
+	writer.On("WriteMessages", matchContext, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		for _, arg := range args[1:] {
+			var event ExtendedLessonEvent
+			assert.NoError(t, json.Unmarshal(arg.(kafka.Message).Value, &event))
+			actualEvents = append(actualEvents, event)
+		}
+	}).Return(nil).Once()
+
+	importer := LessonsImporter{
+		out:            &out,
+		db:             db,
+		dialect:        dialect,
+		writer:         writer,
+		writeThreshold: 10,
+		payloadVersion: ExtendedLessonSchemaVersion,
+	}
+
+	_, err = importer.execute(context.Background(),
+		time.Date(2023, 3, 6, 4, 0, 0, 0, time.UTC),
+		time.Date(2023, 3, 6, 4, 0, 0, 0, time.UTC),
+		2022,
+	)
+
+	assert.NoError(t, err)
+	assert.Len(t, actualEvents, 2)
+	assert.Equal(t, uint(11), actualEvents[0].Id)
+	assert.Equal(t, 0, actualEvents[0].Status)
+	assert.True(t, actualEvents[0].IsDeleted)
+	assert.True(t, time.Date(2023, 3, 5, 3, 0, 0, 0, time.UTC).Equal(actualEvents[0].RegDate))
+	assert.Equal(t, map[string]any{"NUM_AUD": "1011"}, actualEvents[0].Extra)
+	assert.Equal(t, 1, actualEvents[1].Status)
+}
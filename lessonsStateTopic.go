@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"strconv"
+	"strings"
+)
+
+const CleanupPolicyConfigName = "cleanup.policy"
+const CompactCleanupPolicy = "compact"
+
+type topicAdmin interface {
+	CreateTopics(ctx context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error)
+	DescribeConfigs(ctx context.Context, req *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error)
+}
+
+// LessonsStateWriter keeps the latest state of every lesson in a log-compacted topic keyed by lesson ID.
+// Deleted lessons are written as tombstones so compaction drops them.
+type LessonsStateWriter struct {
+	writer events.WriterInterface
+	codec  *PayloadCodec
+}
+
+func newLessonsStateWriter(config Config, codec *PayloadCodec) *LessonsStateWriter {
+	return &LessonsStateWriter{
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(config.kafkaHost),
+			Topic:    config.lessonsStateTopic,
+			Balancer: &kafka.Hash{},
+		},
+		codec: codec,
+	}
+}
+
+// messages encodes the state of each lesson as a LessonEvent of the first schema version, keyed by the lesson ID.
+func (stateWriter *LessonsStateWriter) messages(lessons []events.LessonEvent) (messages []kafka.Message, err error) {
+	messages = make([]kafka.Message, len(lessons))
+	for i, lesson := range lessons {
+		key := strconv.FormatUint(uint64(lesson.Id), 10)
+		if lesson.IsDeleted {
+			messages[i].Key = []byte(key)
+			continue
+		}
+
+		messages[i], err = stateWriter.codec.message(key, &ExtendedLessonEvent{LessonEvent: lesson}, LessonSchemaVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+func (stateWriter *LessonsStateWriter) Close() error {
+	return stateWriter.writer.Close()
+}
+
+// ensureCompactedTopic creates the topic with cleanup.policy=compact or checks that the existing topic is compacted.
+func ensureCompactedTopic(admin topicAdmin, topic string) error {
+	createResponse, err := admin.CreateTopics(context.Background(), &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{
+			{
+				Topic:             topic,
+				NumPartitions:     -1,
+				ReplicationFactor: -1,
+				ConfigEntries: []kafka.ConfigEntry{
+					{ConfigName: CleanupPolicyConfigName, ConfigValue: CompactCleanupPolicy},
+				},
+			},
+		},
+	})
+	if err == nil {
+		err = createResponse.Errors[topic]
+	}
+	if !errors.Is(err, kafka.TopicAlreadyExists) {
+		return err
+	}
+
+	describeResponse, err := admin.DescribeConfigs(context.Background(), &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{
+			{
+				ResourceType: kafka.ResourceTypeTopic,
+				ResourceName: topic,
+				ConfigNames:  []string{CleanupPolicyConfigName},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range describeResponse.Resources {
+		if resource.Error != nil {
+			return resource.Error
+		}
+		for _, entry := range resource.ConfigEntries {
+			if entry.ConfigName != CleanupPolicyConfigName {
+				continue
+			}
+			for _, policy := range strings.Split(entry.ConfigValue, ",") {
+				if strings.TrimSpace(policy) == CompactCleanupPolicy {
+					return nil
+				}
+			}
+			return errors.New("topic " + topic + " has " + CleanupPolicyConfigName + "=" + entry.ConfigValue + ", expected " + CompactCleanupPolicy)
+		}
+	}
+
+	return errors.New("topic " + topic + " has no " + CleanupPolicyConfigName + " config")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeTopicAdmin struct {
+	createErr      error
+	describeErr    error
+	cleanupPolicy  string
+	createRequests []*kafka.CreateTopicsRequest
+}
+
+func (admin *fakeTopicAdmin) CreateTopics(_ context.Context, req *kafka.CreateTopicsRequest) (*kafka.CreateTopicsResponse, error) {
+	admin.createRequests = append(admin.createRequests, req)
+	return &kafka.CreateTopicsResponse{
+		Errors: map[string]error{req.Topics[0].Topic: admin.createErr},
+	}, nil
+}
+
+func (admin *fakeTopicAdmin) DescribeConfigs(_ context.Context, req *kafka.DescribeConfigsRequest) (*kafka.DescribeConfigsResponse, error) {
+	if admin.describeErr != nil {
+		return nil, admin.describeErr
+	}
+
+	resource := kafka.DescribeConfigResponseResource{ResourceName: req.Resources[0].ResourceName}
+	if admin.cleanupPolicy != "" {
+		resource.ConfigEntries = []kafka.DescribeConfigResponseConfigEntry{
+			{ConfigName: CleanupPolicyConfigName, ConfigValue: admin.cleanupPolicy},
+		}
+	}
+
+	return &kafka.DescribeConfigsResponse{Resources: []kafka.DescribeConfigResponseResource{resource}}, nil
+}
+
+func TestEnsureCompactedTopic(t *testing.T) {
+	t.Run("create topic", func(t *testing.T) {
+		admin := &fakeTopicAdmin{}
+
+		assert.NoError(t, ensureCompactedTopic(admin, "lessons-state"))
+		assert.Len(t, admin.createRequests, 1)
+		assert.Equal(t, "lessons-state", admin.createRequests[0].Topics[0].Topic)
+		assert.Equal(
+			t, []kafka.ConfigEntry{{ConfigName: CleanupPolicyConfigName, ConfigValue: CompactCleanupPolicy}},
+			admin.createRequests[0].Topics[0].ConfigEntries,
+		)
+	})
+
+	t.Run("existing compacted topic", func(t *testing.T) {
+		admin := &fakeTopicAdmin{createErr: kafka.TopicAlreadyExists, cleanupPolicy: "compact,delete"}
+
+		assert.NoError(t, ensureCompactedTopic(admin, "lessons-state"))
+	})
+
+	t.Run("existing not compacted topic", func(t *testing.T) {
+		admin := &fakeTopicAdmin{createErr: kafka.TopicAlreadyExists, cleanupPolicy: "delete"}
+
+		err := ensureCompactedTopic(admin, "lessons-state")
+
+		assert.EqualError(t, err, "topic lessons-state has cleanup.policy=delete, expected compact")
+	})
+
+	t.Run("existing topic without policy", func(t *testing.T) {
+		admin := &fakeTopicAdmin{createErr: kafka.TopicAlreadyExists}
+
+		err := ensureCompactedTopic(admin, "lessons-state")
+
+		assert.EqualError(t, err, "topic lessons-state has no cleanup.policy config")
+	})
+
+	t.Run("create error", func(t *testing.T) {
+		admin := &fakeTopicAdmin{createErr: kafka.TopicAuthorizationFailed}
+
+		assert.ErrorIs(t, ensureCompactedTopic(admin, "lessons-state"), kafka.TopicAuthorizationFailed)
+	})
+
+	t.Run("describe error", func(t *testing.T) {
+		expectedError := errors.New("expected test error")
+		admin := &fakeTopicAdmin{createErr: kafka.TopicAlreadyExists, describeErr: expectedError}
+
+		assert.Equal(t, expectedError, ensureCompactedTopic(admin, "lessons-state"))
+	})
+}
+
+func TestLessonsStateWriter(t *testing.T) {
+	lesson := events.LessonEvent{
+		Id:           150,
+		DisciplineId: 200,
+		TypeId:       1,
+		Date:         time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+		Year:         2022,
+		Semester:     2,
+	}
+	deletedLesson := lesson
+	deletedLesson.Id = 151
+	deletedLesson.IsDeleted = true
+
+	t.Run("json", func(t *testing.T) {
+		expectedPayload, _ := json.Marshal(lesson)
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("Close").Return(nil).Once()
+
+		stateWriter := &LessonsStateWriter{writer: writer}
+		messages, err := stateWriter.messages([]events.LessonEvent{lesson, deletedLesson})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []kafka.Message{{Key: []byte("150"), Value: expectedPayload}, {Key: []byte("151")}}, messages)
+		assert.Nil(t, messages[1].Value)
+		assert.NoError(t, stateWriter.Close())
+	})
+
+	t.Run("payload encoding", func(t *testing.T) {
+		stateWriter := &LessonsStateWriter{codec: newPayloadCodec(ProtobufPayloadEncoding, nil)}
+		messages, err := stateWriter.messages([]events.LessonEvent{lesson, deletedLesson})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("150"), messages[0].Key)
+		assert.Equal(t, []any{uint64(150)}, decodeProtoFields(t, messages[0].Value)[1])
+		assert.Contains(t, messages[0].Headers, kafka.Header{Key: ContentTypeHeader, Value: []byte("application/x-protobuf")})
+		assert.Equal(t, kafka.Message{Key: []byte("151")}, messages[1])
+	})
+}
@@ -2,47 +2,107 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/kneu-messenger-pigeon/events"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
+	"time"
 )
 
 type MetaEventbusInterface interface {
-	sendSecondaryDbLessonProcessedEventName(originEvent events.SecondaryDbLoadedEvent) error
-	sendLessonTypesList(list []events.LessonType, year int) error
+	sendSecondaryDbLessonProcessedEventName(ctx context.Context, originEvent events.SecondaryDbLoadedEvent) error
+	sendLessonTypesList(ctx context.Context, list []events.LessonType, year int) error
+	sendLessonsSnapshotStartedEvent(ctx context.Context, year int, startedAt time.Time) error
+	sendLessonsSnapshotFinishedEvent(ctx context.Context, year int, lessonsCount int, startedAt time.Time) error
+	sendLessonsImportStaleEvent(lastImportAt time.Time, maxQuietPeriod time.Duration) error
 }
 
 type MetaEventbus struct {
-	writer events.WriterInterface
+	writer      events.WriterInterface
+	codec       *PayloadCodec
+	rateLimiter *RateLimiter
 }
 
-func (metaEventbus MetaEventbus) sendSecondaryDbLessonProcessedEventName(originEvent events.SecondaryDbLoadedEvent) error {
+func (metaEventbus MetaEventbus) sendSecondaryDbLessonProcessedEventName(ctx context.Context, originEvent events.SecondaryDbLoadedEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "send "+events.SecondaryDbLessonProcessedEventName, trace.WithSpanKind(trace.SpanKindProducer))
+	defer func() { endSpan(span, err) }()
+
 	event := events.SecondaryDbLessonProcessedEvent{
 		CurrentSecondaryDatabaseDatetime:  originEvent.CurrentSecondaryDatabaseDatetime,
 		PreviousSecondaryDatabaseDatetime: originEvent.PreviousSecondaryDatabaseDatetime,
 		Year:                              originEvent.Year,
 	}
-	payload, _ := json.Marshal(event)
+	message, err := metaEventbus.codec.message(events.SecondaryDbLessonProcessedEventName, event, LessonProcessedSchemaVersion)
+	if err != nil {
+		return err
+	}
 
-	return metaEventbus.writer.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(events.SecondaryDbLessonProcessedEventName),
-			Value: payload,
-		},
-	)
+	return metaEventbus.write(ctx, message)
 }
 
-func (metaEventbus MetaEventbus) sendLessonTypesList(list []events.LessonType, year int) error {
+func (metaEventbus MetaEventbus) sendLessonTypesList(ctx context.Context, list []events.LessonType, year int) error {
 	event := events.LessonTypesList{
 		Year: year,
 		List: list,
 	}
-	payload, _ := json.Marshal(event)
+	message, err := metaEventbus.codec.message(events.LessonTypesListName, event, LessonTypesListSchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	return metaEventbus.write(ctx, message)
+}
+
+func (metaEventbus MetaEventbus) sendLessonsSnapshotStartedEvent(ctx context.Context, year int, startedAt time.Time) error {
+	event := LessonsSnapshotStartedEvent{
+		Year:      year,
+		StartedAt: startedAt,
+	}
+	message, err := metaEventbus.codec.message(LessonsSnapshotStartedEventName, event, LessonsSnapshotStartedSchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	return metaEventbus.write(ctx, message)
+}
+
+func (metaEventbus MetaEventbus) sendLessonsSnapshotFinishedEvent(ctx context.Context, year int, lessonsCount int, startedAt time.Time) error {
+	event := LessonsSnapshotFinishedEvent{
+		Year:         year,
+		LessonsCount: lessonsCount,
+		StartedAt:    startedAt,
+		FinishedAt:   time.Now(),
+	}
+	message, err := metaEventbus.codec.message(LessonsSnapshotFinishedEventName, event, LessonsSnapshotFinishedSchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	return metaEventbus.write(ctx, message)
+}
+
+func (metaEventbus MetaEventbus) sendLessonsImportStaleEvent(lastImportAt time.Time, maxQuietPeriod time.Duration) (err error) {
+	ctx, span := tracer.Start(context.Background(), "send "+LessonsImportStaleEventName, trace.WithSpanKind(trace.SpanKindProducer))
+	defer func() { endSpan(span, err) }()
+
+	event := LessonsImportStaleEvent{
+		LastImportAt:   lastImportAt,
+		MaxQuietPeriod: maxQuietPeriod.String(),
+		DetectedAt:     time.Now(),
+	}
+	message, err := metaEventbus.codec.message(LessonsImportStaleEventName, event, LessonsImportStaleSchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	return metaEventbus.write(ctx, message)
+}
+
+func (metaEventbus MetaEventbus) write(ctx context.Context, message kafka.Message) error {
+	messages := []kafka.Message{message}
+	injectTraceContext(ctx, messages)
+	if _, err := metaEventbus.rateLimiter.wait(ctx, messages); err != nil {
+		return err
+	}
 
-	return metaEventbus.writer.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(events.LessonTypesListName),
-			Value: payload,
-		},
-	)
+	return metaEventbus.writer.WriteMessages(ctx, messages...)
 }
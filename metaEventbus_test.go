@@ -8,6 +8,7 @@ import (
 	"github.com/kneu-messenger-pigeon/events/mocks"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"testing"
 	"time"
 )
@@ -23,6 +24,7 @@ func TestSendSecondaryDbLessonProcessedEventName(t *testing.T) {
 	}
 
 	expectedError := errors.New("some error")
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
 
 	payload, _ := json.Marshal(events.SecondaryDbLessonProcessedEvent{
 		CurrentSecondaryDatabaseDatetime:  currentDatetime,
@@ -37,10 +39,10 @@ func TestSendSecondaryDbLessonProcessedEventName(t *testing.T) {
 
 	t.Run("Success send", func(t *testing.T) {
 		writer := mocks.NewWriterInterface(t)
-		writer.On("WriteMessages", context.Background(), expectedMessage).Return(nil)
+		writer.On("WriteMessages", matchContext, expectedMessage).Return(nil)
 
 		eventbus := MetaEventbus{writer: writer}
-		err := eventbus.sendSecondaryDbLessonProcessedEventName(origEvent)
+		err := eventbus.sendSecondaryDbLessonProcessedEventName(context.Background(), origEvent)
 
 		assert.NoErrorf(t, err, "Not expect for error")
 		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
@@ -48,10 +50,10 @@ func TestSendSecondaryDbLessonProcessedEventName(t *testing.T) {
 
 	t.Run("Failed send", func(t *testing.T) {
 		writer := mocks.NewWriterInterface(t)
-		writer.On("WriteMessages", context.Background(), expectedMessage).Return(expectedError)
+		writer.On("WriteMessages", matchContext, expectedMessage).Return(expectedError)
 
 		eventbus := MetaEventbus{writer: writer}
-		err := eventbus.sendSecondaryDbLessonProcessedEventName(origEvent)
+		err := eventbus.sendSecondaryDbLessonProcessedEventName(context.Background(), origEvent)
 
 		assert.Errorf(t, err, "Expect for error")
 		assert.Equal(t, expectedError, err, "Got unexpected error")
@@ -86,7 +88,7 @@ func TestSendLessonTypesList(t *testing.T) {
 		writer.On("WriteMessages", context.Background(), expectedMessage).Return(nil)
 
 		eventbus := MetaEventbus{writer: writer}
-		err := eventbus.sendLessonTypesList(lessonTypesList, expectedYear)
+		err := eventbus.sendLessonTypesList(context.Background(), lessonTypesList, expectedYear)
 
 		assert.NoErrorf(t, err, "Not expect for error")
 		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
@@ -97,10 +99,107 @@ func TestSendLessonTypesList(t *testing.T) {
 		writer.On("WriteMessages", context.Background(), expectedMessage).Return(expectedError)
 
 		eventbus := MetaEventbus{writer: writer}
-		err := eventbus.sendLessonTypesList(lessonTypesList, expectedYear)
+		err := eventbus.sendLessonTypesList(context.Background(), lessonTypesList, expectedYear)
 
 		assert.Errorf(t, err, "Expect for error")
 		assert.Equal(t, expectedError, err, "Got unexpected error")
 		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
 	})
+
+	t.Run("Throttled send", func(t *testing.T) {
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", context.Background(), expectedMessage).Return(nil).Twice()
+
+		limiter, sleeps := newTestRateLimiter(1, 0)
+		eventbus := MetaEventbus{writer: writer, rateLimiter: limiter}
+		assert.NoError(t, eventbus.sendLessonTypesList(context.Background(), lessonTypesList, expectedYear))
+		assert.NoError(t, eventbus.sendLessonTypesList(context.Background(), lessonTypesList, expectedYear))
+
+		assert.Equal(t, []time.Duration{time.Second}, *sleeps)
+	})
+}
+
+func TestSendLessonsSnapshotEvents(t *testing.T) {
+	expectedError := errors.New("some error")
+	expectedYear := 2023
+	startedAt := time.Date(2023, 9, 2, 4, 0, 0, 0, time.UTC)
+
+	t.Run("Send started", func(t *testing.T) {
+		payload, _ := json.Marshal(LessonsSnapshotStartedEvent{
+			Year:      expectedYear,
+			StartedAt: startedAt,
+		})
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", context.Background(), kafka.Message{
+			Key:   []byte(LessonsSnapshotStartedEventName),
+			Value: payload,
+		}).Return(expectedError)
+
+		eventbus := MetaEventbus{writer: writer}
+		err := eventbus.sendLessonsSnapshotStartedEvent(context.Background(), expectedYear, startedAt)
+
+		assert.Equal(t, expectedError, err)
+		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
+	})
+
+	t.Run("Send started with payload encoding", func(t *testing.T) {
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", context.Background(), mock.MatchedBy(func(message kafka.Message) bool {
+			return assert.Equal(t, LessonsSnapshotStartedEventName, string(message.Key)) &&
+				assert.Equal(t, []any{uint64(expectedYear)}, decodeProtoFields(t, message.Value)[1]) &&
+				assert.Contains(t, message.Headers, kafka.Header{Key: ContentTypeHeader, Value: []byte("application/x-protobuf")})
+		})).Return(nil)
+
+		eventbus := MetaEventbus{writer: writer, codec: newPayloadCodec(ProtobufPayloadEncoding, nil)}
+		err := eventbus.sendLessonsSnapshotStartedEvent(context.Background(), expectedYear, startedAt)
+
+		assert.NoError(t, err)
+		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
+	})
+
+	t.Run("Send finished", func(t *testing.T) {
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", context.Background(), mock.MatchedBy(func(message kafka.Message) bool {
+			var event LessonsSnapshotFinishedEvent
+			err := json.Unmarshal(message.Value, &event)
+
+			return assert.Equal(t, LessonsSnapshotFinishedEventName, string(message.Key)) &&
+				assert.NoError(t, err) &&
+				assert.Equal(t, expectedYear, event.Year) &&
+				assert.Equal(t, 1500, event.LessonsCount) &&
+				assert.Equal(t, startedAt, event.StartedAt) &&
+				assert.False(t, event.FinishedAt.IsZero())
+		})).Return(nil)
+
+		eventbus := MetaEventbus{writer: writer}
+		err := eventbus.sendLessonsSnapshotFinishedEvent(context.Background(), expectedYear, 1500, startedAt)
+
+		assert.NoError(t, err)
+		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
+	})
+}
+
+func TestSendLessonsImportStaleEvent(t *testing.T) {
+	lastImportAt := time.Date(2023, 9, 2, 4, 0, 0, 0, time.UTC)
+
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	writer := mocks.NewWriterInterface(t)
+	writer.On("WriteMessages", matchContext, mock.MatchedBy(func(message kafka.Message) bool {
+		var event LessonsImportStaleEvent
+		err := json.Unmarshal(message.Value, &event)
+
+		return assert.Equal(t, LessonsImportStaleEventName, string(message.Key)) &&
+			assert.NoError(t, err) &&
+			assert.Equal(t, lastImportAt, event.LastImportAt) &&
+			assert.Equal(t, "6h0m0s", event.MaxQuietPeriod) &&
+			assert.False(t, event.DetectedAt.IsZero())
+	})).Return(nil)
+
+	eventbus := MetaEventbus{writer: writer}
+	err := eventbus.sendLessonsImportStaleEvent(lastImportAt, 6*time.Hour)
+
+	assert.NoError(t, err)
+	writer.AssertNumberOfCalls(t, "WriteMessages", 1)
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var spoolDepth = expvar.NewMap("spool_depth")
+var secondsSinceLastImport = expvar.NewFloat("seconds_since_last_import")
+var writeThrottledSeconds = expvar.NewFloat("kafka_write_throttled_seconds")
+var consumptionPaused = expvar.NewInt("consumption_paused")
+var yearLockContentions = expvar.NewInt("year_lock_contentions")
+
+func newMetricsServer(addr string, readiness func() error) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", expvar.Handler())
+	mux.HandleFunc("/readyz", func(writer http.ResponseWriter, request *http.Request) {
+		if readiness != nil {
+			if err := readiness(); err != nil {
+				http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		_, _ = writer.Write([]byte("ready\n"))
+	})
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
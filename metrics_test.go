@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsServer(t *testing.T) {
+	t.Run("expose expvar metrics", func(t *testing.T) {
+		server := newMetricsServer(":0", nil)
+
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		assert.Equal(t, 200, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "\"spool_depth\"")
+		assert.Contains(t, recorder.Body.String(), "\"seconds_since_last_import\"")
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		server := newMetricsServer(":0", func() error { return nil })
+
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, 200, recorder.Code)
+		assert.Equal(t, "ready\n", recorder.Body.String())
+	})
+
+	t.Run("degraded", func(t *testing.T) {
+		server := newMetricsServer(":0", func() error { return errors.New("degraded: stale") })
+
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, 503, recorder.Code)
+		assert.Equal(t, "degraded: stale\n", recorder.Body.String())
+	})
+}
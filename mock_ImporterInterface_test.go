@@ -3,6 +3,8 @@
 package main
 
 import (
+	context "context"
+
 	events "github.com/kneu-messenger-pigeon/events"
 	mock "github.com/stretchr/testify/mock"
 
@@ -14,13 +16,13 @@ type MockImporterInterface struct {
 	mock.Mock
 }
 
-// execute provides a mock function with given fields: startDatetime, endDatetime, year
-func (_m *MockImporterInterface) execute(startDatetime time.Time, endDatetime time.Time, year int) error {
-	ret := _m.Called(startDatetime, endDatetime, year)
+// beginReadTransaction provides a mock function with given fields:
+func (_m *MockImporterInterface) beginReadTransaction() error {
+	ret := _m.Called()
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(time.Time, time.Time, int) error); ok {
-		r0 = rf(startDatetime, endDatetime, year)
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -28,13 +30,48 @@ func (_m *MockImporterInterface) execute(startDatetime time.Time, endDatetime ti
 	return r0
 }
 
-// importLessonsType provides a mock function with given fields:
-func (_m *MockImporterInterface) importLessonTypes() ([]events.LessonType, error) {
+// endReadTransaction provides a mock function with given fields:
+func (_m *MockImporterInterface) endReadTransaction() error {
 	ret := _m.Called()
 
-	var r0 []events.LessonType
-	if rf, ok := ret.Get(0).(func() []events.LessonType); ok {
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
 		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// execute provides a mock function with given fields: ctx, startDatetime, endDatetime, year
+func (_m *MockImporterInterface) execute(ctx context.Context, startDatetime time.Time, endDatetime time.Time, year int) (ImportSummary, error) {
+	ret := _m.Called(ctx, startDatetime, endDatetime, year)
+
+	var r0 ImportSummary
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, time.Time, int) ImportSummary); ok {
+		r0 = rf(ctx, startDatetime, endDatetime, year)
+	} else {
+		r0 = ret.Get(0).(ImportSummary)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, time.Time, int) error); ok {
+		r1 = rf(ctx, startDatetime, endDatetime, year)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// importLessonTypes provides a mock function with given fields: ctx
+func (_m *MockImporterInterface) importLessonTypes(ctx context.Context) ([]events.LessonType, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []events.LessonType
+	if rf, ok := ret.Get(0).(func(context.Context) []events.LessonType); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]events.LessonType)
@@ -42,8 +79,50 @@ func (_m *MockImporterInterface) importLessonTypes() ([]events.LessonType, error
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// reconcile provides a mock function with given fields: ctx, year
+func (_m *MockImporterInterface) reconcile(ctx context.Context, year int) (int, error) {
+	ret := _m.Called(ctx, year)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, int) int); ok {
+		r0 = rf(ctx, year)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, year)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// snapshot provides a mock function with given fields: ctx, year
+func (_m *MockImporterInterface) snapshot(ctx context.Context, year int) (int, error) {
+	ret := _m.Called(ctx, year)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, int) int); ok {
+		r0 = rf(ctx, year)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, year)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -3,8 +3,12 @@
 package main
 
 import (
+	context "context"
+
 	events "github.com/kneu-messenger-pigeon/events"
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // MockMetaEventbusInterface is an autogenerated mock type for the MetaEventbusInterface type
@@ -12,13 +16,55 @@ type MockMetaEventbusInterface struct {
 	mock.Mock
 }
 
-// sendLessonTypesList provides a mock function with given fields: list, year
-func (_m *MockMetaEventbusInterface) sendLessonTypesList(list []events.LessonType, year int) error {
-	ret := _m.Called(list, year)
+// sendLessonTypesList provides a mock function with given fields: ctx, list, year
+func (_m *MockMetaEventbusInterface) sendLessonTypesList(ctx context.Context, list []events.LessonType, year int) error {
+	ret := _m.Called(ctx, list, year)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []events.LessonType, int) error); ok {
+		r0 = rf(ctx, list, year)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// sendLessonsImportStaleEvent provides a mock function with given fields: lastImportAt, maxQuietPeriod
+func (_m *MockMetaEventbusInterface) sendLessonsImportStaleEvent(lastImportAt time.Time, maxQuietPeriod time.Duration) error {
+	ret := _m.Called(lastImportAt, maxQuietPeriod)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(time.Time, time.Duration) error); ok {
+		r0 = rf(lastImportAt, maxQuietPeriod)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// sendLessonsSnapshotFinishedEvent provides a mock function with given fields: ctx, year, lessonsCount, startedAt
+func (_m *MockMetaEventbusInterface) sendLessonsSnapshotFinishedEvent(ctx context.Context, year int, lessonsCount int, startedAt time.Time) error {
+	ret := _m.Called(ctx, year, lessonsCount, startedAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, time.Time) error); ok {
+		r0 = rf(ctx, year, lessonsCount, startedAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// sendLessonsSnapshotStartedEvent provides a mock function with given fields: ctx, year, startedAt
+func (_m *MockMetaEventbusInterface) sendLessonsSnapshotStartedEvent(ctx context.Context, year int, startedAt time.Time) error {
+	ret := _m.Called(ctx, year, startedAt)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]events.LessonType, int) error); ok {
-		r0 = rf(list, year)
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) error); ok {
+		r0 = rf(ctx, year, startedAt)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -26,13 +72,13 @@ func (_m *MockMetaEventbusInterface) sendLessonTypesList(list []events.LessonTyp
 	return r0
 }
 
-// sendSecondaryDbLessonProcessedEventName provides a mock function with given fields: originEvent
-func (_m *MockMetaEventbusInterface) sendSecondaryDbLessonProcessedEventName(originEvent events.SecondaryDbLoadedEvent) error {
-	ret := _m.Called(originEvent)
+// sendSecondaryDbLessonProcessedEventName provides a mock function with given fields: ctx, originEvent
+func (_m *MockMetaEventbusInterface) sendSecondaryDbLessonProcessedEventName(ctx context.Context, originEvent events.SecondaryDbLoadedEvent) error {
+	ret := _m.Called(ctx, originEvent)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(events.SecondaryDbLoadedEvent) error); ok {
-		r0 = rf(originEvent)
+	if rf, ok := ret.Get(0).(func(context.Context, events.SecondaryDbLoadedEvent) error); ok {
+		r0 = rf(ctx, originEvent)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"go.etcd.io/bbolt"
+	"io"
+	"sync"
+	"time"
+)
+
+const PauseLessonsImportEventName = "PauseLessonsImportEvent"
+const ResumeLessonsImportEventName = "ResumeLessonsImportEvent"
+
+// ControlGroupId prefixes the control consumer groups, which are separate from the event loop group,
+// so control messages are read while the event loop is paused.
+const ControlGroupId = "secondary-db-lessons-importer-control"
+
+// controlGroupId is the group of this instance: every instance must read each pause and resume, while a shared group
+// would hand every message to one of them. The group must outlive restarts to get the messages sent while
+// the instance was down, so it comes from the configured INSTANCE_ID, e.g. a StatefulSet pod name, not from
+// the hostname of a pod. A single instance without INSTANCE_ID takes the plain group.
+func controlGroupId(instanceId string) string {
+	if instanceId == "" {
+		return ControlGroupId
+	}
+
+	return ControlGroupId + "-" + instanceId
+}
+
+var controlBucket = []byte("control")
+var pauseStateKey = []byte("pause")
+
+type PauseLessonsImportEvent struct {
+	Reason string
+}
+
+type PauseState struct {
+	Paused    bool
+	Reason    string `json:",omitempty"`
+	Source    string `json:",omitempty"`
+	ChangedAt time.Time
+}
+
+type PauseStoreInterface interface {
+	load() (PauseState, error)
+	save(state PauseState) error
+}
+
+type PauseStore struct {
+	db *bbolt.DB
+}
+
+func (store *PauseStore) load() (state PauseState, err error) {
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(controlBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get(pauseStateKey)
+		if value == nil {
+			return nil
+		}
+
+		return json.Unmarshal(value, &state)
+	})
+
+	return
+}
+
+func (store *PauseStore) save(state PauseState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(controlBucket)
+		if err == nil {
+			err = bucket.Put(pauseStateKey, value)
+		}
+
+		return err
+	})
+}
+
+// PauseControl gates the event loop: while paused, wait blocks until resume. Without a store the state
+// lives only until restart.
+type PauseControl struct {
+	out     io.Writer
+	store   PauseStoreInterface
+	mutex   sync.Mutex
+	state   PauseState
+	resumed chan struct{}
+}
+
+func newPauseControl(out io.Writer, store PauseStoreInterface) (*PauseControl, error) {
+	control := &PauseControl{
+		out:   out,
+		store: store,
+	}
+
+	if store != nil {
+		state, err := store.load()
+		if err != nil {
+			return nil, err
+		}
+		control.apply(state)
+	} else {
+		fmt.Fprintf(out, "WARNING: STATE_DIR is not set, a pause is kept in memory only and is lost on restart\n")
+	}
+
+	if control.state.Paused {
+		fmt.Fprintf(out, "Consumption is paused since %s by %s: %s\n", control.state.ChangedAt.Format(dateFormat), control.state.Source, control.state.Reason)
+	}
+
+	return control, nil
+}
+
+func (control *PauseControl) pause(reason string, source string) error {
+	return control.change(PauseState{Paused: true, Reason: reason, Source: source, ChangedAt: time.Now()})
+}
+
+func (control *PauseControl) resume(source string) error {
+	return control.change(PauseState{Paused: false, Source: source, ChangedAt: time.Now()})
+}
+
+func (control *PauseControl) change(state PauseState) error {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.store != nil {
+		if err := control.store.save(state); err != nil {
+			return err
+		}
+	}
+	control.apply(state)
+
+	if state.Paused {
+		fmt.Fprintf(control.out, "Consumption paused by %s: %s\n", state.Source, state.Reason)
+	} else {
+		fmt.Fprintf(control.out, "Consumption resumed by %s\n", state.Source)
+	}
+
+	return nil
+}
+
+// apply expects the mutex to be held, except during construction.
+func (control *PauseControl) apply(state PauseState) {
+	if state.Paused && control.resumed == nil {
+		control.resumed = make(chan struct{})
+	}
+	if !state.Paused && control.resumed != nil {
+		close(control.resumed)
+		control.resumed = nil
+	}
+
+	control.state = state
+	if state.Paused {
+		consumptionPaused.Set(1)
+	} else {
+		consumptionPaused.Set(0)
+	}
+}
+
+func (control *PauseControl) status() PauseState {
+	if control == nil {
+		return PauseState{}
+	}
+
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.state
+}
+
+// wait returns at once when consumption is not paused, otherwise on resume or when ctx is done.
+func (control *PauseControl) wait(ctx context.Context) error {
+	if control == nil {
+		return nil
+	}
+
+	control.mutex.Lock()
+	resumed := control.resumed
+	control.mutex.Unlock()
+
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// listen applies pause and resume messages from the meta topic until the reader fails or is closed.
+func (control *PauseControl) listen(reader events.ReaderInterface) error {
+	for {
+		m, err := reader.FetchMessage(context.Background())
+		if err != nil {
+			return err
+		}
+
+		switch string(m.Key) {
+		case PauseLessonsImportEventName:
+			var event PauseLessonsImportEvent
+			// a pause is applied even without its reason: resuming by mistake is worse
+			if decodeErr := json.Unmarshal(m.Value, &event); decodeErr != nil {
+				fmt.Fprintf(control.out, "Failed to decode %s at offset %d: %v\n", PauseLessonsImportEventName, m.Offset, decodeErr)
+			}
+			err = control.pause(event.Reason, MetaEventRunTrigger)
+		case ResumeLessonsImportEventName:
+			err = control.resume(MetaEventRunTrigger)
+		}
+
+		if err == nil {
+			err = reader.CommitMessages(context.Background(), m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
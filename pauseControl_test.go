@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseControl(t *testing.T) {
+	t.Run("persist across restarts", func(t *testing.T) {
+		var out bytes.Buffer
+		stateDb, err := openStateDb(t.TempDir())
+		assert.NoError(t, err)
+		defer stateDb.Close()
+
+		control, err := newPauseControl(&out, &PauseStore{db: stateDb})
+		assert.NoError(t, err)
+		assert.False(t, control.status().Paused)
+
+		assert.NoError(t, control.pause("Dekanat maintenance", AdminRunTrigger))
+		assert.Equal(t, "1", consumptionPaused.String())
+
+		restarted, err := newPauseControl(&out, &PauseStore{db: stateDb})
+		assert.NoError(t, err)
+		assert.True(t, restarted.status().Paused)
+		assert.Equal(t, "Dekanat maintenance", restarted.status().Reason)
+		assert.Contains(t, out.String(), "Consumption is paused since ")
+		assert.NotContains(t, out.String(), "STATE_DIR is not set")
+
+		assert.NoError(t, restarted.resume(AdminRunTrigger))
+		assert.Equal(t, "0", consumptionPaused.String())
+
+		restarted, err = newPauseControl(&out, &PauseStore{db: stateDb})
+		assert.NoError(t, err)
+		assert.False(t, restarted.status().Paused)
+		assert.Equal(t, AdminRunTrigger, restarted.status().Source)
+	})
+
+	t.Run("wait until resume", func(t *testing.T) {
+		control, err := newPauseControl(io.Discard, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, control.wait(context.Background()))
+
+		assert.NoError(t, control.pause("incident", AdminRunTrigger))
+		assert.NoError(t, control.pause("still incident", AdminRunTrigger))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, control.wait(ctx))
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = control.resume(AdminRunTrigger)
+		}()
+		assert.NoError(t, control.wait(context.Background()))
+	})
+
+	t.Run("nil control never pauses", func(t *testing.T) {
+		var control *PauseControl
+		assert.NoError(t, control.wait(context.Background()))
+		assert.False(t, control.status().Paused)
+	})
+
+	t.Run("listen control messages", func(t *testing.T) {
+		var out bytes.Buffer
+		control, err := newPauseControl(&out, nil)
+		assert.NoError(t, err)
+
+		payload, _ := json.Marshal(PauseLessonsImportEvent{Reason: "downstream incident"})
+		pauseMessage := kafka.Message{Key: []byte(PauseLessonsImportEventName), Value: payload}
+		resumeMessage := kafka.Message{Key: []byte(ResumeLessonsImportEventName), Value: []byte("{}")}
+		otherMessage := kafka.Message{Key: []byte(LessonsSnapshotRequestedEventName), Value: []byte("{}")}
+
+		matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+		reader := mocks.NewReaderInterface(t)
+		reader.On("FetchMessage", matchContext).Return(pauseMessage, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(otherMessage, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(resumeMessage, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, io.EOF)
+		reader.On("CommitMessages", matchContext, mock.Anything).Return(nil).Times(3)
+
+		assert.Equal(t, io.EOF, control.listen(reader))
+		assert.False(t, control.status().Paused)
+		assert.Equal(t, MetaEventRunTrigger, control.status().Source)
+		assert.Contains(t, out.String(), "WARNING: STATE_DIR is not set, a pause is kept in memory only and is lost on restart\n")
+		assert.Contains(t, out.String(), "Consumption paused by meta-event: downstream incident\n")
+		assert.Contains(t, out.String(), "Consumption resumed by meta-event\n")
+	})
+
+	t.Run("listen broken pause message", func(t *testing.T) {
+		var out bytes.Buffer
+		control, err := newPauseControl(&out, nil)
+		assert.NoError(t, err)
+
+		pauseMessage := kafka.Message{Key: []byte(PauseLessonsImportEventName), Value: []byte("{broken"), Offset: 7}
+
+		matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+		reader := mocks.NewReaderInterface(t)
+		reader.On("FetchMessage", matchContext).Return(pauseMessage, nil).Once()
+		reader.On("FetchMessage", matchContext).Return(kafka.Message{}, io.EOF)
+		reader.On("CommitMessages", matchContext, mock.Anything).Return(nil).Once()
+
+		assert.Equal(t, io.EOF, control.listen(reader))
+		assert.True(t, control.status().Paused)
+		assert.Contains(t, out.String(), "Failed to decode PauseLessonsImportEvent at offset 7: invalid character")
+	})
+
+	t.Run("control group per instance", func(t *testing.T) {
+		assert.Equal(t, ControlGroupId+"-importer-1", controlGroupId("importer-1"))
+		assert.Equal(t, ControlGroupId, controlGroupId(""))
+	})
+}
+
+func TestEventLoopPaused(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	breakLoopError := errors.New("breakLoop")
+
+	control, err := newPauseControl(io.Discard, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, control.pause("maintenance", AdminRunTrigger))
+
+	var fetched atomic.Bool
+	reader := mocks.NewReaderInterface(t)
+	reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError).Run(func(args mock.Arguments) {
+		fetched.Store(true)
+	})
+
+	eventLoop := EventLoop{
+		out:    io.Discard,
+		reader: reader,
+		pause:  control,
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- eventLoop.execute(context.Background())
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, fetched.Load())
+
+	assert.NoError(t, control.resume(AdminRunTrigger))
+	assert.Equal(t, breakLoopError, <-result)
+	assert.True(t, fetched.Load())
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hamba/avro/v2"
+	"github.com/kneu-messenger-pigeon/events"
+)
+
+const AvroLessonEventSchema = `{
+  "type": "record", "name": "LessonEvent",
+  "fields": [
+    {"name": "Id", "type": "long"},
+    {"name": "DisciplineId", "type": "long"},
+    {"name": "TypeId", "type": "int"},
+    {"name": "Date", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "Year", "type": "int"},
+    {"name": "Semester", "type": "int"},
+    {"name": "IsDeleted", "type": "boolean"}
+  ]
+}`
+
+const AvroExtendedLessonEventSchema = `{
+  "type": "record", "name": "LessonEvent",
+  "fields": [
+    {"name": "Id", "type": "long"},
+    {"name": "DisciplineId", "type": "long"},
+    {"name": "TypeId", "type": "int"},
+    {"name": "Date", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "Year", "type": "int"},
+    {"name": "Semester", "type": "int"},
+    {"name": "IsDeleted", "type": "boolean"},
+    {"name": "SchemaVersion", "type": "int"},
+    {"name": "RegDate", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "Status", "type": "int"},
+    {"name": "Extra", "type": {"type": "map", "values": "string"}}
+  ]
+}`
+
+const AvroLessonTypesListSchema = `{
+  "type": "record", "name": "LessonTypesList",
+  "fields": [
+    {"name": "Year", "type": "int"},
+    {"name": "List", "type": {"type": "array", "items": {
+      "type": "record", "name": "LessonType",
+      "fields": [
+        {"name": "id", "type": "int"},
+        {"name": "shortName", "type": "string"},
+        {"name": "longName", "type": "string"}
+      ]
+    }}}
+  ]
+}`
+
+const AvroLessonProcessedSchema = `{
+  "type": "record", "name": "SecondaryDbLessonProcessedEvent",
+  "fields": [
+    {"name": "Year", "type": "int"},
+    {"name": "CurrentSecondaryDatabaseDatetime", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "PreviousSecondaryDatabaseDatetime", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+  ]
+}`
+
+const AvroLessonsSnapshotStartedSchema = `{
+  "type": "record", "name": "LessonsSnapshotStartedEvent",
+  "fields": [
+    {"name": "Year", "type": "int"},
+    {"name": "StartedAt", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+  ]
+}`
+
+const AvroLessonsSnapshotFinishedSchema = `{
+  "type": "record", "name": "LessonsSnapshotFinishedEvent",
+  "fields": [
+    {"name": "Year", "type": "int"},
+    {"name": "LessonsCount", "type": "int"},
+    {"name": "StartedAt", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "FinishedAt", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+  ]
+}`
+
+const AvroLessonsImportStaleSchema = `{
+  "type": "record", "name": "LessonsImportStaleEvent",
+  "fields": [
+    {"name": "LastImportAt", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "MaxQuietPeriod", "type": "string"},
+    {"name": "DetectedAt", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+  ]
+}`
+
+var avroSchemas = map[string]avro.Schema{
+	"LessonEvent/1":                     avro.MustParse(AvroLessonEventSchema),
+	"LessonEvent/2":                     avro.MustParse(AvroExtendedLessonEventSchema),
+	"LessonTypesList/1":                 avro.MustParse(AvroLessonTypesListSchema),
+	"SecondaryDbLessonProcessedEvent/1": avro.MustParse(AvroLessonProcessedSchema),
+	"LessonsSnapshotStartedEvent/1":     avro.MustParse(AvroLessonsSnapshotStartedSchema),
+	"LessonsSnapshotFinishedEvent/1":    avro.MustParse(AvroLessonsSnapshotFinishedSchema),
+	"LessonsImportStaleEvent/1":         avro.MustParse(AvroLessonsImportStaleSchema),
+}
+
+type AvroEncoder struct{}
+
+func (encoder AvroEncoder) encode(value any, version int) ([]byte, error) {
+	subject := payloadSubject(value)
+	schema, exists := avroSchemas[fmt.Sprintf("%s/%d", subject, version)]
+	if !exists {
+		return nil, unsupportedSchema(subject, version)
+	}
+
+	var record map[string]any
+	switch event := value.(type) {
+	case *ExtendedLessonEvent:
+		record = map[string]any{
+			"Id":           int64(event.Id),
+			"DisciplineId": int64(event.DisciplineId),
+			"TypeId":       int(event.TypeId),
+			"Date":         event.Date,
+			"Year":         event.Year,
+			"Semester":     int(event.Semester),
+			"IsDeleted":    event.IsDeleted,
+		}
+		if version == ExtendedLessonSchemaVersion {
+			// a NULL column is left out of the map
+			extra := make(map[string]any, len(event.Extra))
+			for column, columnValue := range event.Extra {
+				if columnValue != nil {
+					extra[column] = fmt.Sprint(columnValue)
+				}
+			}
+			record["SchemaVersion"] = event.SchemaVersion
+			record["RegDate"] = event.RegDate
+			record["Status"] = event.Status
+			record["Extra"] = extra
+		}
+
+	case events.LessonTypesList:
+		list := make([]any, len(event.List))
+		for i, lessonType := range event.List {
+			list[i] = map[string]any{
+				"id":        lessonType.Id,
+				"shortName": lessonType.ShortName,
+				"longName":  lessonType.LongName,
+			}
+		}
+		record = map[string]any{"Year": event.Year, "List": list}
+
+	case events.SecondaryDbLessonProcessedEvent:
+		record = map[string]any{
+			"Year":                              event.Year,
+			"CurrentSecondaryDatabaseDatetime":  event.CurrentSecondaryDatabaseDatetime,
+			"PreviousSecondaryDatabaseDatetime": event.PreviousSecondaryDatabaseDatetime,
+		}
+
+	case LessonsSnapshotStartedEvent:
+		record = map[string]any{"Year": event.Year, "StartedAt": event.StartedAt}
+
+	case LessonsSnapshotFinishedEvent:
+		record = map[string]any{
+			"Year":         event.Year,
+			"LessonsCount": event.LessonsCount,
+			"StartedAt":    event.StartedAt,
+			"FinishedAt":   event.FinishedAt,
+		}
+
+	case LessonsImportStaleEvent:
+		record = map[string]any{
+			"LastImportAt":   event.LastImportAt,
+			"MaxQuietPeriod": event.MaxQuietPeriod,
+			"DetectedAt":     event.DetectedAt,
+		}
+	}
+
+	return avro.Marshal(schema, record)
+}
+
+func (encoder AvroEncoder) messageIndexes() []byte {
+	return nil
+}
+
+func (encoder AvroEncoder) contentType() string {
+	return "avro/binary"
+}
+
+func (encoder AvroEncoder) schemaType() string {
+	return "AVRO"
+}
+
+func (encoder AvroEncoder) schema(subject string, version int) (string, error) {
+	if schema, exists := avroSchemas[fmt.Sprintf("%s/%d", subject, version)]; exists {
+		return schema.String(), nil
+	}
+
+	return "", unsupportedSchema(subject, version)
+}
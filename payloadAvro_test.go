@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/hamba/avro/v2"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAvroEncoder(t *testing.T) {
+	encoder := AvroEncoder{}
+	date := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	lesson := &ExtendedLessonEvent{
+		LessonEvent: events.LessonEvent{
+			Id:           10,
+			DisciplineId: 100,
+			TypeId:       1,
+			Date:         date,
+			Year:         2022,
+			Semester:     2,
+		},
+		SchemaVersion: ExtendedLessonSchemaVersion,
+		RegDate:       date.Add(time.Hour),
+		Status:        1,
+		Extra:         map[string]any{"NUM_AUD": 101, "NOTE": nil},
+	}
+
+	t.Run("extended lesson", func(t *testing.T) {
+		payload, err := encoder.encode(lesson, ExtendedLessonSchemaVersion)
+		assert.NoError(t, err)
+
+		var decoded map[string]any
+		assert.NoError(t, avro.Unmarshal(avroSchemas["LessonEvent/2"], payload, &decoded))
+		assert.Equal(t, int64(10), decoded["Id"])
+		assert.Equal(t, date, decoded["Date"])
+		assert.Equal(t, 2022, decoded["Year"])
+		assert.Equal(t, false, decoded["IsDeleted"])
+		assert.Equal(t, date.Add(time.Hour), decoded["RegDate"])
+		assert.Equal(t, map[string]any{"NUM_AUD": "101"}, decoded["Extra"])
+	})
+
+	t.Run("lesson is readable with the first schema", func(t *testing.T) {
+		payload, err := encoder.encode(lesson, LessonSchemaVersion)
+		assert.NoError(t, err)
+
+		var decoded map[string]any
+		assert.NoError(t, avro.Unmarshal(avroSchemas["LessonEvent/1"], payload, &decoded))
+		assert.Equal(t, int64(100), decoded["DisciplineId"])
+		assert.NotContains(t, decoded, "Extra")
+	})
+
+	t.Run("processed event", func(t *testing.T) {
+		payload, err := encoder.encode(events.SecondaryDbLessonProcessedEvent{
+			Year:                              2023,
+			CurrentSecondaryDatabaseDatetime:  date,
+			PreviousSecondaryDatabaseDatetime: date.Add(-24 * time.Hour),
+		}, LessonProcessedSchemaVersion)
+		assert.NoError(t, err)
+
+		var decoded map[string]any
+		assert.NoError(t, avro.Unmarshal(avroSchemas["SecondaryDbLessonProcessedEvent/1"], payload, &decoded))
+		assert.Equal(t, date, decoded["CurrentSecondaryDatabaseDatetime"])
+	})
+
+	t.Run("lesson types list", func(t *testing.T) {
+		payload, err := encoder.encode(events.LessonTypesList{
+			Year: 2023,
+			List: []events.LessonType{{Id: 1, ShortName: "Лек", LongName: "Лекція"}},
+		}, LessonTypesListSchemaVersion)
+		assert.NoError(t, err)
+
+		var decoded events.LessonTypesList
+		assert.NoError(t, avro.Unmarshal(avroSchemas["LessonTypesList/1"], payload, &decoded))
+		assert.Equal(t, 2023, decoded.Year)
+	})
+	t.Run("snapshot finished event", func(t *testing.T) {
+		payload, err := encoder.encode(LessonsSnapshotFinishedEvent{
+			Year:         2023,
+			LessonsCount: 42,
+			StartedAt:    date,
+			FinishedAt:   date.Add(time.Minute),
+		}, LessonsSnapshotFinishedSchemaVersion)
+		assert.NoError(t, err)
+
+		var decoded map[string]any
+		assert.NoError(t, avro.Unmarshal(avroSchemas["LessonsSnapshotFinishedEvent/1"], payload, &decoded))
+		assert.Equal(t, 42, decoded["LessonsCount"])
+		assert.Equal(t, date.Add(time.Minute), decoded["FinishedAt"])
+	})
+
+	t.Run("import stale event", func(t *testing.T) {
+		payload, err := encoder.encode(LessonsImportStaleEvent{
+			LastImportAt:   date,
+			MaxQuietPeriod: "6h0m0s",
+			DetectedAt:     date.Add(7 * time.Hour),
+		}, LessonsImportStaleSchemaVersion)
+		assert.NoError(t, err)
+
+		var decoded map[string]any
+		assert.NoError(t, avro.Unmarshal(avroSchemas["LessonsImportStaleEvent/1"], payload, &decoded))
+		assert.Equal(t, "6h0m0s", decoded["MaxQuietPeriod"])
+		assert.Equal(t, date, decoded["LastImportAt"])
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"strconv"
+	"sync"
+)
+
+const JsonPayloadEncoding = "json"
+const ProtobufPayloadEncoding = "protobuf"
+const AvroPayloadEncoding = "avro"
+const DefaultPayloadEncoding = JsonPayloadEncoding
+
+const ContentTypeHeader = "content-type"
+const SchemaIdHeader = "schema-id"
+
+// confluentMagicByte starts the Confluent wire format: the magic byte, the 4-byte big-endian schema ID
+// and, for Protobuf only, the message indexes, followed by the payload.
+const confluentMagicByte = 0
+
+const LessonTypesListSchemaVersion = 1
+const LessonProcessedSchemaVersion = 1
+const LessonsSnapshotStartedSchemaVersion = 1
+const LessonsSnapshotFinishedSchemaVersion = 1
+const LessonsImportStaleSchemaVersion = 1
+
+type PayloadEncoder interface {
+	encode(value any, version int) ([]byte, error)
+	// messageIndexes locates the encoded message in its schema for the Confluent wire format.
+	messageIndexes() []byte
+	contentType() string
+	schemaType() string
+	schema(subject string, version int) (string, error)
+}
+
+var payloadEncoders = map[string]PayloadEncoder{
+	JsonPayloadEncoding:     JsonEncoder{},
+	ProtobufPayloadEncoding: ProtobufEncoder{},
+	AvroPayloadEncoding:     AvroEncoder{},
+}
+
+// PayloadCodec encodes events with the selected encoder and labels binary payloads with the schema version,
+// the content type and, when a registry is configured, the registered schema ID, both in a header and in the
+// Confluent wire format prefix, so the standard deserializers read the payloads.
+// JSON messages carry schema-version only after the first version, so v1 payloads stay as consumers know them.
+// A nil PayloadCodec encodes JSON.
+type PayloadCodec struct {
+	encoder   PayloadEncoder
+	registry  SchemaRegistryInterface
+	schemaIds map[string]int
+	mutex     sync.Mutex
+}
+
+func newPayloadCodec(encoding string, registry SchemaRegistryInterface) *PayloadCodec {
+	return &PayloadCodec{
+		encoder:   payloadEncoders[encoding],
+		registry:  registry,
+		schemaIds: make(map[string]int),
+	}
+}
+
+func (codec *PayloadCodec) message(key string, value any, version int) (message kafka.Message, err error) {
+	var encoder PayloadEncoder = JsonEncoder{}
+	if codec != nil {
+		encoder = codec.encoder
+	}
+
+	message.Key = []byte(key)
+	message.Value, err = encoder.encode(value, version)
+	if err != nil {
+		return kafka.Message{}, errors.New("failed to encode " + payloadSubject(value) + ": " + err.Error())
+	}
+
+	_, isJson := encoder.(JsonEncoder)
+	if !isJson || version != 1 {
+		message.Headers = append(message.Headers, kafka.Header{Key: SchemaVersionHeader, Value: []byte(strconv.Itoa(version))})
+	}
+	if !isJson {
+		message.Headers = append(message.Headers, kafka.Header{Key: ContentTypeHeader, Value: []byte(encoder.contentType())})
+	}
+
+	if codec != nil && codec.registry != nil {
+		var schemaId int
+		schemaId, err = codec.schemaId(payloadSubject(value), version)
+		if err != nil {
+			return kafka.Message{}, errors.New("failed to register schema: " + err.Error())
+		}
+		message.Headers = append(message.Headers, kafka.Header{Key: SchemaIdHeader, Value: []byte(strconv.Itoa(schemaId))})
+		message.Value = confluentWireFormat(schemaId, encoder.messageIndexes(), message.Value)
+	}
+
+	return
+}
+
+func confluentWireFormat(schemaId int, messageIndexes []byte, payload []byte) []byte {
+	value := make([]byte, 5, 5+len(messageIndexes)+len(payload))
+	value[0] = confluentMagicByte
+	binary.BigEndian.PutUint32(value[1:], uint32(schemaId))
+	value = append(value, messageIndexes...)
+
+	return append(value, payload...)
+}
+
+func (codec *PayloadCodec) schemaId(subject string, version int) (int, error) {
+	codec.mutex.Lock()
+	defer codec.mutex.Unlock()
+
+	cacheKey := subject + "/" + strconv.Itoa(version)
+	if id, exists := codec.schemaIds[cacheKey]; exists {
+		return id, nil
+	}
+
+	schema, err := codec.encoder.schema(subject, version)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := codec.registry.register(subject, codec.encoder.schemaType(), schema)
+	if err == nil {
+		codec.schemaIds[cacheKey] = id
+	}
+
+	return id, err
+}
+
+func payloadSubject(value any) string {
+	switch value.(type) {
+	case *ExtendedLessonEvent:
+		return "LessonEvent"
+	case events.LessonTypesList:
+		return "LessonTypesList"
+	case events.SecondaryDbLessonProcessedEvent:
+		return "SecondaryDbLessonProcessedEvent"
+	case LessonsSnapshotStartedEvent:
+		return LessonsSnapshotStartedEventName
+	case LessonsSnapshotFinishedEvent:
+		return LessonsSnapshotFinishedEventName
+	case LessonsImportStaleEvent:
+		return LessonsImportStaleEventName
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+func unsupportedSchema(subject string, version int) error {
+	return fmt.Errorf("no schema for %s version %d", subject, version)
+}
+
+type JsonEncoder struct{}
+
+func (encoder JsonEncoder) encode(value any, version int) ([]byte, error) {
+	if lesson, isLesson := value.(*ExtendedLessonEvent); isLesson && version == LessonSchemaVersion {
+		return json.Marshal(lesson.LessonEvent)
+	}
+
+	return json.Marshal(value)
+}
+
+func (encoder JsonEncoder) messageIndexes() []byte {
+	return nil
+}
+
+func (encoder JsonEncoder) contentType() string {
+	return "application/json"
+}
+
+func (encoder JsonEncoder) schemaType() string {
+	return "JSON"
+}
+
+func (encoder JsonEncoder) schema(subject string, version int) (string, error) {
+	return "", unsupportedSchema(subject, version)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeSchemaRegistry struct {
+	ids   map[string]int
+	calls int
+	err   error
+}
+
+func (registry *fakeSchemaRegistry) register(subject string, schemaType string, schema string) (int, error) {
+	registry.calls++
+	return registry.ids[subject+"/"+schemaType], registry.err
+}
+
+func TestPayloadCodec(t *testing.T) {
+	processedEvent := events.SecondaryDbLessonProcessedEvent{
+		Year:                              2023,
+		CurrentSecondaryDatabaseDatetime:  time.Date(2023, 9, 2, 4, 0, 0, 0, time.UTC),
+		PreviousSecondaryDatabaseDatetime: time.Date(2023, 9, 1, 4, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("nil codec encodes json", func(t *testing.T) {
+		var codec *PayloadCodec
+		expectedPayload, _ := json.Marshal(processedEvent)
+
+		message, err := codec.message(events.SecondaryDbLessonProcessedEventName, processedEvent, 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, kafka.Message{
+			Key:   []byte(events.SecondaryDbLessonProcessedEventName),
+			Value: expectedPayload,
+		}, message)
+	})
+
+	t.Run("binary payload with registered schema", func(t *testing.T) {
+		registry := &fakeSchemaRegistry{ids: map[string]int{"SecondaryDbLessonProcessedEvent/AVRO": 42}}
+		codec := newPayloadCodec(AvroPayloadEncoding, registry)
+
+		message, err := codec.message(events.SecondaryDbLessonProcessedEventName, processedEvent, 1)
+		assert.NoError(t, err)
+		_, err = codec.message(events.SecondaryDbLessonProcessedEventName, processedEvent, 1)
+		assert.NoError(t, err)
+
+		assert.Equal(t, []kafka.Header{
+			{Key: SchemaVersionHeader, Value: []byte("1")},
+			{Key: ContentTypeHeader, Value: []byte("avro/binary")},
+			{Key: SchemaIdHeader, Value: []byte("42")},
+		}, message.Headers)
+		assert.Equal(t, 1, registry.calls)
+
+		payload, _ := AvroEncoder{}.encode(processedEvent, 1)
+		assert.Equal(t, append([]byte{0, 0, 0, 0, 42}, payload...), message.Value)
+	})
+
+	t.Run("protobuf payload with registered schema", func(t *testing.T) {
+		registry := &fakeSchemaRegistry{ids: map[string]int{"SecondaryDbLessonProcessedEvent/PROTOBUF": 258}}
+		codec := newPayloadCodec(ProtobufPayloadEncoding, registry)
+
+		message, err := codec.message(events.SecondaryDbLessonProcessedEventName, processedEvent, 1)
+		assert.NoError(t, err)
+
+		payload, _ := ProtobufEncoder{}.encode(processedEvent, 1)
+		assert.Equal(t, append([]byte{0, 0, 0, 1, 2, 0}, payload...), message.Value)
+	})
+
+	t.Run("registry error", func(t *testing.T) {
+		codec := newPayloadCodec(ProtobufPayloadEncoding, &fakeSchemaRegistry{err: errors.New("registry is down")})
+
+		_, err := codec.message(events.SecondaryDbLessonProcessedEventName, processedEvent, 1)
+
+		assert.EqualError(t, err, "failed to register schema: registry is down")
+	})
+
+	t.Run("unknown schema version", func(t *testing.T) {
+		codec := newPayloadCodec(ProtobufPayloadEncoding, nil)
+
+		_, err := codec.message(events.SecondaryDbLessonProcessedEventName, processedEvent, 3)
+
+		assert.EqualError(t, err, "failed to encode SecondaryDbLessonProcessedEvent: no schema for SecondaryDbLessonProcessedEvent version 3")
+	})
+}
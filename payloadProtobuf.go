@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sort"
+	"time"
+)
+
+// Datetimes are encoded as Unix milliseconds, the same as the Avro timestamp-millis fields.
+const ProtoLessonEventSchema = `syntax = "proto3";
+
+message LessonEvent {
+  uint64 id = 1;
+  uint64 discipline_id = 2;
+  uint32 type_id = 3;
+  int64 date = 4;
+  int64 year = 5;
+  uint32 semester = 6;
+  bool is_deleted = 7;
+}
+`
+
+const ProtoExtendedLessonEventSchema = `syntax = "proto3";
+
+message LessonEvent {
+  uint64 id = 1;
+  uint64 discipline_id = 2;
+  uint32 type_id = 3;
+  int64 date = 4;
+  int64 year = 5;
+  uint32 semester = 6;
+  bool is_deleted = 7;
+  int64 schema_version = 8;
+  int64 reg_date = 9;
+  int64 status = 10;
+  map<string, string> extra = 11;
+}
+`
+
+// Every schema declares its encoded message first, which the Confluent wire format refers to as index 0.
+const ProtoLessonTypesListSchema = `syntax = "proto3";
+
+message LessonTypesList {
+  int64 year = 1;
+  repeated LessonType list = 2;
+}
+
+message LessonType {
+  int64 id = 1;
+  string short_name = 2;
+  string long_name = 3;
+}
+`
+
+const ProtoLessonProcessedSchema = `syntax = "proto3";
+
+message SecondaryDbLessonProcessedEvent {
+  int64 year = 1;
+  int64 current_secondary_database_datetime = 2;
+  int64 previous_secondary_database_datetime = 3;
+}
+`
+
+const ProtoLessonsSnapshotStartedSchema = `syntax = "proto3";
+
+message LessonsSnapshotStartedEvent {
+  int64 year = 1;
+  int64 started_at = 2;
+}
+`
+
+const ProtoLessonsSnapshotFinishedSchema = `syntax = "proto3";
+
+message LessonsSnapshotFinishedEvent {
+  int64 year = 1;
+  int64 lessons_count = 2;
+  int64 started_at = 3;
+  int64 finished_at = 4;
+}
+`
+
+const ProtoLessonsImportStaleSchema = `syntax = "proto3";
+
+message LessonsImportStaleEvent {
+  int64 last_import_at = 1;
+  string max_quiet_period = 2;
+  int64 detected_at = 3;
+}
+`
+
+type ProtobufEncoder struct{}
+
+func (encoder ProtobufEncoder) encode(value any, version int) ([]byte, error) {
+	if _, err := encoder.schema(payloadSubject(value), version); err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	switch event := value.(type) {
+	case *ExtendedLessonEvent:
+		payload = appendProtoLesson(payload, event, version)
+
+	case events.LessonTypesList:
+		payload = appendProtoVarint(payload, 1, uint64(event.Year))
+		for _, lessonType := range event.List {
+			var item []byte
+			item = appendProtoVarint(item, 1, uint64(lessonType.Id))
+			item = appendProtoString(item, 2, lessonType.ShortName)
+			item = appendProtoString(item, 3, lessonType.LongName)
+			payload = protowire.AppendTag(payload, 2, protowire.BytesType)
+			payload = protowire.AppendBytes(payload, item)
+		}
+
+	case events.SecondaryDbLessonProcessedEvent:
+		payload = appendProtoVarint(payload, 1, uint64(event.Year))
+		payload = appendProtoTime(payload, 2, event.CurrentSecondaryDatabaseDatetime)
+		payload = appendProtoTime(payload, 3, event.PreviousSecondaryDatabaseDatetime)
+
+	case LessonsSnapshotStartedEvent:
+		payload = appendProtoVarint(payload, 1, uint64(event.Year))
+		payload = appendProtoTime(payload, 2, event.StartedAt)
+
+	case LessonsSnapshotFinishedEvent:
+		payload = appendProtoVarint(payload, 1, uint64(event.Year))
+		payload = appendProtoVarint(payload, 2, uint64(event.LessonsCount))
+		payload = appendProtoTime(payload, 3, event.StartedAt)
+		payload = appendProtoTime(payload, 4, event.FinishedAt)
+
+	case LessonsImportStaleEvent:
+		payload = appendProtoTime(payload, 1, event.LastImportAt)
+		payload = appendProtoString(payload, 2, event.MaxQuietPeriod)
+		payload = appendProtoTime(payload, 3, event.DetectedAt)
+	}
+
+	return payload, nil
+}
+
+// messageIndexes is the single byte 0 for the first message of the schema.
+func (encoder ProtobufEncoder) messageIndexes() []byte {
+	return []byte{0}
+}
+
+func (encoder ProtobufEncoder) contentType() string {
+	return "application/x-protobuf"
+}
+
+func (encoder ProtobufEncoder) schemaType() string {
+	return "PROTOBUF"
+}
+
+func (encoder ProtobufEncoder) schema(subject string, version int) (string, error) {
+	schemas := map[string]string{
+		"LessonEvent/1":                     ProtoLessonEventSchema,
+		"LessonEvent/2":                     ProtoExtendedLessonEventSchema,
+		"LessonTypesList/1":                 ProtoLessonTypesListSchema,
+		"SecondaryDbLessonProcessedEvent/1": ProtoLessonProcessedSchema,
+		"LessonsSnapshotStartedEvent/1":     ProtoLessonsSnapshotStartedSchema,
+		"LessonsSnapshotFinishedEvent/1":    ProtoLessonsSnapshotFinishedSchema,
+		"LessonsImportStaleEvent/1":         ProtoLessonsImportStaleSchema,
+	}
+
+	if schema, exists := schemas[fmt.Sprintf("%s/%d", subject, version)]; exists {
+		return schema, nil
+	}
+
+	return "", unsupportedSchema(subject, version)
+}
+
+func appendProtoLesson(payload []byte, event *ExtendedLessonEvent, version int) []byte {
+	payload = appendProtoVarint(payload, 1, uint64(event.Id))
+	payload = appendProtoVarint(payload, 2, uint64(event.DisciplineId))
+	payload = appendProtoVarint(payload, 3, uint64(event.TypeId))
+	payload = appendProtoTime(payload, 4, event.Date)
+	payload = appendProtoVarint(payload, 5, uint64(event.Year))
+	payload = appendProtoVarint(payload, 6, uint64(event.Semester))
+	if event.IsDeleted {
+		payload = appendProtoVarint(payload, 7, 1)
+	}
+
+	if version != ExtendedLessonSchemaVersion {
+		return payload
+	}
+
+	payload = appendProtoVarint(payload, 8, uint64(event.SchemaVersion))
+	payload = appendProtoTime(payload, 9, event.RegDate)
+	payload = appendProtoVarint(payload, 10, uint64(event.Status))
+
+	columns := make([]string, 0, len(event.Extra))
+	for column := range event.Extra {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		// a NULL column is left out of the map
+		if event.Extra[column] == nil {
+			continue
+		}
+		var entry []byte
+		entry = appendProtoString(entry, 1, column)
+		entry = appendProtoString(entry, 2, fmt.Sprint(event.Extra[column]))
+		payload = protowire.AppendTag(payload, 11, protowire.BytesType)
+		payload = protowire.AppendBytes(payload, entry)
+	}
+
+	return payload
+}
+
+// appendProto* skip zero values as proto3 does for scalar fields.
+func appendProtoVarint(payload []byte, field protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return payload
+	}
+
+	payload = protowire.AppendTag(payload, field, protowire.VarintType)
+	return protowire.AppendVarint(payload, value)
+}
+
+func appendProtoString(payload []byte, field protowire.Number, value string) []byte {
+	if value == "" {
+		return payload
+	}
+
+	payload = protowire.AppendTag(payload, field, protowire.BytesType)
+	return protowire.AppendString(payload, value)
+}
+
+func appendProtoTime(payload []byte, field protowire.Number, value time.Time) []byte {
+	if value.IsZero() {
+		return payload
+	}
+
+	return appendProtoVarint(payload, field, uint64(value.UnixMilli()))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"testing"
+	"time"
+)
+
+// decodeProtoFields returns the varint and bytes fields of one message, repeated fields in order.
+func decodeProtoFields(t *testing.T, payload []byte) map[protowire.Number][]any {
+	fields := make(map[protowire.Number][]any)
+	for len(payload) > 0 {
+		number, fieldType, n := protowire.ConsumeTag(payload)
+		assert.Positive(t, n)
+		payload = payload[n:]
+
+		switch fieldType {
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(payload)
+			fields[number] = append(fields[number], value)
+			payload = payload[n:]
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(payload)
+			fields[number] = append(fields[number], value)
+			payload = payload[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", fieldType)
+		}
+	}
+
+	return fields
+}
+
+func TestProtobufEncoder(t *testing.T) {
+	encoder := ProtobufEncoder{}
+	date := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	lesson := &ExtendedLessonEvent{
+		LessonEvent: events.LessonEvent{
+			Id:           10,
+			DisciplineId: 100,
+			TypeId:       1,
+			Date:         date,
+			Year:         2022,
+			Semester:     2,
+			IsDeleted:    true,
+		},
+		SchemaVersion: ExtendedLessonSchemaVersion,
+		Status:        1,
+		Extra:         map[string]any{"NUM_AUD": 101, "NOTE": nil},
+	}
+
+	t.Run("lesson", func(t *testing.T) {
+		payload, err := encoder.encode(lesson, LessonSchemaVersion)
+		assert.NoError(t, err)
+
+		fields := decodeProtoFields(t, payload)
+		assert.Equal(t, []any{uint64(10)}, fields[1])
+		assert.Equal(t, []any{uint64(100)}, fields[2])
+		assert.Equal(t, []any{uint64(1)}, fields[3])
+		assert.Equal(t, []any{uint64(date.UnixMilli())}, fields[4])
+		assert.Equal(t, []any{uint64(2022)}, fields[5])
+		assert.Equal(t, []any{uint64(2)}, fields[6])
+		assert.Equal(t, []any{uint64(1)}, fields[7])
+		assert.NotContains(t, fields, protowire.Number(8))
+	})
+
+	t.Run("extended lesson", func(t *testing.T) {
+		payload, err := encoder.encode(lesson, ExtendedLessonSchemaVersion)
+		assert.NoError(t, err)
+
+		fields := decodeProtoFields(t, payload)
+		assert.Equal(t, []any{uint64(2)}, fields[8])
+		assert.NotContains(t, fields, protowire.Number(9))
+		assert.Equal(t, []any{uint64(1)}, fields[10])
+
+		assert.Len(t, fields[11], 1)
+		entry := decodeProtoFields(t, fields[11][0].([]byte))
+		assert.Equal(t, []any{[]byte("NUM_AUD")}, entry[1])
+		assert.Equal(t, []any{[]byte("101")}, entry[2])
+	})
+
+	t.Run("lesson types list", func(t *testing.T) {
+		payload, err := encoder.encode(events.LessonTypesList{
+			Year: 2023,
+			List: []events.LessonType{{Id: 1, ShortName: "Лек", LongName: "Лекція"}, {Id: 2, ShortName: "Сем"}},
+		}, LessonTypesListSchemaVersion)
+		assert.NoError(t, err)
+
+		fields := decodeProtoFields(t, payload)
+		assert.Equal(t, []any{uint64(2023)}, fields[1])
+		assert.Len(t, fields[2], 2)
+
+		lessonType := decodeProtoFields(t, fields[2][0].([]byte))
+		assert.Equal(t, []any{uint64(1)}, lessonType[1])
+		assert.Equal(t, []any{[]byte("Лек")}, lessonType[2])
+		assert.Equal(t, []any{[]byte("Лекція")}, lessonType[3])
+	})
+
+	t.Run("snapshot started event", func(t *testing.T) {
+		payload, err := encoder.encode(
+			LessonsSnapshotStartedEvent{Year: 2023, StartedAt: date}, LessonsSnapshotStartedSchemaVersion,
+		)
+		assert.NoError(t, err)
+
+		fields := decodeProtoFields(t, payload)
+		assert.Equal(t, []any{uint64(2023)}, fields[1])
+		assert.Equal(t, []any{uint64(date.UnixMilli())}, fields[2])
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		schema, err := encoder.schema("LessonEvent", ExtendedLessonSchemaVersion)
+		assert.NoError(t, err)
+		assert.Contains(t, schema, "map<string, string> extra = 11;")
+
+		_, err = encoder.schema("LessonEvent", 3)
+		assert.EqualError(t, err, "no schema for LessonEvent version 3")
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/kneu-messenger-pigeon/events"
+	"go.etcd.io/bbolt"
+	"strconv"
+)
+
+type PublishedLessonsStoreInterface interface {
+	add(year int, lessons []events.LessonEvent) error
+	list(year int) (map[uint]events.LessonEvent, error)
+	remove(year int, ids []uint) error
+}
+
+// PublishedLessonsStore keeps the last published state of every lesson per year, one bucket per year keyed by lesson ID.
+// A lesson is kept only under its latest year: a lesson rescheduled into another academic year leaves the bucket
+// of the previous one, whose reconcile would otherwise take it for deleted.
+type PublishedLessonsStore struct {
+	db *bbolt.DB
+}
+
+var publishedLessonsBucketPrefix = []byte("published-lessons-")
+
+func publishedLessonsBucket(year int) []byte {
+	return append(append([]byte{}, publishedLessonsBucketPrefix...), strconv.Itoa(year)...)
+}
+
+func lessonIdKey(id uint) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func (store *PublishedLessonsStore) add(year int, lessons []events.LessonEvent) error {
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		name := publishedLessonsBucket(year)
+		var otherYears []*bbolt.Bucket
+		err := tx.ForEach(func(otherName []byte, otherBucket *bbolt.Bucket) error {
+			if bytes.HasPrefix(otherName, publishedLessonsBucketPrefix) && !bytes.Equal(otherName, name) {
+				otherYears = append(otherYears, otherBucket)
+			}
+			return nil
+		})
+
+		var bucket *bbolt.Bucket
+		if err == nil {
+			bucket, err = tx.CreateBucketIfNotExists(name)
+		}
+		for i := 0; err == nil && i < len(lessons); i++ {
+			key := lessonIdKey(lessons[i].Id)
+			for j := 0; err == nil && j < len(otherYears); j++ {
+				err = otherYears[j].Delete(key)
+			}
+
+			var value []byte
+			if err == nil {
+				value, err = json.Marshal(lessons[i])
+			}
+			if err == nil {
+				err = bucket.Put(key, value)
+			}
+		}
+		return err
+	})
+}
+
+func (store *PublishedLessonsStore) list(year int) (lessons map[uint]events.LessonEvent, err error) {
+	lessons = make(map[uint]events.LessonEvent)
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(publishedLessonsBucket(year))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key []byte, value []byte) error {
+			var lesson events.LessonEvent
+			err := json.Unmarshal(value, &lesson)
+			lessons[uint(binary.BigEndian.Uint64(key))] = lesson
+			return err
+		})
+	})
+
+	return
+}
+
+func (store *PublishedLessonsStore) remove(year int, ids []uint) error {
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(publishedLessonsBucket(year))
+		if bucket == nil {
+			return nil
+		}
+
+		var err error
+		for i := 0; err == nil && i < len(ids); i++ {
+			err = bucket.Delete(lessonIdKey(ids[i]))
+		}
+		return err
+	})
+}
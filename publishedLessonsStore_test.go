@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestPublishedLessonsStore(t *testing.T) *PublishedLessonsStore {
+	stateDb, err := openStateDb(t.TempDir())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = stateDb.Close() })
+
+	return &PublishedLessonsStore{db: stateDb}
+}
+
+func TestPublishedLessonsStore(t *testing.T) {
+	lessons := []events.LessonEvent{
+		{Id: 10, DisciplineId: 100, TypeId: 1, Date: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), Year: 2022, Semester: 2},
+		{Id: 300, DisciplineId: 101, TypeId: 2, Date: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC), Year: 2022, Semester: 2},
+	}
+
+	t.Run("add list and remove", func(t *testing.T) {
+		store := newTestPublishedLessonsStore(t)
+
+		assert.NoError(t, store.add(2022, lessons))
+		assert.NoError(t, store.add(2023, []events.LessonEvent{{Id: 20, Date: time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC), Year: 2023}}))
+
+		actual, err := store.list(2022)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uint]events.LessonEvent{10: lessons[0], 300: lessons[1]}, actual)
+
+		assert.NoError(t, store.remove(2022, []uint{300}))
+
+		actual, err = store.list(2022)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uint]events.LessonEvent{10: lessons[0]}, actual)
+
+		actual, err = store.list(2023)
+		assert.NoError(t, err)
+		assert.Len(t, actual, 1)
+	})
+
+	t.Run("lesson moved to another year", func(t *testing.T) {
+		store := newTestPublishedLessonsStore(t)
+
+		moved := lessons[0]
+		moved.Date = time.Date(2023, 9, 4, 0, 0, 0, 0, time.UTC)
+		moved.Year = 2023
+		moved.Semester = 1
+
+		assert.NoError(t, store.add(2022, lessons))
+		assert.NoError(t, store.add(2023, []events.LessonEvent{moved}))
+
+		actual, err := store.list(2022)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uint]events.LessonEvent{300: lessons[1]}, actual)
+
+		actual, err = store.list(2023)
+		assert.NoError(t, err)
+		assert.Equal(t, map[uint]events.LessonEvent{10: moved}, actual)
+	})
+
+	t.Run("overwrite with latest state", func(t *testing.T) {
+		store := newTestPublishedLessonsStore(t)
+
+		updated := lessons[0]
+		updated.IsDeleted = true
+
+		assert.NoError(t, store.add(2022, lessons[:1]))
+		assert.NoError(t, store.add(2022, []events.LessonEvent{updated}))
+
+		actual, err := store.list(2022)
+		assert.NoError(t, err)
+		assert.True(t, actual[10].IsDeleted)
+	})
+
+	t.Run("unknown year", func(t *testing.T) {
+		store := newTestPublishedLessonsStore(t)
+
+		actual, err := store.list(2000)
+		assert.NoError(t, err)
+		assert.Empty(t, actual)
+		assert.NoError(t, store.remove(2000, []uint{1}))
+	})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const queryFiltersPlaceholder = "%FILTERS%"
+const queryExtraColumnsPlaceholder = "%EXTRA_COLUMNS%"
+
+type QueryDefinition struct {
+	Query        string            `json:"query"`
+	Columns      map[string]string `json:"columns"`
+	Filters      []string          `json:"filters"`
+	ExtraColumns []string          `json:"extraColumns"`
+}
+
+// QuerySet reads the import window with Lessons, the inner day chunks of it with LessonsRange, which excludes
+// the chunk end, and the ID pages with LessonsPage.
+type QuerySet struct {
+	Lessons      QueryDefinition `json:"lessons"`
+	LessonsRange QueryDefinition `json:"lessonsRange"`
+	LessonsPage  QueryDefinition `json:"lessonsPage"`
+	Snapshot     QueryDefinition `json:"snapshot"`
+	LessonIds    QueryDefinition `json:"lessonIds"`
+	LessonTypes  QueryDefinition `json:"lessonTypes"`
+}
+
+type columnKind int
+
+const (
+	unknownColumn columnKind = iota
+	integerColumn
+	booleanColumn
+	textColumn
+	datetimeColumn
+	otherColumn
+)
+
+var columnKindNames = map[columnKind]string{
+	integerColumn:  "integer",
+	booleanColumn:  "boolean",
+	textColumn:     "text",
+	datetimeColumn: "datetime",
+}
+
+type fieldBinding[T any] struct {
+	kind   columnKind
+	target func(item *T) any
+}
+
+var lessonEventBindings = map[string]fieldBinding[ExtendedLessonEvent]{
+	"Id":           {integerColumn, func(event *ExtendedLessonEvent) any { return &event.Id }},
+	"DisciplineId": {integerColumn, func(event *ExtendedLessonEvent) any { return &event.DisciplineId }},
+	"Date":         {datetimeColumn, func(event *ExtendedLessonEvent) any { return &event.Date }},
+	"TypeId":       {integerColumn, func(event *ExtendedLessonEvent) any { return &event.TypeId }},
+	"Semester":     {integerColumn, func(event *ExtendedLessonEvent) any { return &event.Semester }},
+	"IsDeleted":    {booleanColumn, func(event *ExtendedLessonEvent) any { return &event.IsDeleted }},
+	"RegDate":      {datetimeColumn, func(event *ExtendedLessonEvent) any { return &event.RegDate }},
+	"Status":       {integerColumn, func(event *ExtendedLessonEvent) any { return &event.Status }},
+}
+
+var lessonTypeBindings = map[string]fieldBinding[events.LessonType]{
+	"Id":        {integerColumn, func(lessonType *events.LessonType) any { return &lessonType.Id }},
+	"ShortName": {textColumn, func(lessonType *events.LessonType) any { return &lessonType.ShortName }},
+	"LongName":  {textColumn, func(lessonType *events.LessonType) any { return &lessonType.LongName }},
+}
+
+var defaultLessonColumns = map[string]string{
+	"Id":           "ID",
+	"DisciplineId": "NUM_PREDM",
+	"Date":         "DATEZAN",
+	"TypeId":       "NUM_VARZAN",
+	"Semester":     "HALF",
+	"IsDeleted":    "isDeleted",
+}
+
+var defaultLessonTypeColumns = map[string]string{
+	"Id":        "ID",
+	"ShortName": "SHIRTNAME",
+	"LongName":  "LONGNAME",
+}
+
+func (definition QueryDefinition) build() string {
+	filters := ""
+	for _, filter := range definition.Filters {
+		filters += " AND (" + filter + ")"
+	}
+
+	extraColumns := ""
+	for _, column := range definition.ExtraColumns {
+		extraColumns += ", " + column
+	}
+
+	return strings.Replace(
+		strings.Replace(definition.Query, queryFiltersPlaceholder, filters, 1),
+		queryExtraColumnsPlaceholder, extraColumns, 1,
+	)
+}
+
+func (definition QueryDefinition) merge(override QueryDefinition) QueryDefinition {
+	merged := QueryDefinition{
+		Query:   definition.Query,
+		Columns: make(map[string]string, len(definition.Columns)),
+		Filters: append(append([]string{}, definition.Filters...), override.Filters...),
+		ExtraColumns: append(
+			append([]string{}, definition.ExtraColumns...), override.ExtraColumns...,
+		),
+	}
+	if override.Query != "" {
+		merged.Query = override.Query
+	}
+	for field, column := range definition.Columns {
+		merged.Columns[field] = column
+	}
+	for field, column := range override.Columns {
+		merged.Columns[field] = column
+	}
+
+	return merged
+}
+
+func loadQuerySet(filename string, defaults QuerySet) (QuerySet, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return QuerySet{}, err
+	}
+
+	var override QuerySet
+	if err = json.Unmarshal(content, &override); err != nil {
+		return QuerySet{}, errors.New("Failed to parse " + filename + ": " + err.Error())
+	}
+
+	querySet := QuerySet{
+		Lessons:      defaults.Lessons.merge(override.Lessons),
+		LessonsRange: defaults.LessonsRange.merge(override.LessonsRange),
+		LessonsPage:  defaults.LessonsPage.merge(override.LessonsPage),
+		Snapshot:     defaults.Snapshot.merge(override.Snapshot),
+		LessonIds:    defaults.LessonIds.merge(override.LessonIds),
+		LessonTypes:  defaults.LessonTypes.merge(override.LessonTypes),
+	}
+
+	if err = checkDefinition("lessons", querySet.Lessons, lessonEventBindings); err == nil {
+		err = checkDefinition("lessonsRange", querySet.LessonsRange, lessonEventBindings)
+	}
+	if err == nil {
+		err = checkDefinition("lessonsPage", querySet.LessonsPage, lessonEventBindings)
+	}
+	if err == nil {
+		err = checkDefinition("snapshot", querySet.Snapshot, lessonEventBindings)
+	}
+	if err == nil {
+		err = checkDefinition("lessonIds", querySet.LessonIds, lessonEventBindings)
+	}
+	if err == nil {
+		err = checkDefinition("lessonTypes", querySet.LessonTypes, lessonTypeBindings)
+	}
+
+	return querySet, err
+}
+
+func checkDefinition[T any](name string, definition QueryDefinition, bindings map[string]fieldBinding[T]) error {
+	if len(definition.Filters) != 0 && !strings.Contains(definition.Query, queryFiltersPlaceholder) {
+		return errors.New(name + " query has filters but no " + queryFiltersPlaceholder + " placeholder")
+	}
+
+	if len(definition.ExtraColumns) != 0 && !strings.Contains(definition.Query, queryExtraColumnsPlaceholder) {
+		return errors.New(name + " query has extra columns but no " + queryExtraColumnsPlaceholder + " placeholder")
+	}
+
+	for field := range definition.Columns {
+		if _, exists := bindings[field]; !exists {
+			return errors.New(name + " query maps unknown field " + field)
+		}
+	}
+
+	return nil
+}
+
+// validateQuerySet runs every query on an empty window and checks that the mapped columns exist with compatible types.
+func validateQuerySet(db *sql.DB, dialect *Dialect) error {
+	zeroDatetime := dialect.bindDatetime(time.Unix(0, 0))
+	rows, err := db.Query(dialect.queries.Lessons.build(), zeroDatetime, zeroDatetime)
+	if err == nil {
+		err = validateColumns("lessons", rows, dialect.queries.Lessons, lessonEventBindings)
+		_ = rows.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(dialect.queries.LessonsRange.build(), zeroDatetime, zeroDatetime)
+	if err == nil {
+		err = validateColumns("lessonsRange", rows, dialect.queries.LessonsRange, lessonEventBindings)
+		_ = rows.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(dialect.queries.LessonsPage.build(), zeroDatetime, zeroDatetime, 0, 1)
+	if err == nil {
+		err = validateColumns("lessonsPage", rows, dialect.queries.LessonsPage, lessonEventBindings)
+		_ = rows.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(dialect.queries.Snapshot.build(), zeroDatetime, zeroDatetime)
+	if err == nil {
+		err = validateColumns("snapshot", rows, dialect.queries.Snapshot, lessonEventBindings)
+		_ = rows.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(dialect.queries.LessonIds.build(), zeroDatetime, zeroDatetime)
+	if err == nil {
+		err = validateColumns("lessonIds", rows, dialect.queries.LessonIds, lessonEventBindings)
+		_ = rows.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err = db.Query(dialect.queries.LessonTypes.build())
+	if err == nil {
+		err = validateColumns("lessonTypes", rows, dialect.queries.LessonTypes, lessonTypeBindings)
+		_ = rows.Close()
+	}
+
+	return err
+}
+
+func validateColumns[T any](name string, rows *sql.Rows, definition QueryDefinition, bindings map[string]fieldBinding[T]) error {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	kinds := make(map[string]columnKind, len(columnTypes))
+	for _, columnType := range columnTypes {
+		kinds[strings.ToUpper(columnType.Name())] = scanTypeKind(columnType.ScanType())
+	}
+
+	for _, column := range definition.ExtraColumns {
+		if _, exists := kinds[strings.ToUpper(column)]; !exists {
+			return fmt.Errorf("%s query result has no extra column %s", name, column)
+		}
+	}
+
+	fields := make([]string, 0, len(definition.Columns))
+	for field := range definition.Columns {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		column := definition.Columns[field]
+		kind, exists := kinds[strings.ToUpper(column)]
+		if !exists {
+			return fmt.Errorf("%s query result has no column %s for field %s", name, column, field)
+		}
+		if !isCompatibleKind(bindings[field].kind, kind) {
+			return fmt.Errorf(
+				"%s query column %s has type incompatible with field %s (expected %s)",
+				name, column, field, columnKindNames[bindings[field].kind],
+			)
+		}
+	}
+
+	return nil
+}
+
+// bindColumns returns scan targets for the result columns: mapped columns point to item fields,
+// the configured extra columns are returned separately by name, others are discarded.
+func bindColumns[T any](
+	rows *sql.Rows, definition QueryDefinition, bindings map[string]fieldBinding[T], item *T,
+) (targets []any, extraTargets map[string]*any, err error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+
+	fieldsByColumn := make(map[string]string, len(definition.Columns))
+	for field, column := range definition.Columns {
+		fieldsByColumn[strings.ToUpper(column)] = field
+	}
+
+	extraColumns := make(map[string]string, len(definition.ExtraColumns))
+	for _, column := range definition.ExtraColumns {
+		if _, isMapped := fieldsByColumn[strings.ToUpper(column)]; !isMapped {
+			extraColumns[strings.ToUpper(column)] = column
+		}
+	}
+
+	targets = make([]any, len(columns))
+	extraTargets = make(map[string]*any, len(extraColumns))
+	for i, column := range columns {
+		if field, exists := fieldsByColumn[strings.ToUpper(column)]; exists {
+			targets[i] = bindings[field].target(item)
+			delete(fieldsByColumn, strings.ToUpper(column))
+		} else if extraColumn, isExtra := extraColumns[strings.ToUpper(column)]; isExtra {
+			extraTargets[extraColumn] = new(any)
+			targets[i] = extraTargets[extraColumn]
+		} else {
+			targets[i] = new(any)
+		}
+	}
+
+	for column, field := range fieldsByColumn {
+		return nil, nil, errors.New("query result has no column " + column + " for field " + field)
+	}
+
+	return
+}
+
+func scanTypeKind(scanType reflect.Type) columnKind {
+	if scanType == nil {
+		return unknownColumn
+	}
+	for scanType.Kind() == reflect.Pointer {
+		scanType = scanType.Elem()
+	}
+
+	switch scanType {
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(sql.NullTime{}):
+		return datetimeColumn
+	case reflect.TypeOf(sql.NullInt64{}), reflect.TypeOf(sql.NullInt32{}), reflect.TypeOf(sql.NullInt16{}), reflect.TypeOf(sql.NullByte{}):
+		return integerColumn
+	case reflect.TypeOf(sql.NullString{}), reflect.TypeOf(sql.RawBytes{}), reflect.TypeOf([]byte{}):
+		return textColumn
+	case reflect.TypeOf(sql.NullBool{}):
+		return booleanColumn
+	}
+
+	switch scanType.Kind() {
+	case reflect.Interface:
+		return unknownColumn
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return integerColumn
+	case reflect.Bool:
+		return booleanColumn
+	case reflect.String:
+		return textColumn
+	}
+
+	return otherColumn
+}
+
+func isCompatibleKind(expected columnKind, actual columnKind) bool {
+	return actual == unknownColumn || actual == expected || (expected == booleanColumn && actual == integerColumn)
+}
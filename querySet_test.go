@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func writeQueriesFile(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "queries.json")
+	assert.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+	return filename
+}
+
+func TestQueryDefinitionBuild(t *testing.T) {
+	t.Run("without filters", func(t *testing.T) {
+		definition := QueryDefinition{Query: "SELECT ID FROM T_PRJURN WHERE REGDATE > ?%FILTERS% ORDER BY ID"}
+
+		assert.Equal(t, "SELECT ID FROM T_PRJURN WHERE REGDATE > ? ORDER BY ID", definition.build())
+	})
+
+	t.Run("with filters", func(t *testing.T) {
+		definition := QueryDefinition{
+			Query:   "SELECT ID FROM T_PRJURN WHERE REGDATE > ?%FILTERS% ORDER BY ID",
+			Filters: []string{"FACULTY <> 99", "NUM_PREDM > 0"},
+		}
+
+		assert.Equal(
+			t, "SELECT ID FROM T_PRJURN WHERE REGDATE > ? AND (FACULTY <> 99) AND (NUM_PREDM > 0) ORDER BY ID",
+			definition.build(),
+		)
+	})
+}
+
+func TestLoadQuerySet(t *testing.T) {
+	t.Run("override filters and columns", func(t *testing.T) {
+		filename := writeQueriesFile(t, `{
+			"lessons": {
+				"columns": {"DisciplineId": "DISCIPLINE_ID"},
+				"filters": ["NUM_PREDM NOT IN (SELECT ID FROM T_TEST_PREDM)"]
+			}
+		}`)
+
+		querySet, err := loadQuerySet(filename, FirebirdDialect.queries)
+
+		assert.NoError(t, err)
+		assert.Equal(t, LessonQuery, querySet.Lessons.Query)
+		assert.Equal(t, "DISCIPLINE_ID", querySet.Lessons.Columns["DisciplineId"])
+		assert.Equal(t, "DATEZAN", querySet.Lessons.Columns["Date"])
+		assert.Contains(t, querySet.Lessons.build(), "AND (NUM_PREDM NOT IN (SELECT ID FROM T_TEST_PREDM)) ORDER BY ID DESC")
+		assert.Equal(t, FirebirdDialect.queries.LessonTypes.build(), querySet.LessonTypes.build())
+		assert.Equal(t, FirebirdDialect.queries.LessonTypes.Columns, querySet.LessonTypes.Columns)
+		assert.Equal(t, "NUM_PREDM", FirebirdDialect.queries.Lessons.Columns["DisciplineId"])
+	})
+
+	t.Run("override query", func(t *testing.T) {
+		filename := writeQueriesFile(t, `{"lessonTypes": {"query": "SELECT ID, SHIRTNAME, LONGNAME FROM V_VARZAN"}}`)
+
+		querySet, err := loadQuerySet(filename, FirebirdDialect.queries)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT ID, SHIRTNAME, LONGNAME FROM V_VARZAN", querySet.LessonTypes.build())
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		filename := writeQueriesFile(t, `{"lessons": {"columns": {"Room": "AUD"}}}`)
+
+		_, err := loadQuerySet(filename, FirebirdDialect.queries)
+
+		assert.EqualError(t, err, "lessons query maps unknown field Room")
+	})
+
+	t.Run("filters without placeholder", func(t *testing.T) {
+		filename := writeQueriesFile(t, `{"lessonTypes": {"query": "SELECT ID, SHIRTNAME, LONGNAME FROM T_VARZAN", "filters": ["ID > 0"]}}`)
+
+		_, err := loadQuerySet(filename, FirebirdDialect.queries)
+
+		assert.EqualError(t, err, "lessonTypes query has filters but no %FILTERS% placeholder")
+	})
+
+	t.Run("wrong json", func(t *testing.T) {
+		filename := writeQueriesFile(t, `{"lessons": [`)
+
+		_, err := loadQuerySet(filename, FirebirdDialect.queries)
+
+		assert.ErrorContains(t, err, "Failed to parse "+filename)
+	})
+
+	t.Run("not exists file", func(t *testing.T) {
+		_, err := loadQuerySet("/not-exists/queries.json", FirebirdDialect.queries)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateQuerySet(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(sqliteSchema)
+	assert.NoError(t, err)
+
+	t.Run("default queries", func(t *testing.T) {
+		assert.NoError(t, validateQuerySet(db, SqliteDialect))
+	})
+
+	t.Run("missing column", func(t *testing.T) {
+		querySet := SqliteDialect.queries
+		querySet.Lessons = querySet.Lessons.merge(QueryDefinition{Columns: map[string]string{"TypeId": "VARZAN"}})
+
+		err := validateQuerySet(db, SqliteDialect.withQueries(querySet))
+
+		assert.EqualError(t, err, "lessons query result has no column VARZAN for field TypeId")
+	})
+
+	t.Run("incompatible column type", func(t *testing.T) {
+		querySet := SqliteDialect.queries
+		querySet.LessonTypes = querySet.LessonTypes.merge(QueryDefinition{Columns: map[string]string{"Id": "LONGNAME"}})
+
+		err := validateQuerySet(db, SqliteDialect.withQueries(querySet))
+
+		assert.EqualError(t, err, "lessonTypes query column LONGNAME has type incompatible with field Id (expected integer)")
+	})
+
+	t.Run("filter with unknown column", func(t *testing.T) {
+		querySet := SqliteDialect.queries
+		querySet.Lessons = querySet.Lessons.merge(QueryDefinition{Filters: []string{"FACULTY <> 99"}})
+
+		err := validateQuerySet(db, SqliteDialect.withQueries(querySet))
+
+		assert.ErrorContains(t, err, "FACULTY")
+	})
+}
+
+func TestImportWithColumnMapping(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	querySet := FirebirdDialect.queries
+	querySet.Lessons = querySet.Lessons.merge(QueryDefinition{
+		Query: "SELECT DATEZAN, ID, AUD, NUM_PREDM, NUM_VARZAN, HALF, DELETED FROM V_LESSONS " +
+			"WHERE REGDATE BETWEEN ? AND ?%FILTERS%",
+		Columns: map[string]string{"IsDeleted": "DELETED"},
+		Filters: []string{"FACULTY <> 99"},
+	})
+	dialect := FirebirdDialect.withQueries(querySet)
+
+	t.Run("scan by column names", func(t *testing.T) {
+		db, dbMock, _ := sqlmock.New()
+		date := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		dbMock.ExpectQuery(regexp.QuoteMeta(
+			"FROM V_LESSONS WHERE REGDATE BETWEEN ? AND ? AND (FACULTY <> 99)",
+		)).WillReturnRows(
+			sqlmock.NewRows([]string{"DATEZAN", "ID", "AUD", "NUM_PREDM", "NUM_VARZAN", "HALF", "DELETED"}).
+				AddRow(date, 10, "101", 100, 3, 2, true),
+		)
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.MatchedBy(func(message kafka.Message) bool {
+			var event events.LessonEvent
+			_ = json.Unmarshal(message.Value, &event)
+			return assert.Equal(t, events.LessonEvent{
+				Id:           10,
+				DisciplineId: 100,
+				TypeId:       3,
+				Date:         date,
+				Year:         2022,
+				Semester:     2,
+				IsDeleted:    true,
+			}, event)
+		})).Return(nil).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        dialect,
+			writer:         writer,
+			writeThreshold: 10,
+		}
+
+		_, err := importer.execute(context.Background(), date, date, 2022)
+
+		assert.NoError(t, err)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("missing mapped column", func(t *testing.T) {
+		db, dbMock, _ := sqlmock.New()
+
+		dbMock.ExpectQuery("FROM V_LESSONS").WillReturnRows(
+			sqlmock.NewRows([]string{"DATEZAN", "ID", "NUM_PREDM", "NUM_VARZAN", "HALF"}),
+		)
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        dialect,
+			writer:         mocks.NewWriterInterface(t),
+			writeThreshold: 10,
+		}
+
+		_, err := importer.execute(context.Background(), time.Now(), time.Now(), 2022)
+
+		assert.EqualError(t, err, "query result has no column DELETED for field IsDeleted")
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"github.com/segmentio/kafka-go"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	rate      float64
+	tokens    float64
+	updatedAt time.Time
+}
+
+// take refills the bucket (up to one second of rate) and takes n tokens. A batch bigger than the bucket is let
+// through as a debt: the returned delay is the time needed to pay it back.
+func (bucket *tokenBucket) take(now time.Time, n float64) time.Duration {
+	if bucket.rate <= 0 {
+		return 0
+	}
+
+	if bucket.updatedAt.IsZero() {
+		bucket.tokens = bucket.rate
+	} else {
+		bucket.tokens = min(bucket.rate, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*bucket.rate)
+	}
+	bucket.updatedAt = now
+	bucket.tokens -= n
+
+	if bucket.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// RateLimiter is a token bucket on messages and bytes per second shared by the lessons, lessons state and meta writers
+// and the spool replays.
+// A nil RateLimiter does not limit anything.
+type RateLimiter struct {
+	messages tokenBucket
+	bytes    tokenBucket
+	now      func() time.Time
+	sleep    func(ctx context.Context, delay time.Duration) error
+	mutex    sync.Mutex
+}
+
+func newRateLimiter(messagesPerSecond int, bytesPerSecond int) *RateLimiter {
+	if messagesPerSecond <= 0 && bytesPerSecond <= 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		messages: tokenBucket{rate: float64(messagesPerSecond)},
+		bytes:    tokenBucket{rate: float64(bytesPerSecond)},
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// wait takes the messages from the buckets and sleeps off the debt, unless ctx is done first. It returns the delay
+// for the caller to count, as the limiter is shared by the runs of different years.
+func (limiter *RateLimiter) wait(ctx context.Context, messages []kafka.Message) (time.Duration, error) {
+	if limiter == nil || len(messages) == 0 {
+		return 0, nil
+	}
+
+	size := 0
+	for _, message := range messages {
+		size += len(message.Key) + len(message.Value)
+	}
+
+	limiter.mutex.Lock()
+	now := limiter.now()
+	delay := max(
+		limiter.messages.take(now, float64(len(messages))),
+		limiter.bytes.take(now, float64(size)),
+	)
+	limiter.mutex.Unlock()
+
+	if delay > 0 {
+		writeThrottledSeconds.Add(delay.Seconds())
+		return delay, limiter.sleep(ctx, delay)
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func newTestRateLimiter(messagesPerSecond int, bytesPerSecond int) (*RateLimiter, *[]time.Duration) {
+	now := time.Date(2023, 9, 2, 4, 0, 0, 0, time.UTC)
+	var sleeps []time.Duration
+
+	limiter := newRateLimiter(messagesPerSecond, bytesPerSecond)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(ctx context.Context, delay time.Duration) error {
+		sleeps = append(sleeps, delay)
+		now = now.Add(delay)
+		return ctx.Err()
+	}
+
+	return limiter, &sleeps
+}
+
+func waitLimiter(t *testing.T, limiter *RateLimiter, messages []kafka.Message) time.Duration {
+	delay, err := limiter.wait(context.Background(), messages)
+	assert.NoError(t, err)
+
+	return delay
+}
+
+func TestRateLimiter(t *testing.T) {
+	message := kafka.Message{Key: []byte("key"), Value: []byte("1234567")}
+
+	t.Run("disabled", func(t *testing.T) {
+		limiter := newRateLimiter(0, 0)
+
+		assert.Nil(t, limiter)
+		delay, err := limiter.wait(context.Background(), []kafka.Message{message})
+		assert.NoError(t, err)
+		assert.Zero(t, delay)
+	})
+
+	t.Run("messages per second", func(t *testing.T) {
+		limiter, sleeps := newTestRateLimiter(10, 0)
+
+		assert.Zero(t, waitLimiter(t, limiter, make([]kafka.Message, 10)))
+		assert.Equal(t, 500*time.Millisecond, waitLimiter(t, limiter, make([]kafka.Message, 5)))
+		assert.Equal(t, time.Second, waitLimiter(t, limiter, make([]kafka.Message, 10)))
+
+		assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, *sleeps)
+	})
+
+	t.Run("bytes per second", func(t *testing.T) {
+		limiter, sleeps := newTestRateLimiter(1000, 20)
+
+		assert.Zero(t, waitLimiter(t, limiter, []kafka.Message{message, message}))
+		assert.Equal(t, 2*time.Second, waitLimiter(t, limiter, []kafka.Message{message, message, message, message}))
+
+		assert.Len(t, *sleeps, 1)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		limiter := newRateLimiter(1, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := limiter.wait(ctx, make([]kafka.Message, 1))
+		assert.NoError(t, err)
+
+		startedAt := time.Now()
+		delay, err := limiter.wait(ctx, make([]kafka.Message, 60))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, time.Minute, delay.Round(time.Second))
+		assert.Less(t, time.Since(startedAt), time.Second)
+	})
+}
+
+func TestImporterWriteLessons(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	lessons := []events.LessonEvent{{Id: 10}, {Id: 11}}
+	messages := []kafka.Message{{Key: []byte("lesson")}, {Key: []byte("lesson")}}
+
+	writer := mocks.NewWriterInterface(t)
+	writer.On("WriteMessages", matchContext, messages[0], messages[1]).Return(nil).Once()
+
+	stateWriter := mocks.NewWriterInterface(t)
+	stateWriter.On("WriteMessages", matchContext, mock.Anything, mock.Anything).Return(nil).Once()
+
+	limiter, sleeps := newTestRateLimiter(2, 0)
+	importer := LessonsImporter{
+		out:         &out,
+		writer:      writer,
+		stateWriter: &LessonsStateWriter{writer: stateWriter},
+		rateLimiter: limiter,
+	}
+
+	err := importer.writeLessons(context.Background(), messages, lessons)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second}, *sleeps)
+	assert.Equal(t, time.Second, importer.throttled)
+
+	other := importer.clone()
+	other.writer = mocks.NewWriterInterface(t)
+	other.writer.(*mocks.WriterInterface).On("WriteMessages", matchContext, messages[0], messages[1]).Return(nil).Once()
+	stateWriter.On("WriteMessages", matchContext, mock.Anything, mock.Anything).Return(nil).Once()
+
+	assert.NoError(t, other.writeLessons(context.Background(), messages, lessons))
+	assert.Equal(t, 2*time.Second, other.throttled)
+	assert.Equal(t, time.Second, importer.throttled)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ReadTransaction is the secondary DB transaction that serves every read of one meta event,
+// so the lesson types and the lessons come from the same state of the DB.
+type ReadTransaction struct {
+	tx        *sql.Tx
+	Id        int64
+	StartedAt time.Time
+}
+
+// beginReadTransaction opens the shared read transaction. Chunked imports keep their own short
+// transactions per chunk, so there it does nothing and every read goes to the pool.
+func (importer *LessonsImporter) beginReadTransaction() (err error) {
+	if importer.chunkMode != "" || importer.readTransaction != nil {
+		return nil
+	}
+
+	readTransaction := &ReadTransaction{StartedAt: time.Now()}
+	readTransaction.tx, err = importer.db.BeginTx(context.Background(), importer.dialect.readTxOptions)
+	if err != nil {
+		return
+	}
+
+	if importer.dialect.transactionIdQuery != "" {
+		err = readTransaction.tx.QueryRow(importer.dialect.transactionIdQuery).Scan(&readTransaction.Id)
+		if err != nil {
+			_ = readTransaction.tx.Rollback()
+			return
+		}
+	}
+
+	importer.readTransaction = readTransaction
+	return
+}
+
+func (importer *LessonsImporter) endReadTransaction() (err error) {
+	if importer.readTransaction != nil {
+		err = importer.readTransaction.tx.Commit()
+		importer.readTransaction = nil
+	}
+
+	return
+}
+
+func (importer *LessonsImporter) source() queryer {
+	if importer.readTransaction != nil {
+		return importer.readTransaction.tx
+	}
+
+	return importer.db
+}
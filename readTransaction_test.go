@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestReadTransaction(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+
+	t.Run("lesson types and lessons in one transaction", func(t *testing.T) {
+		db, dbMock, _ := sqlmock.New()
+
+		startDatetime := time.Date(2023, 3, 5, 4, 0, 0, 0, time.UTC)
+		endDatetime := time.Date(2023, 3, 6, 4, 0, 0, 0, time.UTC)
+
+		dbMock.ExpectBegin()
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.transactionIdQuery)).WillReturnRows(
+			sqlmock.NewRows([]string{"CURRENT_TRANSACTION"}).AddRow(4242),
+		)
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.LessonTypes.build())).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "SHIRTNAME", "LONGNAME"}).AddRow(1, "Лек", "Лекція"),
+		)
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).WillReturnRows(
+			sqlmock.NewRows(expectedColumns).AddRow(10, 100, startDatetime, 1, 2, false),
+		)
+		dbMock.ExpectCommit()
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Once()
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        FirebirdDialect,
+			writer:         writer,
+			writeThreshold: 10,
+		}
+
+		assert.NoError(t, importer.beginReadTransaction())
+
+		lessonTypes, err := importer.importLessonTypes(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, lessonTypes, 1)
+
+		summary, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4242), summary.TransactionId)
+		assert.False(t, summary.TransactionStartedAt.IsZero())
+
+		assert.NoError(t, importer.endReadTransaction())
+		assert.Nil(t, importer.readTransaction)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("transaction id error", func(t *testing.T) {
+		expectedError := errors.New("expected test error")
+		db, dbMock, _ := sqlmock.New()
+
+		dbMock.ExpectBegin()
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.transactionIdQuery)).WillReturnError(expectedError)
+		dbMock.ExpectRollback()
+
+		importer := LessonsImporter{out: &out, db: db, dialect: FirebirdDialect}
+
+		assert.Equal(t, expectedError, importer.beginReadTransaction())
+		assert.Nil(t, importer.readTransaction)
+		assert.NoError(t, importer.endReadTransaction())
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("chunked import keeps own transactions", func(t *testing.T) {
+		db, dbMock, _ := sqlmock.New()
+
+		importer := LessonsImporter{out: &out, db: db, dialect: FirebirdDialect, chunkMode: DayChunkMode}
+
+		assert.NoError(t, importer.beginReadTransaction())
+		assert.Nil(t, importer.readTransaction)
+		assert.NoError(t, importer.endReadTransaction())
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+}
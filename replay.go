@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// MetaGroupId is the consumer group of the event loop on events.MetaEventsTopic.
+const MetaGroupId = "secondary-db-lessons-importer"
+
+const replayFetchMaxBytes = 1e6
+
+// groupAdmin is the part of kafka.Client the replay command needs.
+type groupAdmin interface {
+	DescribeGroups(ctx context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error)
+	Metadata(ctx context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error)
+	ListOffsets(ctx context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error)
+	OffsetFetch(ctx context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error)
+	OffsetCommit(ctx context.Context, req *kafka.OffsetCommitRequest) (*kafka.OffsetCommitResponse, error)
+	Fetch(ctx context.Context, req *kafka.FetchRequest) (*kafka.FetchResponse, error)
+}
+
+// ReplayOffsets is the committed offset of a partition, the offset replay moves it to, and the end of the partition.
+type ReplayOffsets struct {
+	Partition int
+	Committed int64
+	From      int64
+	End       int64
+}
+
+// replayCommand moves the offsets of the meta consumer group back, so the next run reprocesses the meta events
+// from --since or --from-offset. It refuses while a consumer of the group is active and lists the events first.
+func replayCommand(out io.Writer, admin groupAdmin, command Command, location *time.Location) error {
+	ctx := context.Background()
+	topic := events.MetaEventsTopic
+
+	err := checkGroupInactive(ctx, admin, MetaGroupId)
+	if err != nil {
+		return err
+	}
+
+	partitions, err := topicPartitions(ctx, admin, topic)
+	if err != nil {
+		return err
+	}
+	if command.since == "" {
+		if !containsPartition(partitions, command.partition) {
+			return errors.New("topic " + topic + " has no partition " + strconv.Itoa(command.partition))
+		}
+		partitions = []int{command.partition}
+	}
+
+	ends, err := listOffsets(ctx, admin, topic, partitions, kafka.LastOffsetOf)
+	if err != nil {
+		return err
+	}
+
+	var froms map[int]int64
+	if command.since != "" {
+		since, _ := parseReplaySince(command.since, location)
+		froms, err = listOffsets(ctx, admin, topic, partitions, func(partition int) kafka.OffsetRequest {
+			return kafka.TimeOffsetOf(partition, since)
+		})
+	} else {
+		var firsts map[int]int64
+		firsts, err = listOffsets(ctx, admin, topic, partitions, kafka.FirstOffsetOf)
+		first, end := firsts[command.partition], ends[command.partition]
+		if err == nil && (command.fromOffset < first || command.fromOffset > end) {
+			err = fmt.Errorf("offset %d is out of partition %d range %d - %d", command.fromOffset, command.partition, first, end)
+		}
+		froms = map[int]int64{command.partition: command.fromOffset}
+	}
+	if err != nil {
+		return err
+	}
+
+	committed, err := committedOffsets(ctx, admin, MetaGroupId, topic, partitions)
+	if err != nil {
+		return err
+	}
+
+	replays := make([]ReplayOffsets, len(partitions))
+	for i, partition := range partitions {
+		replays[i] = ReplayOffsets{Partition: partition, Committed: committed[partition], From: froms[partition], End: ends[partition]}
+		// no event at or after --since: nothing to replay in the partition
+		if replays[i].From < 0 {
+			replays[i].From = replays[i].End
+		}
+	}
+
+	printReplayOffsets(out, replays)
+	count, err := printReplayEvents(ctx, out, admin, topic, replays, location)
+	if err != nil {
+		return err
+	}
+
+	if command.dryRun {
+		fmt.Fprintf(out, "Dry run: %d meta events would be reprocessed, offsets are not changed\n", count)
+		return nil
+	}
+
+	if err = commitReplayOffsets(ctx, admin, MetaGroupId, topic, replays); err != nil {
+		return errors.New("Failed to reset offsets: " + err.Error())
+	}
+	fmt.Fprintf(out, "Offsets of %s consumer group are reset: %d meta events will be reprocessed\n", MetaGroupId, count)
+
+	return nil
+}
+
+func parseReplaySince(since string, location *time.Location) (time.Time, error) {
+	parsed, err := time.ParseInLocation(dateFormat, since, location)
+	if err != nil {
+		parsed, err = time.ParseInLocation(RunsDateFormat, since, location)
+	}
+
+	return parsed, err
+}
+
+// checkGroupInactive fails while any consumer is a member of the group: it would overwrite the reset offsets.
+func checkGroupInactive(ctx context.Context, admin groupAdmin, groupId string) error {
+	response, err := admin.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{groupId}})
+	if err != nil {
+		return errors.New("Failed to describe consumer group: " + err.Error())
+	}
+
+	for _, group := range response.Groups {
+		if group.Error != nil {
+			return errors.New("Failed to describe consumer group: " + group.Error.Error())
+		}
+		if len(group.Members) != 0 {
+			return fmt.Errorf(
+				"consumer group %s has %d active members (%s), stop the importer before replay",
+				groupId, len(group.Members), group.Members[0].ClientHost,
+			)
+		}
+	}
+
+	return nil
+}
+
+func topicPartitions(ctx context.Context, admin groupAdmin, topic string) ([]int, error) {
+	response, err := admin.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, errors.New("Failed to read " + topic + " metadata: " + err.Error())
+	}
+
+	var partitions []int
+	for _, metadata := range response.Topics {
+		if metadata.Error != nil {
+			return nil, errors.New("Failed to read " + topic + " metadata: " + metadata.Error.Error())
+		}
+		for _, partition := range metadata.Partitions {
+			partitions = append(partitions, partition.ID)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, errors.New("topic " + topic + " has no partitions")
+	}
+	sort.Ints(partitions)
+
+	return partitions, nil
+}
+
+func containsPartition(partitions []int, partition int) bool {
+	for _, candidate := range partitions {
+		if candidate == partition {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listOffsets resolves one offset request per partition; a time request without a later event resolves to -1.
+func listOffsets(
+	ctx context.Context, admin groupAdmin, topic string, partitions []int, request func(partition int) kafka.OffsetRequest,
+) (map[int]int64, error) {
+	requests := make([]kafka.OffsetRequest, len(partitions))
+	for i, partition := range partitions {
+		requests[i] = request(partition)
+	}
+
+	response, err := admin.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: requests}})
+	if err != nil {
+		return nil, errors.New("Failed to list offsets: " + err.Error())
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	for _, partitionOffsets := range response.Topics[topic] {
+		if partitionOffsets.Error != nil {
+			return nil, errors.New("Failed to list offsets: " + partitionOffsets.Error.Error())
+		}
+
+		offset := max(partitionOffsets.FirstOffset, partitionOffsets.LastOffset)
+		for timeOffset := range partitionOffsets.Offsets {
+			offset = timeOffset
+		}
+		offsets[partitionOffsets.Partition] = offset
+	}
+
+	return offsets, nil
+}
+
+func committedOffsets(ctx context.Context, admin groupAdmin, groupId string, topic string, partitions []int) (map[int]int64, error) {
+	response, err := admin.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: groupId, Topics: map[string][]int{topic: partitions}})
+	if err == nil {
+		err = response.Error
+	}
+	if err != nil {
+		return nil, errors.New("Failed to fetch committed offsets: " + err.Error())
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	for _, partition := range partitions {
+		offsets[partition] = -1
+	}
+	for _, partition := range response.Topics[topic] {
+		if partition.Error != nil {
+			return nil, errors.New("Failed to fetch committed offsets: " + partition.Error.Error())
+		}
+		offsets[partition.Partition] = partition.CommittedOffset
+	}
+
+	return offsets, nil
+}
+
+func commitReplayOffsets(ctx context.Context, admin groupAdmin, groupId string, topic string, replays []ReplayOffsets) error {
+	commits := make([]kafka.OffsetCommit, len(replays))
+	for i, replay := range replays {
+		commits[i] = kafka.OffsetCommit{Partition: replay.Partition, Offset: replay.From}
+	}
+
+	// generation -1 commits as a standalone client, which Kafka accepts only while the group has no members
+	response, err := admin.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      groupId,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range response.Topics[topic] {
+		if partition.Error != nil {
+			return partition.Error
+		}
+	}
+
+	return nil
+}
+
+func printReplayOffsets(out io.Writer, replays []ReplayOffsets) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PARTITION\tCOMMITTED\tFROM\tEND")
+	for _, replay := range replays {
+		committed := "-"
+		if replay.Committed >= 0 {
+			committed = strconv.FormatInt(replay.Committed, 10)
+		}
+		fmt.Fprintf(table, "%d\t%s\t%d\t%d\n", replay.Partition, committed, replay.From, replay.End)
+	}
+	_ = table.Flush()
+}
+
+// printReplayEvents lists the meta events between the new offset and the end of each partition.
+func printReplayEvents(
+	ctx context.Context, out io.Writer, admin groupAdmin, topic string, replays []ReplayOffsets, location *time.Location,
+) (count int, err error) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer table.Flush()
+	fmt.Fprintln(table, "PARTITION\tOFFSET\tTIME\tEVENT\tYEAR\tWINDOW")
+
+	for _, replay := range replays {
+		offset := replay.From
+		for offset < replay.End {
+			var response *kafka.FetchResponse
+			response, err = admin.Fetch(ctx, &kafka.FetchRequest{
+				Topic: topic, Partition: replay.Partition, Offset: offset, MaxBytes: replayFetchMaxBytes,
+			})
+			if err == nil {
+				err = response.Error
+			}
+			if err != nil {
+				return count, errors.New("Failed to fetch meta events: " + err.Error())
+			}
+
+			next := offset
+			for next < replay.End {
+				record, readErr := response.Records.ReadRecord()
+				if readErr != nil {
+					break
+				}
+				if record.Offset < offset {
+					continue
+				}
+				next = record.Offset + 1
+
+				m := kafka.Message{Offset: record.Offset}
+				if record.Key != nil {
+					m.Key, _ = kafka.ReadAll(record.Key)
+				}
+				if record.Value != nil {
+					m.Value, _ = kafka.ReadAll(record.Value)
+				}
+				fmt.Fprintf(
+					table, "%d\t%d\t%s\t%s\n",
+					replay.Partition, m.Offset, record.Time.In(location).Format(dateFormat), describeMetaEvent(m),
+				)
+				count++
+			}
+
+			// a compacted or truncated tail has no records up to the end
+			if next == offset {
+				break
+			}
+			offset = next
+		}
+	}
+
+	return
+}
+
+func describeMetaEvent(m kafka.Message) string {
+	if string(m.Key) == events.SecondaryDbLoadedEventName {
+		var event events.SecondaryDbLoadedEvent
+		_ = json.Unmarshal(m.Value, &event)
+		return fmt.Sprintf(
+			"%s\t%d\t%s - %s", m.Key, event.Year,
+			event.PreviousSecondaryDatabaseDatetime.Format(dateFormat), event.CurrentSecondaryDatabaseDatetime.Format(dateFormat),
+		)
+	}
+
+	if year, hasWork := messageYear(m); hasWork {
+		return fmt.Sprintf("%s\t%d\t-", m.Key, year)
+	}
+
+	return string(m.Key) + "\t-\t-"
+}
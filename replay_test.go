@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeMetaRecord struct {
+	offset int64
+	time   time.Time
+	key    string
+	value  []byte
+}
+
+type fakeGroupAdmin struct {
+	members   []kafka.DescribeGroupsResponseMember
+	records   []fakeMetaRecord
+	committed int64
+	sinceAt   time.Time
+	commits   []*kafka.OffsetCommitRequest
+	fetchErr  error
+}
+
+func (admin *fakeGroupAdmin) DescribeGroups(_ context.Context, req *kafka.DescribeGroupsRequest) (*kafka.DescribeGroupsResponse, error) {
+	return &kafka.DescribeGroupsResponse{
+		Groups: []kafka.DescribeGroupsResponseGroup{{GroupID: req.GroupIDs[0], Members: admin.members}},
+	}, nil
+}
+
+func (admin *fakeGroupAdmin) Metadata(_ context.Context, req *kafka.MetadataRequest) (*kafka.MetadataResponse, error) {
+	return &kafka.MetadataResponse{
+		Topics: []kafka.Topic{{Name: req.Topics[0], Partitions: []kafka.Partition{{Topic: req.Topics[0], ID: 0}}}},
+	}, nil
+}
+
+func (admin *fakeGroupAdmin) ListOffsets(_ context.Context, req *kafka.ListOffsetsRequest) (*kafka.ListOffsetsResponse, error) {
+	response := &kafka.ListOffsetsResponse{Topics: make(map[string][]kafka.PartitionOffsets)}
+	for topic, requests := range req.Topics {
+		for _, request := range requests {
+			offsets := kafka.PartitionOffsets{Partition: request.Partition, FirstOffset: -1, LastOffset: -1, Offsets: map[int64]time.Time{}}
+			switch request.Timestamp {
+			case kafka.FirstOffset:
+				offsets.FirstOffset = admin.records[0].offset
+			case kafka.LastOffset:
+				offsets.LastOffset = admin.records[len(admin.records)-1].offset + 1
+			default:
+				offset := int64(-1)
+				for _, record := range admin.records {
+					if !record.time.Before(time.UnixMilli(request.Timestamp)) {
+						offset = record.offset
+						break
+					}
+				}
+				offsets.Offsets[offset] = time.UnixMilli(request.Timestamp)
+			}
+			response.Topics[topic] = append(response.Topics[topic], offsets)
+		}
+	}
+
+	return response, nil
+}
+
+func (admin *fakeGroupAdmin) OffsetFetch(_ context.Context, req *kafka.OffsetFetchRequest) (*kafka.OffsetFetchResponse, error) {
+	return &kafka.OffsetFetchResponse{
+		Topics: map[string][]kafka.OffsetFetchPartition{
+			events.MetaEventsTopic: {{Partition: 0, CommittedOffset: admin.committed}},
+		},
+	}, nil
+}
+
+func (admin *fakeGroupAdmin) OffsetCommit(_ context.Context, req *kafka.OffsetCommitRequest) (*kafka.OffsetCommitResponse, error) {
+	admin.commits = append(admin.commits, req)
+	return &kafka.OffsetCommitResponse{}, nil
+}
+
+func (admin *fakeGroupAdmin) Fetch(_ context.Context, req *kafka.FetchRequest) (*kafka.FetchResponse, error) {
+	if admin.fetchErr != nil {
+		return nil, admin.fetchErr
+	}
+
+	// like Kafka, return the whole batch that contains the requested offset
+	records := make([]kafka.Record, len(admin.records))
+	for i, record := range admin.records {
+		records[i] = kafka.Record{
+			Offset: record.offset,
+			Time:   record.time,
+			Key:    kafka.NewBytes([]byte(record.key)),
+			Value:  kafka.NewBytes(record.value),
+		}
+	}
+
+	return &kafka.FetchResponse{Topic: req.Topic, Partition: req.Partition, Records: kafka.NewRecordReader(records...)}, nil
+}
+
+func newFakeGroupAdmin() *fakeGroupAdmin {
+	loadedEvent := func(day int) []byte {
+		payload, _ := json.Marshal(events.SecondaryDbLoadedEvent{
+			PreviousSecondaryDatabaseDatetime: time.Date(2023, 9, day-1, 4, 0, 0, 0, time.UTC),
+			CurrentSecondaryDatabaseDatetime:  time.Date(2023, 9, day, 4, 0, 0, 0, time.UTC),
+			Year:                              2023,
+		})
+		return payload
+	}
+	snapshotRequest, _ := json.Marshal(LessonsSnapshotRequestedEvent{Year: 2022})
+
+	record := func(offset int64, day int, key string, value []byte) fakeMetaRecord {
+		return fakeMetaRecord{offset: offset, time: time.Date(2023, 9, day, 4, 5, 0, 0, time.UTC), key: key, value: value}
+	}
+
+	return &fakeGroupAdmin{
+		committed: 14,
+		records: []fakeMetaRecord{
+			record(10, 10, events.SecondaryDbLoadedEventName, loadedEvent(10)),
+			record(11, 11, events.SecondaryDbLoadedEventName, loadedEvent(11)),
+			record(12, 12, LessonsSnapshotRequestedEventName, snapshotRequest),
+			record(13, 13, events.SecondaryDbLessonProcessedEventName, loadedEvent(13)),
+		},
+	}
+}
+
+func TestReplayCommand(t *testing.T) {
+	t.Run("since", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		admin := newFakeGroupAdmin()
+
+		command := Command{name: ReplayCommandName, since: "2023-09-11", fromOffset: -1}
+		assert.NoError(t, replayCommand(out, admin, command, time.UTC))
+
+		assert.Len(t, admin.commits, 1)
+		assert.Equal(t, MetaGroupId, admin.commits[0].GroupID)
+		assert.Equal(t, -1, admin.commits[0].GenerationID)
+		assert.Equal(t, []kafka.OffsetCommit{{Partition: 0, Offset: 11}}, admin.commits[0].Topics[events.MetaEventsTopic])
+
+		assert.Contains(t, out.String(), "PARTITION  COMMITTED  FROM  END\n0          14         11    14\n")
+		assert.Contains(t, out.String(), "0          11      2023-09-11 04:05:00  SecondaryDbLoadedEvent           2023  2023-09-10 04:00:00 - 2023-09-11 04:00:00")
+		assert.Contains(t, out.String(), "0          12      2023-09-12 04:05:00  "+LessonsSnapshotRequestedEventName)
+		assert.NotContains(t, out.String(), "2023-09-10 04:05:00")
+		assert.Contains(t, out.String(), "Offsets of secondary-db-lessons-importer consumer group are reset: 3 meta events will be reprocessed")
+	})
+
+	t.Run("since after the last event", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		admin := newFakeGroupAdmin()
+
+		command := Command{name: ReplayCommandName, since: "2023-10-01", fromOffset: -1}
+		assert.NoError(t, replayCommand(out, admin, command, time.UTC))
+
+		assert.Equal(t, []kafka.OffsetCommit{{Partition: 0, Offset: 14}}, admin.commits[0].Topics[events.MetaEventsTopic])
+		assert.Contains(t, out.String(), "0 meta events will be reprocessed")
+	})
+
+	t.Run("from offset dry run", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		admin := newFakeGroupAdmin()
+
+		command := Command{name: ReplayCommandName, fromOffset: 12, dryRun: true}
+		assert.NoError(t, replayCommand(out, admin, command, time.UTC))
+
+		assert.Empty(t, admin.commits)
+		assert.Contains(t, out.String(), "Dry run: 2 meta events would be reprocessed, offsets are not changed")
+	})
+
+	t.Run("offset out of range", func(t *testing.T) {
+		admin := newFakeGroupAdmin()
+
+		command := Command{name: ReplayCommandName, fromOffset: 3}
+		err := replayCommand(&bytes.Buffer{}, admin, command, time.UTC)
+
+		assert.EqualError(t, err, "offset 3 is out of partition 0 range 10 - 14")
+		assert.Empty(t, admin.commits)
+	})
+
+	t.Run("unknown partition", func(t *testing.T) {
+		command := Command{name: ReplayCommandName, fromOffset: 12, partition: 2}
+		err := replayCommand(&bytes.Buffer{}, newFakeGroupAdmin(), command, time.UTC)
+
+		assert.EqualError(t, err, "topic "+events.MetaEventsTopic+" has no partition 2")
+	})
+
+	t.Run("active consumer", func(t *testing.T) {
+		admin := newFakeGroupAdmin()
+		admin.members = []kafka.DescribeGroupsResponseMember{{MemberID: "importer-1", ClientHost: "/10.0.0.5"}}
+
+		command := Command{name: ReplayCommandName, since: "2023-09-11", fromOffset: -1}
+		err := replayCommand(&bytes.Buffer{}, admin, command, time.UTC)
+
+		assert.EqualError(t, err, "consumer group secondary-db-lessons-importer has 1 active members (/10.0.0.5), stop the importer before replay")
+		assert.Empty(t, admin.commits)
+	})
+
+	t.Run("fetch error", func(t *testing.T) {
+		admin := newFakeGroupAdmin()
+		admin.fetchErr = errors.New("broker is down")
+
+		command := Command{name: ReplayCommandName, since: "2023-09-11", fromOffset: -1}
+		err := replayCommand(&bytes.Buffer{}, admin, command, time.UTC)
+
+		assert.EqualError(t, err, "Failed to fetch meta events: broker is down")
+		assert.Empty(t, admin.commits)
+	})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+var runsBucket = []byte("runs")
+
+const ImportRunKind = "import"
+const SnapshotRunKind = "snapshot"
+const ReconcileRunKind = "reconcile"
+
+const LessonTypesRunKind = "lesson-types"
+
+const MetaEventRunTrigger = "meta-event"
+const CliRunTrigger = "cli"
+const ScheduleRunTrigger = "schedule"
+const AdminRunTrigger = "admin"
+
+// RunRecord is one import, snapshot or reconcile run. Start and End are the import window of the meta event;
+// a record without FinishedAt belongs to a run that is still going or was interrupted.
+type RunRecord struct {
+	Id         uint64
+	Kind       string
+	Trigger    string
+	Year       int
+	Start      time.Time
+	End        time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Rows       int
+	Batches    int
+	Error      string `json:",omitempty"`
+}
+
+type RunFilter struct {
+	Year  int
+	Date  time.Time
+	Limit int
+}
+
+type RunHistoryStoreInterface interface {
+	RunHistoryReader
+	save(record *RunRecord) error
+}
+
+// RunHistoryReader is what the runs command reads: the state DB or the admin API of the running importer.
+type RunHistoryReader interface {
+	list(filter RunFilter) ([]RunRecord, error)
+	get(id uint64) (*RunRecord, error)
+}
+
+// RunHistoryStore keeps every run keyed by a growing ID, so the newest runs are at the end of the bucket.
+type RunHistoryStore struct {
+	db *bbolt.DB
+}
+
+// RunProgress counts what the current run has written so far; the importer finds it in the run context.
+type RunProgress struct {
+	lessons atomic.Int64
+	batches atomic.Int64
+}
+
+type runProgressKey struct{}
+
+func withRunProgress(ctx context.Context, progress *RunProgress) context.Context {
+	return context.WithValue(ctx, runProgressKey{}, progress)
+}
+
+func runProgressFromContext(ctx context.Context) *RunProgress {
+	progress, _ := ctx.Value(runProgressKey{}).(*RunProgress)
+	return progress
+}
+
+func (progress *RunProgress) add(lessons int) {
+	if progress != nil {
+		progress.lessons.Add(int64(lessons))
+		progress.batches.Add(1)
+	}
+}
+
+func runIdKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// save stores the record and assigns the ID of a new one.
+func (store *RunHistoryStore) save(record *RunRecord) error {
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err == nil && record.Id == 0 {
+			record.Id, err = bucket.NextSequence()
+		}
+
+		var value []byte
+		if err == nil {
+			value, err = json.Marshal(record)
+		}
+		if err == nil {
+			err = bucket.Put(runIdKey(record.Id), value)
+		}
+
+		return err
+	})
+}
+
+// list returns the newest runs first. A filter date selects runs whose window overlaps that day.
+func (store *RunHistoryStore) list(filter RunFilter) (records []RunRecord, err error) {
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil && (filter.Limit <= 0 || len(records) < filter.Limit); key, value = cursor.Prev() {
+			var record RunRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if filter.matches(record) {
+				records = append(records, record)
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func (store *RunHistoryStore) get(id uint64) (record *RunRecord, err error) {
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get(runIdKey(id))
+		if value == nil {
+			return nil
+		}
+
+		record = &RunRecord{}
+		return json.Unmarshal(value, record)
+	})
+
+	return
+}
+
+func (filter RunFilter) matches(record RunRecord) bool {
+	if filter.Year != 0 && record.Year != filter.Year {
+		return false
+	}
+
+	if !filter.Date.IsZero() {
+		dayEnd := filter.Date.AddDate(0, 0, 1)
+		return !record.Start.IsZero() && record.Start.Before(dayEnd) && !record.End.Before(filter.Date)
+	}
+
+	return true
+}
+
+func runCommand(out io.Writer, store RunHistoryReader, command Command, location *time.Location) error {
+	if command.action == RunsShowAction {
+		record, err := store.get(command.runId)
+		if err == nil && record == nil {
+			err = errors.New("run " + strconv.FormatUint(command.runId, 10) + " not found")
+		}
+		if err == nil {
+			printRun(out, *record)
+		}
+
+		return err
+	}
+
+	filter := RunFilter{Year: command.year, Limit: command.limit}
+	if command.date != "" {
+		filter.Date, _ = time.ParseInLocation(RunsDateFormat, command.date, location)
+	}
+
+	records, err := store.list(filter)
+	if err == nil {
+		printRuns(out, records)
+	}
+
+	return err
+}
+
+func printRuns(out io.Writer, records []RunRecord) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tKIND\tTRIGGER\tYEAR\tWINDOW\tSTARTED\tDURATION\tROWS\tBATCHES\tERROR")
+	for _, record := range records {
+		fmt.Fprintf(
+			table, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			record.Id, record.Kind, record.Trigger, record.Year, formatRunWindow(record),
+			record.StartedAt.Format(dateFormat), formatRunDuration(record), record.Rows, record.Batches, record.Error,
+		)
+	}
+	_ = table.Flush()
+}
+
+func printRun(out io.Writer, record RunRecord) {
+	fmt.Fprintf(out, "ID:       %d\n", record.Id)
+	fmt.Fprintf(out, "Kind:     %s\n", record.Kind)
+	fmt.Fprintf(out, "Trigger:  %s\n", record.Trigger)
+	fmt.Fprintf(out, "Year:     %d\n", record.Year)
+	fmt.Fprintf(out, "Window:   %s\n", formatRunWindow(record))
+	fmt.Fprintf(out, "Started:  %s\n", record.StartedAt.Format(dateFormat))
+	fmt.Fprintf(out, "Duration: %s\n", formatRunDuration(record))
+	fmt.Fprintf(out, "Rows:     %d\n", record.Rows)
+	fmt.Fprintf(out, "Batches:  %d\n", record.Batches)
+	if record.Error != "" {
+		fmt.Fprintf(out, "Error:    %s\n", record.Error)
+	}
+}
+
+func formatRunWindow(record RunRecord) string {
+	if record.Start.IsZero() {
+		return "-"
+	}
+
+	return record.Start.Format(dateFormat) + " - " + record.End.Format(dateFormat)
+}
+
+func formatRunDuration(record RunRecord) string {
+	if record.FinishedAt.IsZero() {
+		return "unfinished"
+	}
+
+	return record.FinishedAt.Sub(record.StartedAt).Round(time.Millisecond).String()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestRunHistoryStore(t *testing.T) {
+	stateDb, err := openStateDb(t.TempDir())
+	assert.NoError(t, err)
+	defer stateDb.Close()
+
+	store := &RunHistoryStore{db: stateDb}
+
+	records, err := store.list(RunFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	first := &RunRecord{
+		Kind:    ImportRunKind,
+		Trigger: MetaEventRunTrigger,
+		Year:    2022,
+		Start:   time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC),
+		End:     time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC),
+	}
+	second := &RunRecord{
+		Kind:    ImportRunKind,
+		Trigger: MetaEventRunTrigger,
+		Year:    2022,
+		Start:   time.Date(2023, 3, 15, 4, 0, 0, 0, time.UTC),
+		End:     time.Date(2023, 3, 16, 4, 0, 0, 0, time.UTC),
+	}
+	snapshot := &RunRecord{Kind: SnapshotRunKind, Trigger: CliRunTrigger, Year: 2023}
+
+	assert.NoError(t, store.save(first))
+	assert.NoError(t, store.save(second))
+	assert.NoError(t, store.save(snapshot))
+	assert.Equal(t, uint64(1), first.Id)
+	assert.Equal(t, uint64(3), snapshot.Id)
+
+	first.Rows = 120
+	first.Error = "failed"
+	assert.NoError(t, store.save(first))
+
+	record, err := store.get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 120, record.Rows)
+	assert.Equal(t, "failed", record.Error)
+	assert.True(t, first.Start.Equal(record.Start))
+
+	record, err = store.get(10)
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	records, err = store.list(RunFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2, 1}, runIds(records))
+
+	records, err = store.list(RunFilter{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2}, runIds(records))
+
+	records, err = store.list(RunFilter{Year: 2022})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2, 1}, runIds(records))
+
+	records, err = store.list(RunFilter{Date: time.Date(2023, 3, 14, 0, 0, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, runIds(records))
+
+	records, err = store.list(RunFilter{Date: time.Date(2023, 3, 20, 0, 0, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestRunCommand(t *testing.T) {
+	stateDb, err := openStateDb(t.TempDir())
+	assert.NoError(t, err)
+	defer stateDb.Close()
+
+	store := &RunHistoryStore{db: stateDb}
+	startedAt := time.Date(2023, 3, 14, 5, 0, 0, 0, time.UTC)
+	assert.NoError(t, store.save(&RunRecord{
+		Kind:       ImportRunKind,
+		Trigger:    MetaEventRunTrigger,
+		Year:       2022,
+		Start:      time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC),
+		End:        time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC),
+		StartedAt:  startedAt,
+		FinishedAt: startedAt.Add(1500 * time.Millisecond),
+		Rows:       42,
+		Batches:    2,
+	}))
+	assert.NoError(t, store.save(&RunRecord{
+		Kind:      ReconcileRunKind,
+		Trigger:   ScheduleRunTrigger,
+		Year:      2022,
+		StartedAt: startedAt.Add(time.Hour),
+	}))
+
+	t.Run("list", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCommand(&out, store, Command{name: RunsCommandName, action: RunsListAction, date: "2023-03-14"}, time.UTC)
+
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			"ID  KIND    TRIGGER     YEAR  WINDOW                                     STARTED              DURATION  ROWS  BATCHES  ERROR\n"+
+				"1   import  meta-event  2022  2023-03-13 04:00:00 - 2023-03-14 04:00:00  2023-03-14 05:00:00  1.5s      42    2        \n",
+			out.String(),
+		)
+	})
+
+	t.Run("show", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCommand(&out, store, Command{name: RunsCommandName, action: RunsShowAction, runId: 2}, time.UTC)
+
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "Kind:     reconcile\n")
+		assert.Contains(t, out.String(), "Window:   -\n")
+		assert.Contains(t, out.String(), "Duration: unfinished\n")
+	})
+
+	t.Run("show unknown", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runCommand(&out, store, Command{name: RunsCommandName, action: RunsShowAction, runId: 5}, time.UTC)
+
+		assert.EqualError(t, err, "run 5 not found")
+	})
+}
+
+func TestEventLoopRunHistory(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	breakLoopError := errors.New("breakLoop")
+	expectedError := errors.New("expected error")
+
+	stateDb, err := openStateDb(t.TempDir())
+	assert.NoError(t, err)
+	defer stateDb.Close()
+	store := &RunHistoryStore{db: stateDb}
+
+	event := events.SecondaryDbLoadedEvent{
+		PreviousSecondaryDatabaseDatetime: time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC),
+		CurrentSecondaryDatabaseDatetime:  time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC),
+		Year:                              2022,
+	}
+	payload, _ := json.Marshal(event)
+	message := kafka.Message{Key: []byte(events.SecondaryDbLoadedEventName), Value: payload}
+
+	metaEventbus := NewMockMetaEventbusInterface(t)
+	metaEventbus.On("sendLessonTypesList", matchContext, mock.Anything, event.Year).Return(nil)
+	metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, event).Return(nil)
+
+	reader := mocks.NewReaderInterface(t)
+	reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+	reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError)
+	reader.On("CommitMessages", matchContext, message).Return(nil)
+
+	importer := NewMockImporterInterface(t)
+	importer.On("beginReadTransaction").Return(nil)
+	importer.On("importLessonTypes", matchContext).Return([]events.LessonType{{Id: 30, ShortName: "Лек", LongName: "Лекція"}}, nil)
+	importer.On("endReadTransaction").Return(nil)
+	importer.On("execute", matchContext, event.PreviousSecondaryDatabaseDatetime, event.CurrentSecondaryDatabaseDatetime, event.Year).
+		Return(ImportSummary{Lessons: 42, Batches: 2}, nil)
+	importer.On("reconcile", matchContext, 2023).Return(0, expectedError)
+
+	eventLoop := EventLoop{
+		out:          &out,
+		metaEventbus: metaEventbus,
+		reader:       reader,
+		importer:     importer,
+		runHistory:   store,
+		redactor:     newRedactor("masterkey"),
+	}
+
+	assert.Equal(t, breakLoopError, eventLoop.execute(context.Background()))
+	_, err = eventLoop.runReconcile(context.Background(), 2023, CliRunTrigger)
+	assert.Equal(t, expectedError, err)
+	importer.On("reconcile", matchContext, 2024).Return(0, errors.New("login SYSDBA:masterkey failed"))
+	_, _ = eventLoop.runReconcile(context.Background(), 2024, CliRunTrigger)
+
+	records, err := store.list(RunFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "login SYSDBA:*** failed", records[0].Error)
+
+	reconcileRun, importRun := records[1], records[2]
+	assert.Equal(t, ImportRunKind, importRun.Kind)
+	assert.Equal(t, MetaEventRunTrigger, importRun.Trigger)
+	assert.Equal(t, 2022, importRun.Year)
+	assert.True(t, event.PreviousSecondaryDatabaseDatetime.Equal(importRun.Start))
+	assert.True(t, event.CurrentSecondaryDatabaseDatetime.Equal(importRun.End))
+	assert.Equal(t, 42, importRun.Rows)
+	assert.Equal(t, 2, importRun.Batches)
+	assert.Empty(t, importRun.Error)
+	assert.False(t, importRun.FinishedAt.Before(importRun.StartedAt))
+
+	assert.Equal(t, ReconcileRunKind, reconcileRun.Kind)
+	assert.Equal(t, CliRunTrigger, reconcileRun.Trigger)
+	assert.Equal(t, "expected error", reconcileRun.Error)
+}
+
+func runIds(records []RunRecord) []uint64 {
+	ids := make([]uint64, len(records))
+	for i, record := range records {
+		ids[i] = record.Id
+	}
+
+	return ids
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FileSchemaRegistryScheme = "file://"
+
+type SchemaRegistryInterface interface {
+	register(subject string, schemaType string, schema string) (int, error)
+}
+
+func newSchemaRegistry(registryUrl string, timeout time.Duration) SchemaRegistryInterface {
+	if strings.HasPrefix(registryUrl, FileSchemaRegistryScheme) {
+		return &FileSchemaRegistry{path: strings.TrimPrefix(registryUrl, FileSchemaRegistryScheme)}
+	}
+
+	return &HttpSchemaRegistry{
+		url: strings.TrimSuffix(registryUrl, "/"),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// HttpSchemaRegistry registers schemas with the Confluent compatible REST API. Registering an already known
+// schema returns its existing ID.
+type HttpSchemaRegistry struct {
+	url    string
+	client *http.Client
+}
+
+type registerSchemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+type registerSchemaResponse struct {
+	Id int `json:"id"`
+}
+
+func (registry *HttpSchemaRegistry) register(subject string, schemaType string, schema string) (int, error) {
+	payload, err := json.Marshal(registerSchemaRequest{Schema: schema, SchemaType: schemaType})
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(
+		context.Background(), http.MethodPost,
+		registry.url+"/subjects/"+url.PathEscape(subject)+"/versions", bytes.NewReader(payload),
+	)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+
+	response, err := registry.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return 0, fmt.Errorf("schema registry responded with status %s: %s", response.Status, bytes.TrimSpace(body))
+	}
+
+	var registered registerSchemaResponse
+	if err = json.NewDecoder(response.Body).Decode(&registered); err != nil {
+		return 0, errors.New("wrong schema registry response: " + err.Error())
+	}
+
+	return registered.Id, nil
+}
+
+type registeredSchema struct {
+	Id         int    `json:"id"`
+	SchemaType string `json:"schemaType"`
+	Schema     string `json:"schema"`
+}
+
+// FileSchemaRegistry is a local stand-in for a schema registry: subjects and their schemas live in one JSON file,
+// IDs are assigned in registration order across all subjects.
+type FileSchemaRegistry struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func (registry *FileSchemaRegistry) register(subject string, schemaType string, schema string) (int, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	subjects := make(map[string][]registeredSchema)
+	content, err := os.ReadFile(registry.path)
+	if err == nil {
+		err = json.Unmarshal(content, &subjects)
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	lastId := 0
+	for _, schemas := range subjects {
+		for _, registered := range schemas {
+			if registered.Id > lastId {
+				lastId = registered.Id
+			}
+		}
+	}
+
+	for _, registered := range subjects[subject] {
+		if registered.Schema == schema && registered.SchemaType == schemaType {
+			return registered.Id, nil
+		}
+	}
+
+	subjects[subject] = append(subjects[subject], registeredSchema{Id: lastId + 1, SchemaType: schemaType, Schema: schema})
+	content, err = json.MarshalIndent(subjects, "", "  ")
+	if err == nil {
+		err = os.WriteFile(registry.path+".tmp", content, 0644)
+	}
+	if err == nil {
+		err = os.Rename(registry.path+".tmp", registry.path)
+	}
+
+	return lastId + 1, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewSchemaRegistry(t *testing.T) {
+	assert.Equal(t, &FileSchemaRegistry{path: "/tmp/schemas.json"}, newSchemaRegistry("file:///tmp/schemas.json", time.Second))
+	assert.IsType(t, &HttpSchemaRegistry{}, newSchemaRegistry("http://registry:8081/", time.Second))
+}
+
+func TestHttpSchemaRegistry(t *testing.T) {
+	t.Run("register schema", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			var body registerSchemaRequest
+			assert.Equal(t, "/subjects/LessonEvent/versions", request.URL.Path)
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+			assert.Equal(t, registerSchemaRequest{Schema: "schema", SchemaType: "AVRO"}, body)
+
+			_, _ = writer.Write([]byte(`{"id": 7}`))
+		}))
+		defer server.Close()
+
+		id, err := newSchemaRegistry(server.URL, time.Second).register("LessonEvent", "AVRO", "schema")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 7, id)
+	})
+
+	t.Run("incompatible schema", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusConflict)
+			_, _ = writer.Write([]byte(`{"error_code": 409}`))
+		}))
+		defer server.Close()
+
+		_, err := newSchemaRegistry(server.URL, time.Second).register("LessonEvent", "AVRO", "schema")
+
+		assert.EqualError(t, err, `schema registry responded with status 409 Conflict: {"error_code": 409}`)
+	})
+}
+
+func TestFileSchemaRegistry(t *testing.T) {
+	registry := &FileSchemaRegistry{path: filepath.Join(t.TempDir(), "schemas.json")}
+
+	id, err := registry.register("LessonEvent", "AVRO", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	id, err = registry.register("LessonTypesList", "AVRO", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, id)
+
+	id, err = registry.register("LessonEvent", "AVRO", "v2")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, id)
+
+	reopened := &FileSchemaRegistry{path: registry.path}
+	id, err = reopened.register("LessonEvent", "AVRO", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+}
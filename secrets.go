@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const SecretFileSuffix = "_FILE"
+
+const redactedSecret = "***"
+
+// secretEnvNames may be given as NAME_FILE instead of NAME, e.g. a Docker or Kubernetes secret mounted as a file.
+var secretEnvNames = []string{"SECONDARY_DEKANAT_DB_DSN", "ADMIN_TOKEN", "OUTPUT_SINK_URL", "SCHEMA_REGISTRY_URL"}
+
+var dsnPasswordPattern = regexp.MustCompile(`(?i)\bpassword\s*=\s*('[^']*'|[^\s;]+)`)
+
+// loadSecretEnvs reads every secret from its env var or from the file named by its _FILE var.
+func loadSecretEnvs() (map[string]string, error) {
+	secrets := make(map[string]string, len(secretEnvNames))
+	for _, name := range secretEnvNames {
+		value := os.Getenv(name)
+		filename := os.Getenv(name + SecretFileSuffix)
+		if filename != "" && value != "" {
+			return nil, errors.New("both " + name + " and " + name + SecretFileSuffix + " are set")
+		}
+
+		if filename != "" {
+			content, err := os.ReadFile(filename)
+			if err != nil {
+				return nil, errors.New("failed to read " + name + SecretFileSuffix + ": " + err.Error())
+			}
+			value = strings.TrimRight(string(content), "\r\n")
+		}
+		secrets[name] = value
+	}
+
+	return secrets, nil
+}
+
+// dsnPassword finds the password in a URL DSN, in a key=value DSN or in a Firebird user:password@host/database DSN.
+func dsnPassword(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		return urlPassword(dsn)
+	}
+
+	if match := dsnPasswordPattern.FindStringSubmatch(dsn); match != nil {
+		return strings.Trim(match[1], "'")
+	}
+
+	at := strings.LastIndex(dsn, "@")
+	if at == -1 {
+		return ""
+	}
+	_, password, _ := strings.Cut(dsn[:at], ":")
+
+	return password
+}
+
+func urlPassword(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.User == nil {
+		return ""
+	}
+	password, _ := parsed.User.Password()
+
+	return password
+}
+
+// secrets lists the credentials of the config that must never reach the output. Drivers quote the DSN and URLs
+// whole as well as the passwords alone, so both are listed.
+func (config Config) secrets() []string {
+	return []string{
+		config.secondaryDekanatDbDSN,
+		dsnPassword(config.secondaryDekanatDbDSN),
+		config.adminToken,
+		config.outputSinkUrl,
+		urlPassword(config.outputSinkUrl),
+		config.schemaRegistryUrl,
+		urlPassword(config.schemaRegistryUrl),
+	}
+}
+
+// String keeps the secrets out of config dumps.
+func (config Config) String() string {
+	type plainConfig Config
+
+	return newRedactor(config.secrets()...).redact(fmt.Sprintf("%+v", plainConfig(config)))
+}
+
+func (config Config) GoString() string {
+	return config.String()
+}
+
+// Redactor masks known secrets in log lines and error messages. A secret is masked only as a whole word,
+// so a short password such as "db" does not mask every "db" in the output.
+type Redactor struct {
+	secrets *regexp.Regexp
+}
+
+func newRedactor(secrets ...string) *Redactor {
+	var matches []string
+	for _, secret := range secrets {
+		if secret != "" {
+			matches = append(matches, secret)
+			// drivers quote or escape the DSN in their errors
+			if escaped := url.QueryEscape(secret); escaped != secret {
+				matches = append(matches, escaped)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return &Redactor{}
+	}
+
+	// the leftmost-first alternation prefers the first listed secret at a position, so a whole DSN wins over its password
+	sort.SliceStable(matches, func(i, j int) bool { return len(matches[i]) > len(matches[j]) })
+	for i, match := range matches {
+		matches[i] = regexp.QuoteMeta(match)
+	}
+
+	return &Redactor{secrets: regexp.MustCompile(strings.Join(matches, "|"))}
+}
+
+func (redactor *Redactor) redact(text string) string {
+	if redactor == nil || redactor.secrets == nil {
+		return text
+	}
+
+	var redacted strings.Builder
+	written := 0
+	for offset := 0; offset < len(text); {
+		match := redactor.secrets.FindStringIndex(text[offset:])
+		if match == nil {
+			break
+		}
+		start, end := offset+match[0], offset+match[1]
+		if !isSecretBoundary(text, start) || !isSecretBoundary(text, end) {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			offset = start + size
+			continue
+		}
+
+		redacted.WriteString(text[written:start])
+		redacted.WriteString(redactedSecret)
+		written, offset = end, end
+	}
+	if written == 0 {
+		return text
+	}
+	redacted.WriteString(text[written:])
+
+	return redacted.String()
+}
+
+// isSecretBoundary is a word boundary like \b in regexp, for letters and digits of any script.
+func isSecretBoundary(text string, position int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:position])
+	after, _ := utf8.DecodeRuneInString(text[position:])
+
+	return position == 0 || position == len(text) || !isWordRune(before) || !isWordRune(after)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (redactor *Redactor) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	message := redactor.redact(err.Error())
+	if message == err.Error() {
+		return err
+	}
+
+	return errors.New(message)
+}
+
+// writer redacts each write on its own; the app writes whole lines, so a secret is never split between writes.
+func (redactor *Redactor) writer(out io.Writer) io.Writer {
+	return &redactingWriter{out: out, redactor: redactor}
+}
+
+type redactingWriter struct {
+	out      io.Writer
+	redactor *Redactor
+}
+
+func (writer *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(writer.out, writer.redactor.redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
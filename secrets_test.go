@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSecretEnvs(t *testing.T) {
+	t.Run("from file", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "dsn")
+		assert.NoError(t, os.WriteFile(filename, []byte("SYSDBA:masterkey@db/dekanat\n"), 0600))
+		t.Setenv("SECONDARY_DEKANAT_DB_DSN", "")
+		t.Setenv("SECONDARY_DEKANAT_DB_DSN_FILE", filename)
+		t.Setenv("ADMIN_TOKEN", "plain-token")
+
+		secrets, err := loadSecretEnvs()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "SYSDBA:masterkey@db/dekanat", secrets["SECONDARY_DEKANAT_DB_DSN"])
+		assert.Equal(t, "plain-token", secrets["ADMIN_TOKEN"])
+	})
+
+	t.Run("both set", func(t *testing.T) {
+		t.Setenv("ADMIN_TOKEN", "plain-token")
+		t.Setenv("ADMIN_TOKEN_FILE", "/run/secrets/admin_token")
+
+		_, err := loadSecretEnvs()
+
+		assert.EqualError(t, err, "both ADMIN_TOKEN and ADMIN_TOKEN_FILE are set")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "missing")
+		t.Setenv("SCHEMA_REGISTRY_URL_FILE", filename)
+
+		_, err := loadSecretEnvs()
+
+		assert.EqualError(t, err, "failed to read SCHEMA_REGISTRY_URL_FILE: open "+filename+": no such file or directory")
+	})
+}
+
+func TestDsnPassword(t *testing.T) {
+	assert.Equal(t, "masterkey", dsnPassword("SYSDBA:masterkey@db:3050/dekanat"))
+	assert.Equal(t, "p@ss:word", dsnPassword("SYSDBA:p@ss:word@db/dekanat"))
+	assert.Equal(t, "secret", dsnPassword("postgres://importer:secret@db:5432/dekanat?sslmode=disable"))
+	assert.Equal(t, "se cret", dsnPassword("host=db user=importer password='se cret' dbname=dekanat"))
+	assert.Equal(t, "secret", dsnPassword("Server=db;User Id=importer;Password=secret;"))
+	assert.Empty(t, dsnPassword("file:/var/lib/dekanat.db"))
+	assert.Empty(t, dsnPassword("postgres://db:5432/dekanat"))
+}
+
+func TestRedactor(t *testing.T) {
+	redactor := newRedactor("", "pw1", "p@ss word", "bearer-token", "SYSDBA:p@ss word@db/dekanat")
+
+	assert.Equal(t, "SYSDBA:***@db, *** and ***", redactor.redact("SYSDBA:p@ss word@db, p%40ss+word and bearer-token"))
+	assert.Equal(t, "login importer:*** failed, pw12 and xpw1 are kept", redactor.redact("login importer:pw1 failed, pw12 and xpw1 are kept"))
+	assert.Equal(t, "***, ***", redactor.redact("pw1, pw1"))
+	assert.Equal(t, "open *** failed", redactor.redact("open SYSDBA:p@ss word@db/dekanat failed"))
+	assert.Equal(t, "open *** failed", redactor.redact("open SYSDBA%3Ap%40ss+word%40db%2Fdekanat failed"))
+
+	original := errors.New("connection refused")
+	assert.Same(t, original, redactor.redactError(original))
+	assert.EqualError(t, redactor.redactError(errors.New("login SYSDBA:p@ss word failed")), "login SYSDBA:*** failed")
+	assert.NoError(t, redactor.redactError(nil))
+
+	var out bytes.Buffer
+	n, err := fmt.Fprintf(redactor.writer(&out), "Bearer %s\n", "bearer-token")
+	assert.NoError(t, err)
+	assert.Equal(t, len("Bearer bearer-token\n"), n)
+	assert.Equal(t, "Bearer ***\n", out.String())
+
+	var nilRedactor *Redactor
+	assert.Equal(t, "pw1", nilRedactor.redact("pw1"))
+	assert.Equal(t, "pw1", newRedactor("").redact("pw1"))
+}
+
+func TestConfigString(t *testing.T) {
+	config := Config{
+		secondaryDekanatDbDSN: "SYSDBA:masterkey@db/dekanat",
+		adminToken:            "admin-token",
+		outputSinkUrl:         "redis://:sink-password@redis:6379/0",
+		kafkaHost:             "kafka:9092",
+	}
+
+	for _, dump := range []string{fmt.Sprint(config), fmt.Sprintf("%+v", config), fmt.Sprintf("%#v", config)} {
+		assert.Contains(t, dump, "secondaryDekanatDbDSN:***")
+		assert.Contains(t, dump, "kafkaHost:kafka:9092")
+		assert.NotContains(t, dump, "masterkey")
+		assert.NotContains(t, dump, "admin-token")
+		assert.NotContains(t, dump, "sink-password")
+	}
+}
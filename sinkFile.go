@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type FileSink struct {
+	topic string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func newFileSink(config Config, topic string) (events.WriterInterface, error) {
+	file, err := os.OpenFile(
+		filepath.Join(config.outputSinkUrl, topic+".jsonl"),
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		topic: topic,
+		file:  file,
+	}, nil
+}
+
+func (sink *FileSink) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	var lines []byte
+	for _, message := range msgs {
+		line, err := json.Marshal(newSinkRecord(sink.topic, message))
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	_, err := sink.file.Write(lines)
+	if err == nil {
+		err = sink.file.Sync()
+	}
+
+	return err
+}
+
+func (sink *FileSink) Close() error {
+	return sink.file.Close()
+}
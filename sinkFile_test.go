@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	t.Run("append json lines", func(t *testing.T) {
+		dir := t.TempDir()
+		writer, err := newFileSink(Config{outputSinkUrl: dir}, events.RawLessonsTopic)
+		assert.NoError(t, err)
+
+		err = writer.WriteMessages(
+			context.Background(),
+			kafka.Message{Key: []byte(events.LessonEventName), Value: []byte(`{"Id":1}`)},
+			kafka.Message{Key: []byte(events.LessonEventName), Value: []byte(`{"Id":2}`)},
+		)
+		assert.NoError(t, err)
+
+		err = writer.WriteMessages(
+			context.Background(),
+			kafka.Message{Key: []byte(events.LessonEventName), Value: []byte(`{"Id":3}`)},
+		)
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+
+		content, err := os.ReadFile(filepath.Join(dir, events.RawLessonsTopic+".jsonl"))
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			`{"topic":"raw-lessons","key":"LessonEvent","value":{"Id":1}}`+"\n"+
+				`{"topic":"raw-lessons","key":"LessonEvent","value":{"Id":2}}`+"\n"+
+				`{"topic":"raw-lessons","key":"LessonEvent","value":{"Id":3}}`+"\n",
+			string(content),
+		)
+	})
+
+	t.Run("not exists dir", func(t *testing.T) {
+		writer, err := newFileSink(Config{outputSinkUrl: "/not-exists-dir"}, events.RawLessonsTopic)
+
+		assert.Nil(t, writer)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/segmentio/kafka-go"
+)
+
+const natsKeyHeader = "Event-Key"
+
+type natsPublisher interface {
+	PublishMsg(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+type NatsSink struct {
+	subject   string
+	publisher natsPublisher
+	conn      *nats.Conn
+}
+
+func newNatsSink(config Config, topic string) (events.WriterInterface, error) {
+	conn, err := nats.Connect(config.outputSinkUrl, nats.Timeout(config.kafkaTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &NatsSink{
+		subject:   topic,
+		publisher: js,
+		conn:      conn,
+	}, nil
+}
+
+func (sink *NatsSink) WriteMessages(ctx context.Context, msgs ...kafka.Message) (err error) {
+	for _, message := range msgs {
+		record := newSinkRecord(sink.subject, message)
+
+		natsMessage := nats.NewMsg(record.Topic)
+		natsMessage.Header.Set(natsKeyHeader, record.Key)
+		for key, value := range record.Headers {
+			natsMessage.Header.Set(key, value)
+		}
+		natsMessage.Data = record.Value
+
+		if _, err = sink.publisher.PublishMsg(ctx, natsMessage); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (sink *NatsSink) Close() error {
+	if sink.conn != nil {
+		return sink.conn.Drain()
+	}
+
+	return nil
+}
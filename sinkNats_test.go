@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeNatsPublisher struct {
+	messages []*nats.Msg
+	err      error
+}
+
+func (publisher *fakeNatsPublisher) PublishMsg(_ context.Context, msg *nats.Msg, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if publisher.err != nil {
+		return nil, publisher.err
+	}
+	publisher.messages = append(publisher.messages, msg)
+	return &jetstream.PubAck{}, nil
+}
+
+func TestNatsSink(t *testing.T) {
+	t.Run("publish messages", func(t *testing.T) {
+		publisher := &fakeNatsPublisher{}
+		sink := &NatsSink{subject: events.RawLessonsTopic, publisher: publisher}
+
+		err := sink.WriteMessages(
+			context.Background(),
+			kafka.Message{
+				Key:     []byte(events.LessonEventName),
+				Value:   []byte(`{"Id":1}`),
+				Headers: []kafka.Header{{Key: "schema-version", Value: []byte("1")}},
+			},
+		)
+
+		assert.NoError(t, err)
+		assert.Len(t, publisher.messages, 1)
+		assert.Equal(t, events.RawLessonsTopic, publisher.messages[0].Subject)
+		assert.Equal(t, events.LessonEventName, publisher.messages[0].Header.Get(natsKeyHeader))
+		assert.Equal(t, "1", publisher.messages[0].Header.Get("schema-version"))
+		assert.Equal(t, `{"Id":1}`, string(publisher.messages[0].Data))
+		assert.NoError(t, sink.Close())
+	})
+
+	t.Run("publish error", func(t *testing.T) {
+		expectedError := errors.New("no responders")
+		sink := &NatsSink{subject: events.RawLessonsTopic, publisher: &fakeNatsPublisher{err: expectedError}}
+
+		err := sink.WriteMessages(context.Background(), kafka.Message{Key: []byte(events.LessonEventName)})
+
+		assert.Equal(t, expectedError, err)
+	})
+
+	t.Run("connection error", func(t *testing.T) {
+		sink, err := newNatsSink(Config{outputSinkUrl: "nats://127.0.0.1:1", kafkaTimeout: time.Second}, events.RawLessonsTopic)
+
+		assert.Nil(t, sink)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+)
+
+type redisStreamClient interface {
+	XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd
+	Close() error
+}
+
+type RedisSink struct {
+	stream string
+	client redisStreamClient
+}
+
+func newRedisSink(config Config, topic string) (events.WriterInterface, error) {
+	options, err := redis.ParseURL(config.outputSinkUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisSink{
+		stream: topic,
+		client: redis.NewClient(options),
+	}, nil
+}
+
+func (sink *RedisSink) WriteMessages(ctx context.Context, msgs ...kafka.Message) (err error) {
+	for _, message := range msgs {
+		record := newSinkRecord(sink.stream, message)
+
+		values := []string{"key", record.Key, "value", string(record.Value)}
+		for key, value := range record.Headers {
+			values = append(values, "header:"+key, value)
+		}
+
+		err = sink.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: record.Topic,
+			Values: values,
+		}).Err()
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (sink *RedisSink) Close() error {
+	return sink.client.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeRedisStreamClient struct {
+	args   []*redis.XAddArgs
+	err    error
+	closed bool
+}
+
+func (client *fakeRedisStreamClient) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
+	cmd := redis.NewStringCmd(ctx)
+	if client.err != nil {
+		cmd.SetErr(client.err)
+	} else {
+		client.args = append(client.args, a)
+	}
+	return cmd
+}
+
+func (client *fakeRedisStreamClient) Close() error {
+	client.closed = true
+	return nil
+}
+
+func TestRedisSink(t *testing.T) {
+	t.Run("add stream entries", func(t *testing.T) {
+		client := &fakeRedisStreamClient{}
+		sink := &RedisSink{stream: events.RawLessonsTopic, client: client}
+
+		err := sink.WriteMessages(
+			context.Background(),
+			kafka.Message{Key: []byte(events.LessonEventName), Value: []byte(`{"Id":1}`)},
+			kafka.Message{Key: []byte(events.LessonEventName), Value: []byte(`{"Id":2}`)},
+		)
+
+		assert.NoError(t, err)
+		assert.Len(t, client.args, 2)
+		assert.Equal(t, events.RawLessonsTopic, client.args[0].Stream)
+		assert.Equal(t, []string{"key", events.LessonEventName, "value", `{"Id":2}`}, client.args[1].Values)
+
+		assert.NoError(t, sink.Close())
+		assert.True(t, client.closed)
+	})
+
+	t.Run("add error", func(t *testing.T) {
+		expectedError := errors.New("connection refused")
+		sink := &RedisSink{stream: events.RawLessonsTopic, client: &fakeRedisStreamClient{err: expectedError}}
+
+		err := sink.WriteMessages(context.Background(), kafka.Message{Key: []byte(events.LessonEventName)})
+
+		assert.Equal(t, expectedError, err)
+	})
+
+	t.Run("wrong url", func(t *testing.T) {
+		sink, err := newRedisSink(Config{outputSinkUrl: "http://not-redis"}, events.RawLessonsTopic)
+
+		assert.Nil(t, sink)
+		assert.Error(t, err)
+	})
+}
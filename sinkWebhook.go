@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type webhookBatch struct {
+	Topic    string       `json:"topic"`
+	Messages []sinkRecord `json:"messages"`
+}
+
+type WebhookSink struct {
+	url    string
+	topic  string
+	client *http.Client
+}
+
+func newWebhookSink(config Config, topic string) (events.WriterInterface, error) {
+	return &WebhookSink{
+		url:   config.outputSinkUrl,
+		topic: topic,
+		client: &http.Client{
+			Timeout: config.kafkaTimeout,
+		},
+	}, nil
+}
+
+func (sink *WebhookSink) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	batch := webhookBatch{
+		Topic:    sink.topic,
+		Messages: make([]sinkRecord, len(msgs)),
+	}
+	for i, message := range msgs {
+		batch.Messages[i] = newSinkRecord(sink.topic, message)
+	}
+
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sink.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := sink.client.Do(request)
+	if urlErr, isUrlErr := err.(*url.Error); isUrlErr {
+		urlErr.URL = redactedUrl(urlErr.URL)
+	}
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %s", redactedUrl(sink.url), response.Status)
+	}
+
+	return nil
+}
+
+// redactedUrl masks the password and the query values, which often carry access tokens.
+func redactedUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return "***"
+	}
+
+	names := make([]string, 0)
+	for name := range parsed.Query() {
+		names = append(names, name+"="+redactedSecret)
+	}
+	sort.Strings(names)
+	parsed.RawQuery = ""
+
+	redacted := parsed.Redacted()
+	if len(names) != 0 {
+		redacted += "?" + strings.Join(names, "&")
+	}
+
+	return redacted
+}
+
+func (sink *WebhookSink) Close() error {
+	sink.client.CloseIdleConnections()
+	return nil
+}
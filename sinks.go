@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"sort"
+	"strings"
+)
+
+const DefaultOutputSink = "kafka"
+
+type SinkFactory func(config Config, topic string) (events.WriterInterface, error)
+
+var sinkRegistry = map[string]SinkFactory{
+	"kafka":   newKafkaSink,
+	"nats":    newNatsSink,
+	"redis":   newRedisSink,
+	"webhook": newWebhookSink,
+	"file":    newFileSink,
+}
+
+// sinkRecord is the common shape every non-Kafka sink uses for one message:
+// the event key as a string and the payload embedded as JSON (base64 string for non-JSON payloads).
+type sinkRecord struct {
+	Topic   string            `json:"topic"`
+	Key     string            `json:"key"`
+	Value   json.RawMessage   `json:"value"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func newSink(config Config, topic string) (events.WriterInterface, error) {
+	factory, exists := sinkRegistry[config.outputSink]
+	if !exists {
+		return nil, errors.New("unknown output sink \"" + config.outputSink + "\", expected one of: " + strings.Join(sinkNames(), ", "))
+	}
+
+	return factory(config, topic)
+}
+
+func sinkNames() []string {
+	names := make([]string, 0, len(sinkRegistry))
+	for name := range sinkRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func newKafkaSink(config Config, topic string) (events.WriterInterface, error) {
+	return &kafka.Writer{
+		Addr:     kafka.TCP(config.kafkaHost),
+		Topic:    topic,
+		Balancer: &kafka.LeastBytes{},
+	}, nil
+}
+
+func newSinkRecord(topic string, message kafka.Message) sinkRecord {
+	record := sinkRecord{
+		Topic: topic,
+		Key:   string(message.Key),
+		Value: message.Value,
+	}
+
+	if !json.Valid(message.Value) {
+		record.Value, _ = json.Marshal(message.Value)
+	}
+
+	if len(message.Headers) != 0 {
+		record.Headers = make(map[string]string, len(message.Headers))
+		for _, header := range message.Headers {
+			record.Headers[header.Key] = string(header.Value)
+		}
+	}
+
+	return record
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewSink(t *testing.T) {
+	t.Run("kafka sink", func(t *testing.T) {
+		config := Config{kafkaHost: "KAFKA:9999", outputSink: "kafka"}
+
+		writer, err := newSink(config, events.RawLessonsTopic)
+
+		assert.NoError(t, err)
+		assert.IsType(t, &kafka.Writer{}, writer)
+		assert.Equal(t, events.RawLessonsTopic, writer.(*kafka.Writer).Topic)
+	})
+
+	t.Run("file sink", func(t *testing.T) {
+		config := Config{outputSink: "file", outputSinkUrl: t.TempDir()}
+
+		writer, err := newSink(config, events.MetaEventsTopic)
+
+		assert.NoError(t, err)
+		assert.IsType(t, &FileSink{}, writer)
+		assert.NoError(t, writer.Close())
+	})
+
+	t.Run("unknown sink", func(t *testing.T) {
+		config := Config{outputSink: "carrier-pigeon"}
+
+		writer, err := newSink(config, events.RawLessonsTopic)
+
+		assert.Nil(t, writer)
+		assert.EqualError(t, err, "unknown output sink \"carrier-pigeon\", expected one of: file, kafka, nats, redis, webhook")
+	})
+}
+
+func TestNewSinkRecord(t *testing.T) {
+	t.Run("json payload", func(t *testing.T) {
+		record := newSinkRecord(events.RawLessonsTopic, kafka.Message{
+			Key:     []byte(events.LessonEventName),
+			Value:   []byte(`{"Id":1}`),
+			Headers: []kafka.Header{{Key: "schema-version", Value: []byte("1")}},
+		})
+
+		assert.Equal(t, events.RawLessonsTopic, record.Topic)
+		assert.Equal(t, events.LessonEventName, record.Key)
+		assert.JSONEq(t, `{"Id":1}`, string(record.Value))
+		assert.Equal(t, map[string]string{"schema-version": "1"}, record.Headers)
+	})
+
+	t.Run("binary payload", func(t *testing.T) {
+		record := newSinkRecord(events.RawLessonsTopic, kafka.Message{
+			Key:   []byte(events.LessonEventName),
+			Value: []byte{0, 1, 2},
+		})
+
+		assert.Equal(t, `"AAEC"`, string(record.Value))
+		assert.Nil(t, record.Headers)
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+const LessonsSnapshotRequestedEventName = "LessonsSnapshotRequestedEvent"
+const LessonsSnapshotStartedEventName = "LessonsSnapshotStartedEvent"
+const LessonsSnapshotFinishedEventName = "LessonsSnapshotFinishedEvent"
+
+type LessonsSnapshotRequestedEvent struct {
+	Year int
+}
+
+type LessonsSnapshotStartedEvent struct {
+	Year      int
+	StartedAt time.Time
+}
+
+type LessonsSnapshotFinishedEvent struct {
+	Year         int
+	LessonsCount int
+	StartedAt    time.Time
+	FinishedAt   time.Time
+}
+
+const LessonsImportStaleEventName = "LessonsImportStaleEvent"
+
+type LessonsImportStaleEvent struct {
+	LastImportAt   time.Time
+	MaxQuietPeriod string
+	DetectedAt     time.Time
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+	_ "time/tzdata"
+)
+
+const DefaultSourceTimeZone = "Europe/Kyiv"
+
+// inSourceLocation converts a window boundary into the source time zone the naive Dekanat timestamps are written in.
+// Without a configured zone the time is kept in its own location.
+func (importer *LessonsImporter) inSourceLocation(datetime time.Time) time.Time {
+	if importer.location == nil {
+		return datetime
+	}
+
+	return datetime.In(importer.location)
+}
+
+func (importer *LessonsImporter) bindDatetime(datetime time.Time) any {
+	return importer.dialect.bindDatetime(importer.inSourceLocation(datetime))
+}
+
+// normalizeDatetime reads the wall clock of a scanned timestamp as a time in the source time zone,
+// whatever location the driver attached to it. A wall clock skipped by the spring transition moves forward by the gap.
+func (importer *LessonsImporter) normalizeDatetime(datetime time.Time) time.Time {
+	if importer.location == nil || datetime.IsZero() {
+		return datetime
+	}
+
+	return time.Date(
+		datetime.Year(), datetime.Month(), datetime.Day(),
+		datetime.Hour(), datetime.Minute(), datetime.Second(), datetime.Nanosecond(), importer.location,
+	)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSourceTimeZone(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	kyiv := mustLoadLocation("Europe/Kyiv")
+
+	executeWindow := func(
+		t *testing.T, startDatetime time.Time, endDatetime time.Time,
+		expectedArgs []driver.Value, scannedDates []time.Time,
+	) (payloads []string) {
+		db, dbMock, _ := sqlmock.New()
+
+		rows := sqlmock.NewRows(expectedColumns)
+		for i, date := range scannedDates {
+			rows.AddRow(100+i, 200, date, 1, 2, false)
+		}
+		dbMock.ExpectQuery(regexp.QuoteMeta(FirebirdDialect.queries.Lessons.build())).
+			WithArgs(expectedArgs...).WillReturnRows(rows)
+
+		writer := mocks.NewWriterInterface(t)
+		if len(scannedDates) != 0 {
+			writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				var event struct{ Date string }
+				_ = json.Unmarshal(args.Get(1).(kafka.Message).Value, &event)
+				payloads = append(payloads, event.Date)
+			})
+		}
+
+		importer := LessonsImporter{
+			out:            &out,
+			db:             db,
+			dialect:        FirebirdDialect,
+			writer:         writer,
+			writeThreshold: 1,
+			location:       kyiv,
+		}
+
+		_, err := importer.execute(context.Background(), startDatetime, endDatetime, 2022)
+
+		assert.NoError(t, err)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+		return
+	}
+
+	t.Run("spring transition", func(t *testing.T) {
+		payloads := executeWindow(
+			t,
+			time.Date(2023, 3, 27, 0, 30, 0, 0, time.UTC),
+			time.Date(2023, 3, 27, 0, 30, 0, 0, time.UTC),
+			[]driver.Value{"2023-03-25 00:00:00", "2023-03-27 03:30:00"},
+			[]time.Time{
+				time.Date(2023, 3, 26, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 3, 27, 0, 0, 0, 0, time.UTC),
+			},
+		)
+
+		assert.Equal(t, []string{"2023-03-26T00:00:00+02:00", "2023-03-27T00:00:00+03:00"}, payloads)
+	})
+
+	t.Run("autumn transition", func(t *testing.T) {
+		payloads := executeWindow(
+			t,
+			time.Date(2023, 10, 29, 1, 30, 0, 0, time.UTC),
+			time.Date(2023, 10, 29, 1, 30, 0, 0, time.UTC),
+			[]driver.Value{"2023-10-27 00:00:00", "2023-10-29 03:30:00"},
+			[]time.Time{
+				time.Date(2023, 10, 29, 0, 0, 0, 0, time.FixedZone("driver", 5*60*60)),
+				time.Date(2023, 10, 30, 0, 0, 0, 0, time.FixedZone("driver", 5*60*60)),
+			},
+		)
+
+		assert.Equal(t, []string{"2023-10-29T00:00:00+03:00", "2023-10-30T00:00:00+02:00"}, payloads)
+	})
+
+	t.Run("window in summer offset", func(t *testing.T) {
+		executeWindow(
+			t,
+			time.Date(2023, 10, 29, 0, 30, 0, 0, time.UTC),
+			time.Date(2023, 10, 29, 0, 59, 59, 0, time.UTC),
+			[]driver.Value{"2023-10-27 00:00:00", "2023-10-29 03:59:59"},
+			nil,
+		)
+	})
+}
+
+func TestNormalizeDatetime(t *testing.T) {
+	kyiv := mustLoadLocation("Europe/Kyiv")
+	importer := LessonsImporter{location: kyiv}
+
+	assert.Equal(
+		t, time.Date(2023, 3, 26, 4, 30, 0, 0, kyiv),
+		importer.normalizeDatetime(time.Date(2023, 3, 26, 3, 30, 0, 0, time.UTC)),
+		"wall clock in spring gap moves forward",
+	)
+	assert.True(t, importer.normalizeDatetime(time.Time{}).IsZero())
+
+	legacyImporter := LessonsImporter{}
+	datetime := time.Date(2023, 3, 26, 3, 30, 0, 0, time.UTC)
+	assert.Equal(t, datetime, legacyImporter.normalizeDatetime(datetime))
+	assert.Equal(t, datetime, legacyImporter.inSourceLocation(datetime))
+}
+
+func TestAcademicYearRangeInSourceTimeZone(t *testing.T) {
+	kyiv := mustLoadLocation("Europe/Kyiv")
+	importer := LessonsImporter{location: kyiv}
+
+	start, end := importer.academicYearRange(2023)
+
+	assert.Equal(t, "2023-09-01T00:00:00+03:00", start.Format(time.RFC3339))
+	assert.Equal(t, "2024-09-01T00:00:00+03:00", end.Format(time.RFC3339))
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const DefaultSpoolMaxBytes = 64 * 1024 * 1024
+
+const spoolRecordHeaderSize = 4
+
+// spool replays back off from spoolRetryMinDelay up to spoolRetryMaxDelay while the wrapped writer fails.
+const spoolRetryMinDelay = time.Second
+const spoolRetryMaxDelay = time.Minute
+
+var ErrSpoolFull = errors.New("spool size limit exceeded")
+
+type flusher interface {
+	flush(ctx context.Context) error
+}
+
+type spoolMessage struct {
+	Key     []byte
+	Value   []byte
+	Headers []kafka.Header
+}
+
+// SpoolWriter appends every batch to a local segment file before passing it to the wrapped writer.
+// Batches that could not be delivered stay in the segment and are replayed in order: a write behind them
+// only appends until the replay backoff is over, and every run starts with a flush. Replayed batches take
+// their share of the rate limiter; a new batch already took it from the importer.
+type SpoolWriter struct {
+	out         io.Writer
+	writer      events.WriterInterface
+	rateLimiter *RateLimiter
+	path        string
+	maxBytes    int64
+	batches     *expvar.Int
+	size        *expvar.Int
+	retryDelay  time.Duration
+	retryAt     time.Time
+	mutex       sync.Mutex
+}
+
+func newSpoolWriter(
+	out io.Writer, writer events.WriterInterface, path string, maxBytes int64, rateLimiter *RateLimiter,
+) (*SpoolWriter, error) {
+	spool := &SpoolWriter{
+		out:         out,
+		writer:      writer,
+		rateLimiter: rateLimiter,
+		path:        path,
+		maxBytes:    maxBytes,
+		batches:     new(expvar.Int),
+		size:        new(expvar.Int),
+	}
+
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	batches, size, err := spool.scan(file)
+	if err == io.ErrUnexpectedEOF {
+		fmt.Fprintf(out, "Spool %s has incomplete tail record, truncate it to %d bytes\n", path, size)
+		err = os.Truncate(path, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spool.batches.Set(batches)
+	spool.size.Set(size)
+	name := filepath.Base(path)
+	spoolDepth.Set(name+".batches", spool.batches)
+	spoolDepth.Set(name+".bytes", spool.size)
+
+	return spool, nil
+}
+
+func (spool *SpoolWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	backoff := spool.batches.Value() > 0 && time.Now().Before(spool.retryAt)
+	err := spool.append(msgs)
+	if errors.Is(err, ErrSpoolFull) && !backoff && spool.replay(ctx, 0) == nil {
+		err = spool.append(msgs)
+	}
+	if err != nil || backoff {
+		return err
+	}
+
+	if replayErr := spool.replay(ctx, 1); replayErr != nil {
+		fmt.Fprintf(spool.out, "Spool replay postponed (%d batches pending): %v\n", spool.batches.Value(), replayErr)
+	}
+
+	return nil
+}
+
+func (spool *SpoolWriter) flush(ctx context.Context) error {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.replay(ctx, 0)
+}
+
+func (spool *SpoolWriter) Close() error {
+	err := spool.flush(context.Background())
+	if err != nil {
+		fmt.Fprintf(spool.out, "Spool is not empty on close (%d batches pending): %v\n", spool.batches.Value(), err)
+	}
+
+	return spool.writer.Close()
+}
+
+func (spool *SpoolWriter) append(msgs []kafka.Message) error {
+	batch := make([]spoolMessage, len(msgs))
+	for i, message := range msgs {
+		batch[i] = spoolMessage{
+			Key:     message.Key,
+			Value:   message.Value,
+			Headers: message.Headers,
+		}
+	}
+
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	recordSize := int64(spoolRecordHeaderSize + len(payload))
+	if spool.maxBytes > 0 && spool.size.Value()+recordSize > spool.maxBytes {
+		return ErrSpoolFull
+	}
+
+	file, err := os.OpenFile(spool.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	copy(record[spoolRecordHeaderSize:], payload)
+
+	if _, err = file.Write(record); err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		spool.batches.Add(1)
+		spool.size.Add(recordSize)
+	}
+
+	return err
+}
+
+// replay sends the spooled batches in order and schedules the next attempt with backoff when the writer fails.
+// The last throttledTail batches were throttled by the caller.
+func (spool *SpoolWriter) replay(ctx context.Context, throttledTail int64) (err error) {
+	defer func() {
+		if err == nil {
+			spool.retryDelay = 0
+		} else {
+			spool.retryDelay = min(max(2*spool.retryDelay, spoolRetryMinDelay), spoolRetryMaxDelay)
+		}
+		spool.retryAt = time.Now().Add(spool.retryDelay)
+	}()
+
+	if spool.batches.Value() == 0 {
+		return nil
+	}
+
+	file, err := os.Open(spool.path)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	var batch []spoolMessage
+	var messages []kafka.Message
+	throttled := spool.batches.Value() - throttledTail
+	for i := int64(0); err == nil; i++ {
+		batch, err = spool.readRecord(file)
+		if err == nil {
+			messages = toKafkaMessages(batch)
+		}
+		if err == nil && i < throttled {
+			_, err = spool.rateLimiter.wait(ctx, messages)
+		}
+		if err == nil {
+			err = spool.writer.WriteMessages(ctx, messages...)
+		}
+		if err == nil {
+			offset, _ = file.Seek(0, io.SeekCurrent)
+			spool.batches.Add(-1)
+		}
+	}
+	_ = file.Close()
+
+	if err == io.EOF {
+		err = os.Truncate(spool.path, 0)
+		spool.batches.Set(0)
+		spool.size.Set(0)
+		return err
+	}
+
+	if offset > 0 {
+		if truncateErr := spool.truncateHead(offset); truncateErr != nil {
+			return truncateErr
+		}
+	}
+
+	return err
+}
+
+func (spool *SpoolWriter) truncateHead(offset int64) error {
+	file, err := os.Open(spool.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tmpPath := spool.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	if _, err = file.Seek(offset, io.SeekStart); err == nil {
+		size, err = io.Copy(tmpFile, file)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, spool.path)
+	}
+	if err == nil {
+		spool.size.Set(size)
+	}
+
+	return err
+}
+
+func (spool *SpoolWriter) scan(file *os.File) (batches int64, size int64, err error) {
+	for err == nil {
+		_, err = spool.readRecord(file)
+		if err == nil {
+			batches++
+			size, _ = file.Seek(0, io.SeekCurrent)
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	return
+}
+
+func (spool *SpoolWriter) readRecord(reader io.Reader) (batch []spoolMessage, err error) {
+	header := make([]byte, spoolRecordHeaderSize)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err = io.ReadFull(reader, payload); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = json.Unmarshal(payload, &batch)
+	}
+
+	return
+}
+
+func toKafkaMessages(batch []spoolMessage) []kafka.Message {
+	messages := make([]kafka.Message, len(batch))
+	for i, message := range batch {
+		messages[i] = kafka.Message{
+			Key:     message.Key,
+			Value:   message.Value,
+			Headers: message.Headers,
+		}
+	}
+
+	return messages
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpoolWriter(t *testing.T) {
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	expectedError := errors.New("broker is not available")
+
+	firstMessage := kafka.Message{Key: []byte("first"), Value: []byte(`{"Id":1}`)}
+	secondMessage := kafka.Message{Key: []byte("second"), Value: []byte(`{"Id":2}`)}
+
+	t.Run("pass through", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, firstMessage).Return(nil).Once()
+
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+
+		err = spool.WriteMessages(context.Background(), firstMessage)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), spool.batches.Value())
+		assert.Equal(t, int64(0), spool.size.Value())
+		stat, _ := os.Stat(path)
+		assert.Equal(t, int64(0), stat.Size())
+	})
+
+	t.Run("keep batches while writer fails and replay in order", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, firstMessage).Return(expectedError).Once()
+
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+
+		err = spool.WriteMessages(context.Background(), firstMessage)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), spool.batches.Value())
+		assert.NotZero(t, spool.size.Value())
+
+		// the backoff is over
+		spool.retryAt = time.Now()
+		writer.On("WriteMessages", matchContext, firstMessage).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, secondMessage).Return(nil).Once()
+
+		err = spool.WriteMessages(context.Background(), secondMessage)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), spool.batches.Value())
+		assert.Equal(t, int64(0), spool.size.Value())
+
+		writer.AssertNumberOfCalls(t, "WriteMessages", 3)
+		assert.Equal(t, firstMessage.Key, writer.Calls[1].Arguments.Get(1).(kafka.Message).Key)
+		assert.Equal(t, secondMessage.Key, writer.Calls[2].Arguments.Get(1).(kafka.Message).Key)
+	})
+
+	t.Run("partial replay keeps tail", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, firstMessage).Return(expectedError).Once()
+		writer.On("WriteMessages", matchContext, firstMessage).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, secondMessage).Return(expectedError).Once()
+
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, spool.WriteMessages(context.Background(), firstMessage))
+		assert.NoError(t, spool.WriteMessages(context.Background(), secondMessage))
+		assert.Equal(t, int64(2), spool.batches.Value())
+
+		err = spool.flush(context.Background())
+		assert.Equal(t, expectedError, err)
+		assert.Equal(t, int64(1), spool.batches.Value())
+
+		reopened, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), reopened.batches.Value())
+		assert.Equal(t, spool.size.Value(), reopened.size.Value())
+	})
+
+	t.Run("back off replays while writer fails", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, firstMessage).Return(expectedError).Once()
+
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, spool.WriteMessages(context.Background(), firstMessage))
+		assert.NoError(t, spool.WriteMessages(context.Background(), secondMessage))
+		assert.NoError(t, spool.WriteMessages(context.Background(), secondMessage))
+
+		writer.AssertNumberOfCalls(t, "WriteMessages", 1)
+		assert.Equal(t, int64(3), spool.batches.Value())
+		assert.Equal(t, spoolRetryMinDelay, spool.retryDelay)
+
+		writer.On("WriteMessages", matchContext, firstMessage).Return(expectedError).Once()
+		assert.Equal(t, expectedError, spool.flush(context.Background()))
+		assert.Equal(t, 2*spoolRetryMinDelay, spool.retryDelay)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		cancelled := mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() != nil })
+		writer.On("WriteMessages", cancelled, firstMessage).Return(context.Canceled).Once()
+		assert.ErrorIs(t, spool.flush(ctx), context.Canceled)
+		assert.Equal(t, int64(3), spool.batches.Value())
+	})
+
+	t.Run("throttle replayed batches", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, firstMessage).Return(expectedError).Once()
+
+		limiter, sleeps := newTestRateLimiter(1, 0)
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, limiter)
+		assert.NoError(t, err)
+
+		// the importer throttles new batches before they reach the spool
+		assert.NoError(t, spool.WriteMessages(context.Background(), firstMessage))
+		assert.Empty(t, *sleeps)
+
+		spool.retryAt = time.Now()
+		writer.On("WriteMessages", matchContext, firstMessage).Return(nil).Once()
+		writer.On("WriteMessages", matchContext, secondMessage).Return(nil).Once()
+		assert.NoError(t, spool.WriteMessages(context.Background(), secondMessage))
+
+		assert.Equal(t, int64(0), spool.batches.Value())
+		assert.Empty(t, *sleeps)
+
+		writer.On("WriteMessages", matchContext, secondMessage).Return(expectedError).Once()
+		assert.NoError(t, spool.WriteMessages(context.Background(), secondMessage))
+		writer.On("WriteMessages", matchContext, secondMessage).Return(nil).Once()
+		assert.NoError(t, spool.flush(context.Background()))
+
+		assert.Equal(t, []time.Duration{time.Second}, *sleeps)
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, mock.Anything).Return(expectedError)
+
+		spool, err := newSpoolWriter(&out, writer, path, 64, nil)
+		assert.NoError(t, err)
+
+		assert.NoError(t, spool.WriteMessages(context.Background(), firstMessage))
+		err = spool.WriteMessages(context.Background(), secondMessage)
+
+		assert.ErrorIs(t, err, ErrSpoolFull)
+		assert.Equal(t, int64(1), spool.batches.Value())
+	})
+
+	t.Run("truncate incomplete tail record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("WriteMessages", matchContext, firstMessage).Return(expectedError).Once()
+
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, spool.WriteMessages(context.Background(), firstMessage))
+		expectedSize := spool.size.Value()
+
+		file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		_, _ = file.Write([]byte{0, 0, 0, 100, '['})
+		_ = file.Close()
+
+		reopened, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), reopened.batches.Value())
+		assert.Equal(t, expectedSize, reopened.size.Value())
+
+		stat, _ := os.Stat(path)
+		assert.Equal(t, expectedSize, stat.Size())
+	})
+
+	t.Run("close flushes and closes writer", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lessons.spool")
+
+		writer := mocks.NewWriterInterface(t)
+		writer.On("Close").Return(nil).Once()
+
+		spool, err := newSpoolWriter(&out, writer, path, DefaultSpoolMaxBytes, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, spool.Close())
+	})
+}
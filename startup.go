@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultStartupTimeout = time.Minute * 2
+const startupInitialDelay = time.Millisecond * 500
+const startupMaxDelay = time.Second * 15
+
+// requiredSchema lists the columns the default queries read from the secondary DB.
+var requiredSchema = map[string]map[string]columnKind{
+	"T_PRJURN": {
+		"ID":         integerColumn,
+		"NUM_PREDM":  integerColumn,
+		"DATEZAN":    datetimeColumn,
+		"NUM_VARZAN": integerColumn,
+		"HALF":       integerColumn,
+		"FSTATUS":    integerColumn,
+		"REGDATE":    datetimeColumn,
+	},
+	"T_VARZAN": {
+		"ID":        integerColumn,
+		"SHIRTNAME": textColumn,
+		"LONGNAME":  textColumn,
+	},
+}
+
+// waitFor retries check with exponential backoff until it succeeds or timeout elapses.
+func waitFor(out io.Writer, name string, check func() error, timeout time.Duration, initialDelay time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := initialDelay
+
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("%s is not reachable after %s: %w", name, timeout, err)
+		}
+
+		fmt.Fprintf(out, "Waiting for %s: %v. Retry in %s\n", name, err, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, startupMaxDelay)
+	}
+}
+
+func kafkaReachable(dialer *kafka.Dialer, host string) func() error {
+	return func() error {
+		conn, err := dialer.DialContext(context.Background(), "tcp", host)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+}
+
+// checkSchema reads the dialect catalog and reports every missing table, missing column and incompatible column type.
+func checkSchema(db *sql.DB, dialect *Dialect) error {
+	tables := make([]string, 0, len(requiredSchema))
+	for table := range requiredSchema {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var problems []string
+	for _, table := range tables {
+		actualKinds, dataTypes, err := catalogColumns(db, dialect, table)
+		if err != nil {
+			return errors.New("Failed to read " + table + " columns: " + err.Error())
+		}
+		if len(actualKinds) == 0 {
+			problems = append(problems, "table "+table+" is missing")
+			continue
+		}
+
+		columns := make([]string, 0, len(requiredSchema[table]))
+		for column := range requiredSchema[table] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+
+		for _, column := range columns {
+			expected := requiredSchema[table][column]
+			actual, exists := actualKinds[column]
+			if !exists {
+				problems = append(problems, "column "+table+"."+column+" is missing")
+			} else if !isCompatibleKind(expected, actual) {
+				problems = append(problems, fmt.Sprintf(
+					"column %s.%s has type %s, expected %s", table, column, dataTypes[column], columnKindNames[expected],
+				))
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func catalogColumns(db *sql.DB, dialect *Dialect, table string) (kinds map[string]columnKind, dataTypes map[string]string, err error) {
+	rows, err := db.Query(dialect.columnsCatalogQuery, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	kinds = make(map[string]columnKind)
+	dataTypes = make(map[string]string)
+	var column, dataType string
+	for err == nil && rows.Next() {
+		if err = rows.Scan(&column, &dataType); err == nil {
+			column = strings.ToUpper(strings.TrimSpace(column))
+			dataTypes[column] = strings.ToUpper(strings.TrimSpace(dataType))
+			kinds[column] = catalogTypeKind(dataTypes[column])
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return
+}
+
+func catalogTypeKind(dataType string) columnKind {
+	switch {
+	case strings.Contains(dataType, "INT"):
+		return integerColumn
+	case strings.Contains(dataType, "TIMESTAMP"), strings.Contains(dataType, "DATE"):
+		return datetimeColumn
+	case strings.Contains(dataType, "CHAR"), strings.Contains(dataType, "TEXT"):
+		return textColumn
+	case strings.Contains(dataType, "BOOL"):
+		return booleanColumn
+	}
+
+	return otherColumn
+}
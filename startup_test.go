@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWaitFor(t *testing.T) {
+	expectedError := errors.New("connection refused")
+
+	t.Run("retry until reachable", func(t *testing.T) {
+		var out bytes.Buffer
+		attempts := 0
+
+		err := waitFor(&out, "secondary Dekanat DB", func() error {
+			attempts++
+			if attempts < 3 {
+				return expectedError
+			}
+			return nil
+		}, time.Second, time.Millisecond)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+		assert.Contains(t, out.String(), "Waiting for secondary Dekanat DB: connection refused. Retry in 2ms")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var out bytes.Buffer
+
+		err := waitFor(&out, "Kafka", func() error { return expectedError }, time.Millisecond*20, time.Millisecond)
+
+		assert.EqualError(t, err, "Kafka is not reachable after 20ms: connection refused")
+		assert.ErrorIs(t, err, expectedError)
+	})
+}
+
+func TestCheckSchema(t *testing.T) {
+	openDb := func(t *testing.T, schema string) *sql.DB {
+		db, err := sql.Open("sqlite", ":memory:")
+		assert.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		_, err = db.Exec(schema)
+		assert.NoError(t, err)
+		return db
+	}
+
+	t.Run("valid schema", func(t *testing.T) {
+		assert.NoError(t, checkSchema(openDb(t, sqliteSchema), SqliteDialect))
+	})
+
+	t.Run("missing table", func(t *testing.T) {
+		db := openDb(t, sqliteSchema+"DROP TABLE T_VARZAN;")
+
+		assert.EqualError(t, checkSchema(db, SqliteDialect), "table T_VARZAN is missing")
+	})
+
+	t.Run("missing column and wrong type", func(t *testing.T) {
+		db := openDb(t, `
+CREATE TABLE T_PRJURN (ID INTEGER, NUM_PREDM INTEGER, DATEZAN TEXT, NUM_VARZAN INTEGER, HALF INTEGER, FSTATUS INTEGER);
+CREATE TABLE T_VARZAN (ID INTEGER, SHIRTNAME TEXT, LONGNAME VARCHAR(100));
+`)
+
+		assert.EqualError(
+			t, checkSchema(db, SqliteDialect),
+			"column T_PRJURN.DATEZAN has type TEXT, expected datetime; column T_PRJURN.REGDATE is missing",
+		)
+	})
+}
+
+func TestCatalogTypeKind(t *testing.T) {
+	assert.Equal(t, integerColumn, catalogTypeKind("SMALLINT"))
+	assert.Equal(t, integerColumn, catalogTypeKind("INTEGER"))
+	assert.Equal(t, datetimeColumn, catalogTypeKind("TIMESTAMP WITHOUT TIME ZONE"))
+	assert.Equal(t, datetimeColumn, catalogTypeKind("DATE"))
+	assert.Equal(t, textColumn, catalogTypeKind("CHARACTER VARYING"))
+	assert.Equal(t, booleanColumn, catalogTypeKind("BOOLEAN"))
+	assert.Equal(t, otherColumn, catalogTypeKind("OTHER"))
+}
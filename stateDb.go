@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"go.etcd.io/bbolt"
+	"path/filepath"
+	"time"
+)
+
+const StateDbFilename = "state.db"
+
+func openStateDb(stateDir string) (*bbolt.DB, error) {
+	return openStateDbWith(stateDir, &bbolt.Options{Timeout: time.Second * 5})
+}
+
+// openStateDbReadOnly lets several CLI readers share the DB, but still waits for a running importer to close it.
+func openStateDbReadOnly(stateDir string) (*bbolt.DB, error) {
+	return openStateDbWith(stateDir, &bbolt.Options{Timeout: time.Second * 5, ReadOnly: true})
+}
+
+func openStateDbWith(stateDir string, options *bbolt.Options) (*bbolt.DB, error) {
+	db, err := bbolt.Open(filepath.Join(stateDir, StateDbFilename), 0644, options)
+	if errors.Is(err, bbolt.ErrTimeout) {
+		err = errors.New("state DB is locked by a running importer, set ADMIN_LISTEN and ADMIN_TOKEN to go through it")
+	}
+
+	return db, err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+)
+
+const TracerName = "secondary-db-lessons-importer"
+
+const OtlpTracesExporter = "otlp"
+const StdoutTracesExporter = "stdout"
+
+// tracer resolves to the provider installed by setupTracing; until then (and in tests) it does not record spans.
+var tracer = otel.Tracer(TracerName)
+
+var tracePropagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// spanRedactor masks secrets in the errors recorded on spans, which leave the service through the exporter.
+var spanRedactor *Redactor
+
+// setupTracing installs the global tracer provider for the TRACES_EXPORTER. The OTLP exporter reads its endpoint
+// and headers from the standard OTEL_EXPORTER_OTLP_* variables.
+func setupTracing(out io.Writer, exporterName string, redactor *Redactor) (shutdown func(context.Context) error, err error) {
+	spanRedactor = redactor
+
+	var exporter sdktrace.SpanExporter
+	switch exporterName {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case OtlpTracesExporter:
+		exporter, err = otlptracehttp.New(context.Background())
+	case StdoutTracesExporter:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	default:
+		err = errors.New("unknown traces exporter " + exporterName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(TracerName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// kafkaHeaderCarrier exposes Kafka message headers to the trace context propagator.
+type kafkaHeaderCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (carrier kafkaHeaderCarrier) Get(key string) string {
+	for _, header := range *carrier.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+func (carrier kafkaHeaderCarrier) Set(key string, value string) {
+	for i, header := range *carrier.headers {
+		if header.Key == key {
+			(*carrier.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+
+	*carrier.headers = append(*carrier.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (carrier kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, len(*carrier.headers))
+	for i, header := range *carrier.headers {
+		keys[i] = header.Key
+	}
+
+	return keys
+}
+
+func extractTraceContext(ctx context.Context, message *kafka.Message) context.Context {
+	return tracePropagator.Extract(ctx, kafkaHeaderCarrier{headers: &message.Headers})
+}
+
+func injectTraceContext(ctx context.Context, messages []kafka.Message) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+
+	for i := range messages {
+		tracePropagator.Inject(ctx, kafkaHeaderCarrier{headers: &messages[i].Headers})
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		err = spanRedactor.redactError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
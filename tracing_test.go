@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"testing"
+	"time"
+)
+
+const incomingTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestKafkaHeaderCarrier(t *testing.T) {
+	headers := []kafka.Header{{Key: "traceparent", Value: []byte("old")}}
+	carrier := kafkaHeaderCarrier{headers: &headers}
+
+	carrier.Set("traceparent", incomingTraceparent)
+	carrier.Set("tracestate", "vendor=1")
+
+	assert.Equal(t, incomingTraceparent, carrier.Get("traceparent"))
+	assert.Equal(t, "vendor=1", carrier.Get("tracestate"))
+	assert.Empty(t, carrier.Get("baggage"))
+	assert.Equal(t, []string{"traceparent", "tracestate"}, carrier.Keys())
+}
+
+func TestSetupTracing(t *testing.T) {
+	var out bytes.Buffer
+
+	shutdown, err := setupTracing(&out, "", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = setupTracing(&out, "zipkin", nil)
+	assert.EqualError(t, err, "unknown traces exporter zipkin")
+}
+
+func TestEndSpanRedactsError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	_, err := setupTracing(io.Discard, "", newRedactor("masterkey"))
+	assert.NoError(t, err)
+	defer func() { spanRedactor = nil }()
+
+	_, span := provider.Tracer(TracerName).Start(context.Background(), "import lessons")
+	endSpan(span, errors.New("login SYSDBA:masterkey failed"))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "login SYSDBA:*** failed", spans[0].Status().Description)
+	for _, attribute := range spans[0].Events()[0].Attributes {
+		assert.NotContains(t, attribute.Value.Emit(), "masterkey")
+	}
+}
+
+func TestEventLoopTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previousProvider)
+
+	var out bytes.Buffer
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	breakLoopError := errors.New("breakLoop")
+
+	event := events.SecondaryDbLoadedEvent{
+		PreviousSecondaryDatabaseDatetime: time.Date(2023, 4, 10, 4, 0, 0, 0, time.UTC),
+		CurrentSecondaryDatabaseDatetime:  time.Date(2023, 4, 11, 4, 0, 0, 0, time.UTC),
+		Year:                              2022,
+	}
+	payload, _ := json.Marshal(event)
+	message := kafka.Message{
+		Key:     []byte(events.SecondaryDbLoadedEventName),
+		Value:   payload,
+		Headers: []kafka.Header{{Key: "traceparent", Value: []byte(incomingTraceparent)}},
+	}
+
+	writer := mocks.NewWriterInterface(t)
+	writer.On("WriteMessages", matchContext, mock.Anything).Return(nil).Once()
+
+	reader := mocks.NewReaderInterface(t)
+	reader.On("FetchMessage", matchContext).Return(message, nil).Once()
+	reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError)
+	reader.On("CommitMessages", matchContext, message).Return(nil).Once()
+
+	importer := NewMockImporterInterface(t)
+	importer.On("beginReadTransaction").Return(nil)
+	importer.On("importLessonTypes", matchContext).Return([]events.LessonType{}, nil)
+	importer.On("endReadTransaction").Return(nil)
+	importer.On("execute", matchContext, event.PreviousSecondaryDatabaseDatetime, event.CurrentSecondaryDatabaseDatetime, event.Year).
+		Return(ImportSummary{}, nil).Run(func(args mock.Arguments) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace.SpanContextFromContext(args.Get(0).(context.Context)).TraceID().String())
+	})
+
+	eventLoop := EventLoop{
+		out:          &out,
+		metaEventbus: &MetaEventbus{writer: writer},
+		reader:       reader,
+		importer:     importer,
+	}
+
+	err := eventLoop.execute(context.Background())
+	assert.Equal(t, breakLoopError, err)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	sendSpan, processSpan := spans[0], spans[1]
+
+	assert.Equal(t, "send "+events.SecondaryDbLessonProcessedEventName, sendSpan.Name())
+	assert.Equal(t, processSpan.SpanContext().SpanID(), sendSpan.Parent().SpanID())
+
+	assert.Equal(t, "process "+events.SecondaryDbLoadedEventName, processSpan.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", processSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", processSpan.Parent().SpanID().String())
+	assert.True(t, processSpan.Parent().IsRemote())
+
+	processedMessage := writer.Calls[0].Arguments.Get(1).(kafka.Message)
+	outgoingTraceparent := kafkaHeaderCarrier{headers: &processedMessage.Headers}.Get("traceparent")
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+sendSpan.SpanContext().SpanID().String()+"-01", outgoingTraceparent)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const maxWatchdogCheckInterval = time.Minute
+const minWatchdogCheckInterval = 10 * time.Millisecond
+
+// Watchdog notices when no SecondaryDbLoadedEvent was imported for longer than maxQuietPeriod:
+// it publishes LessonsImportStaleEvent once per quiet period and reports readiness as degraded until the next import.
+type Watchdog struct {
+	out            io.Writer
+	metaEventbus   MetaEventbusInterface
+	maxQuietPeriod time.Duration
+	lastImportAt   atomic.Int64
+	degraded       atomic.Bool
+}
+
+func newWatchdog(out io.Writer, metaEventbus MetaEventbusInterface, maxQuietPeriod time.Duration) *Watchdog {
+	watchdog := &Watchdog{
+		out:            out,
+		metaEventbus:   metaEventbus,
+		maxQuietPeriod: maxQuietPeriod,
+	}
+	watchdog.markImported(time.Now())
+
+	return watchdog
+}
+
+func (watchdog *Watchdog) run(done <-chan struct{}) {
+	ticker := time.NewTicker(watchdog.checkInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			watchdog.check(now)
+		}
+	}
+}
+
+// checkInterval checks ten times per quiet period, but keeps a tiny period from spinning the ticker.
+func (watchdog *Watchdog) checkInterval() time.Duration {
+	return max(min(watchdog.maxQuietPeriod/10, maxWatchdogCheckInterval), minWatchdogCheckInterval)
+}
+
+func (watchdog *Watchdog) markImported(at time.Time) {
+	watchdog.lastImportAt.Store(at.UnixNano())
+	secondsSinceLastImport.Set(0)
+
+	if watchdog.degraded.Swap(false) {
+		fmt.Fprintf(watchdog.out, "Lessons import recovered at %s\n", at.Format(dateFormat))
+	}
+}
+
+func (watchdog *Watchdog) check(now time.Time) {
+	lastImportAt := time.Unix(0, watchdog.lastImportAt.Load())
+	quietFor := now.Sub(lastImportAt)
+	secondsSinceLastImport.Set(quietFor.Seconds())
+
+	if quietFor <= watchdog.maxQuietPeriod || watchdog.degraded.Swap(true) {
+		return
+	}
+
+	fmt.Fprintf(
+		watchdog.out, "No secondary DB load imported since %s (max quiet period %s)\n",
+		lastImportAt.Format(dateFormat), watchdog.maxQuietPeriod,
+	)
+	if err := watchdog.metaEventbus.sendLessonsImportStaleEvent(lastImportAt, watchdog.maxQuietPeriod); err != nil {
+		fmt.Fprintf(watchdog.out, "Failed to send %s: %v\n", LessonsImportStaleEventName, err)
+	}
+}
+
+func (watchdog *Watchdog) readiness() error {
+	if watchdog.degraded.Load() {
+		lastImportAt := time.Unix(0, watchdog.lastImportAt.Load())
+		return errors.New("degraded: no secondary DB load imported since " + lastImportAt.Format(dateFormat))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	lastImportAt := time.Date(2023, 9, 2, 4, 0, 0, 0, time.Local)
+
+	t.Run("quiet period not exceeded", func(t *testing.T) {
+		var out bytes.Buffer
+		metaEventbus := NewMockMetaEventbusInterface(t)
+
+		watchdog := newWatchdog(&out, metaEventbus, time.Hour)
+		watchdog.markImported(lastImportAt)
+		watchdog.check(lastImportAt.Add(30 * time.Minute))
+
+		assert.NoError(t, watchdog.readiness())
+		assert.Equal(t, float64(1800), secondsSinceLastImport.Value())
+		metaEventbus.AssertNotCalled(t, "sendLessonsImportStaleEvent")
+	})
+
+	t.Run("quiet period exceeded", func(t *testing.T) {
+		var out bytes.Buffer
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendLessonsImportStaleEvent", lastImportAt, time.Hour).Return(nil).Once()
+
+		watchdog := newWatchdog(&out, metaEventbus, time.Hour)
+		watchdog.markImported(lastImportAt)
+		watchdog.check(lastImportAt.Add(2 * time.Hour))
+		watchdog.check(lastImportAt.Add(3 * time.Hour))
+
+		assert.EqualError(t, watchdog.readiness(), "degraded: no secondary DB load imported since 2023-09-02 04:00:00")
+		assert.Equal(t, float64(10800), secondsSinceLastImport.Value())
+		assert.Contains(t, out.String(), "No secondary DB load imported since 2023-09-02 04:00:00 (max quiet period 1h0m0s)")
+		metaEventbus.AssertNumberOfCalls(t, "sendLessonsImportStaleEvent", 1)
+
+		watchdog.markImported(lastImportAt.Add(4 * time.Hour))
+
+		assert.NoError(t, watchdog.readiness())
+		assert.Equal(t, float64(0), secondsSinceLastImport.Value())
+		assert.Contains(t, out.String(), "Lessons import recovered at 2023-09-02 08:00:00")
+	})
+
+	t.Run("stale event send error", func(t *testing.T) {
+		var out bytes.Buffer
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendLessonsImportStaleEvent", lastImportAt, time.Hour).Return(errors.New("kafka down")).Once()
+
+		watchdog := newWatchdog(&out, metaEventbus, time.Hour)
+		watchdog.markImported(lastImportAt)
+		watchdog.check(lastImportAt.Add(2 * time.Hour))
+
+		assert.Error(t, watchdog.readiness())
+		assert.Contains(t, out.String(), "Failed to send LessonsImportStaleEvent: kafka down")
+	})
+
+	t.Run("check interval", func(t *testing.T) {
+		assert.Equal(t, maxWatchdogCheckInterval, newWatchdog(io.Discard, nil, 6*time.Hour).checkInterval())
+		assert.Equal(t, 6*time.Second, newWatchdog(io.Discard, nil, time.Minute).checkInterval())
+		assert.Equal(t, minWatchdogCheckInterval, newWatchdog(io.Discard, nil, 5*time.Nanosecond).checkInterval())
+	})
+
+	t.Run("run stops", func(t *testing.T) {
+		var out bytes.Buffer
+		metaEventbus := NewMockMetaEventbusInterface(t)
+		metaEventbus.On("sendLessonsImportStaleEvent", lastImportAt, 10*time.Millisecond).Return(nil).Once()
+
+		watchdog := newWatchdog(&out, metaEventbus, 10*time.Millisecond)
+		watchdog.markImported(lastImportAt)
+
+		done := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			watchdog.run(done)
+		}()
+
+		assert.Eventually(t, func() bool { return watchdog.readiness() != nil }, time.Second, time.Millisecond)
+		close(done)
+		<-finished
+	})
+}
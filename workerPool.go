@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/segmentio/kafka-go"
+	"io"
+	"sync"
+	"time"
+)
+
+const DefaultImportWorkers = 1
+
+// maxQueuedMessagesPerWorker bounds how far the event loop fetches ahead of the workers.
+const maxQueuedMessagesPerWorker = 16
+
+// QueuedMessage is a fetched meta event waiting for its year worker.
+type QueuedMessage struct {
+	message kafka.Message
+	pending *pendingMessage
+	year    int
+}
+
+// YearWorkers processes the messages of one key strictly in fetch order and messages of different keys in parallel,
+// at most limit of them at once. Consecutive SecondaryDbLoadedEvents of one year that wait in a queue are handed
+// to process together, so a backlog is imported in one pass. Once process fails for a key, the later messages
+// of the key are dropped: they must not be handled before the failed one is retried.
+type YearWorkers struct {
+	process func(batch []QueuedMessage) error
+	limit   chan struct{}
+	queued  chan struct{}
+	mutex   sync.Mutex
+	queues  map[int][]QueuedMessage
+	failed  map[int]bool
+	running sync.WaitGroup
+}
+
+func newYearWorkers(limit int, process func(batch []QueuedMessage) error) *YearWorkers {
+	limit = max(limit, 1)
+
+	return &YearWorkers{
+		process: process,
+		limit:   make(chan struct{}, limit),
+		queued:  make(chan struct{}, limit*maxQueuedMessagesPerWorker),
+		queues:  make(map[int][]QueuedMessage),
+		failed:  make(map[int]bool),
+	}
+}
+
+// reserve takes a queue slot before a message is fetched; processing of the message gives it back with release.
+func (workers *YearWorkers) reserve(ctx context.Context) error {
+	select {
+	case workers.queued <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (workers *YearWorkers) release() {
+	<-workers.queued
+}
+
+func (workers *YearWorkers) submit(key int, message QueuedMessage) {
+	workers.mutex.Lock()
+	if workers.failed[key] {
+		workers.mutex.Unlock()
+		workers.release()
+		return
+	}
+	queue, draining := workers.queues[key]
+	workers.queues[key] = append(queue, message)
+	workers.mutex.Unlock()
+
+	if !draining {
+		workers.running.Add(1)
+		go workers.drain(key)
+	}
+}
+
+func (workers *YearWorkers) drain(key int) {
+	defer workers.running.Done()
+
+	for {
+		workers.mutex.Lock()
+		queue := workers.queues[key]
+		if len(queue) == 0 {
+			delete(workers.queues, key)
+			workers.mutex.Unlock()
+			return
+		}
+		size := coalescedSize(queue)
+		batch := queue[:size:size]
+		workers.queues[key] = queue[size:]
+		workers.mutex.Unlock()
+
+		workers.limit <- struct{}{}
+		err := workers.process(batch)
+		<-workers.limit
+
+		if err != nil {
+			workers.drop(key)
+			return
+		}
+	}
+}
+
+// drop marks the key as failed and gives back the queue slots of its waiting messages.
+func (workers *YearWorkers) drop(key int) {
+	workers.mutex.Lock()
+	dropped := workers.queues[key]
+	delete(workers.queues, key)
+	workers.failed[key] = true
+	workers.mutex.Unlock()
+
+	for range dropped {
+		workers.release()
+	}
+}
+
+func (workers *YearWorkers) wait() {
+	workers.running.Wait()
+}
+
+// coalescedSize counts the SecondaryDbLoadedEvents of one year at the head of the queue; any other message goes alone.
+func coalescedSize(queue []QueuedMessage) int {
+	size := 1
+	for size < len(queue) && isLoadedEvent(queue[0]) && isLoadedEvent(queue[size]) && queue[size].year == queue[0].year {
+		size++
+	}
+
+	return size
+}
+
+func isLoadedEvent(queued QueuedMessage) bool {
+	return string(queued.message.Key) == events.SecondaryDbLoadedEventName
+}
+
+type pendingMessage struct {
+	message kafka.Message
+	done    bool
+}
+
+// OrderedCommitter commits messages in fetch order: a finished message waits until every earlier one is finished,
+// so a restart never skips a message that was still in work.
+type OrderedCommitter struct {
+	reader  events.ReaderInterface
+	mutex   sync.Mutex
+	pending []*pendingMessage
+}
+
+func (committer *OrderedCommitter) add(message kafka.Message) *pendingMessage {
+	committer.mutex.Lock()
+	defer committer.mutex.Unlock()
+
+	pending := &pendingMessage{message: message}
+	committer.pending = append(committer.pending, pending)
+
+	return pending
+}
+
+func (committer *OrderedCommitter) finish(pending *pendingMessage) error {
+	committer.mutex.Lock()
+	defer committer.mutex.Unlock()
+
+	pending.done = true
+	for len(committer.pending) > 0 && committer.pending[0].done {
+		if err := committer.reader.CommitMessages(context.Background(), committer.pending[0].message); err != nil {
+			return err
+		}
+		committer.pending = committer.pending[1:]
+	}
+
+	return nil
+}
+
+// reconcileSchedule remembers the last scheduled reconcile per year for the parallel workers.
+type reconcileSchedule struct {
+	mutex sync.Mutex
+	at    map[int]time.Time
+}
+
+func (schedule *reconcileSchedule) due(year int, interval time.Duration, now time.Time) bool {
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	if schedule.at == nil {
+		schedule.at = make(map[int]time.Time)
+	}
+	if now.Sub(schedule.at[year]) < interval {
+		return false
+	}
+	schedule.at[year] = now
+
+	return true
+}
+
+// lockedWriter serializes the log lines of parallel year workers, their importers and year locks.
+type lockedWriter struct {
+	out   io.Writer
+	mutex sync.Mutex
+}
+
+func newLockedWriter(out io.Writer) io.Writer {
+	return &lockedWriter{out: out}
+}
+
+func (writer *lockedWriter) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	return writer.out.Write(p)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/kneu-messenger-pigeon/events/mocks"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestYearWorkers(t *testing.T) {
+	t.Run("order within key, bounded parallelism across keys", func(t *testing.T) {
+		var mutex sync.Mutex
+		var order []int
+		var running, maxRunning atomic.Int32
+		workers := newYearWorkers(2, func(batch []QueuedMessage) error {
+			current := running.Add(1)
+			for {
+				seen := maxRunning.Load()
+				if current <= seen || maxRunning.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			mutex.Lock()
+			for _, queued := range batch {
+				if queued.year == 2022 {
+					order = append(order, int(queued.message.Offset))
+				}
+			}
+			mutex.Unlock()
+			running.Add(-1)
+
+			return nil
+		})
+
+		for i := 0; i < 5; i++ {
+			for _, year := range []int{2022, 2023, 2024} {
+				workers.submit(year, QueuedMessage{message: kafka.Message{Offset: int64(i)}, year: year})
+			}
+		}
+		workers.wait()
+
+		assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+		assert.Equal(t, int32(2), maxRunning.Load())
+	})
+
+	t.Run("coalesce loaded events of one year", func(t *testing.T) {
+		loaded := func(year int) QueuedMessage {
+			return QueuedMessage{message: kafka.Message{Key: []byte(events.SecondaryDbLoadedEventName)}, year: year}
+		}
+		snapshot := QueuedMessage{message: kafka.Message{Key: []byte(LessonsSnapshotRequestedEventName)}, year: 2023}
+
+		assert.Equal(t, 1, coalescedSize([]QueuedMessage{loaded(2023)}))
+		assert.Equal(t, 3, coalescedSize([]QueuedMessage{loaded(2023), loaded(2023), loaded(2023), snapshot, loaded(2023)}))
+		assert.Equal(t, 2, coalescedSize([]QueuedMessage{loaded(2023), loaded(2023), loaded(2022)}))
+		assert.Equal(t, 1, coalescedSize([]QueuedMessage{snapshot, snapshot}))
+	})
+
+	t.Run("failed batch stops its key", func(t *testing.T) {
+		snapshot := func(offset int64, year int) QueuedMessage {
+			return QueuedMessage{message: kafka.Message{Key: []byte(LessonsSnapshotRequestedEventName), Offset: offset}, year: year}
+		}
+
+		var mutex sync.Mutex
+		var processed []int64
+		var workers *YearWorkers
+		workers = newYearWorkers(1, func(batch []QueuedMessage) error {
+			defer workers.release()
+			time.Sleep(5 * time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			processed = append(processed, batch[0].message.Offset)
+			if batch[0].message.Offset == 1 {
+				return errors.New("expected test error")
+			}
+
+			return nil
+		})
+
+		for i, queued := range []QueuedMessage{snapshot(1, 2023), snapshot(2, 2023), snapshot(3, 2024), snapshot(4, 2023)} {
+			assert.NoError(t, workers.reserve(context.Background()))
+			workers.submit(queued.year, queued)
+			if i == 2 {
+				workers.wait()
+			}
+		}
+		workers.wait()
+
+		assert.ElementsMatch(t, []int64{1, 3}, processed)
+		assert.Empty(t, workers.queued)
+	})
+
+	t.Run("reserve bounds queued messages", func(t *testing.T) {
+		workers := newYearWorkers(1, func(batch []QueuedMessage) error { return nil })
+		for i := 0; i < maxQueuedMessagesPerWorker; i++ {
+			assert.NoError(t, workers.reserve(context.Background()))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, workers.reserve(ctx))
+
+		workers.release()
+		assert.NoError(t, workers.reserve(context.Background()))
+	})
+}
+
+func TestOrderedCommitter(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	first := kafka.Message{Offset: 1}
+	second := kafka.Message{Offset: 2}
+	third := kafka.Message{Offset: 3}
+
+	var committed []int64
+	reader := mocks.NewReaderInterface(t)
+	reader.On("CommitMessages", matchContext, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		committed = append(committed, args.Get(1).(kafka.Message).Offset)
+	})
+
+	committer := &OrderedCommitter{reader: reader}
+	firstPending := committer.add(first)
+	secondPending := committer.add(second)
+	thirdPending := committer.add(third)
+
+	assert.NoError(t, committer.finish(thirdPending))
+	assert.NoError(t, committer.finish(secondPending))
+	assert.Empty(t, committed)
+
+	assert.NoError(t, committer.finish(firstPending))
+	assert.Equal(t, []int64{1, 2, 3}, committed)
+}
+
+func TestReconcileSchedule(t *testing.T) {
+	schedule := &reconcileSchedule{}
+	now := time.Now()
+
+	assert.True(t, schedule.due(2022, time.Hour, now))
+	assert.False(t, schedule.due(2022, time.Hour, now.Add(time.Minute)))
+	assert.True(t, schedule.due(2023, time.Hour, now.Add(time.Minute)))
+	assert.True(t, schedule.due(2022, time.Hour, now.Add(time.Hour)))
+}
+
+func TestEventLoopParallelYears(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	breakLoopError := errors.New("breakLoop")
+
+	previousYearEvent := events.SecondaryDbLoadedEvent{
+		PreviousSecondaryDatabaseDatetime: time.Date(2023, 3, 1, 4, 0, 0, 0, time.UTC),
+		CurrentSecondaryDatabaseDatetime:  time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC),
+		Year:                              2022,
+	}
+	currentYearEvent := events.SecondaryDbLoadedEvent{
+		PreviousSecondaryDatabaseDatetime: time.Date(2023, 9, 13, 4, 0, 0, 0, time.UTC),
+		CurrentSecondaryDatabaseDatetime:  time.Date(2023, 9, 14, 4, 0, 0, 0, time.UTC),
+		Year:                              2023,
+	}
+	previousYearPayload, _ := json.Marshal(previousYearEvent)
+	currentYearPayload, _ := json.Marshal(currentYearEvent)
+	previousYearMessage := kafka.Message{Key: []byte(events.SecondaryDbLoadedEventName), Value: previousYearPayload, Offset: 10}
+	currentYearMessage := kafka.Message{Key: []byte(events.SecondaryDbLoadedEventName), Value: currentYearPayload, Offset: 11}
+
+	currentYearProcessed := make(chan struct{})
+
+	metaEventbus := NewMockMetaEventbusInterface(t)
+	metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, previousYearEvent).Return(nil)
+	metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, currentYearEvent).Return(nil).Run(func(args mock.Arguments) {
+		close(currentYearProcessed)
+	})
+
+	var commitMutex sync.Mutex
+	var committed []int64
+	reader := mocks.NewReaderInterface(t)
+	reader.On("FetchMessage", matchContext).Return(previousYearMessage, nil).Once()
+	reader.On("FetchMessage", matchContext).Return(currentYearMessage, nil).Once()
+	reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError)
+	reader.On("CommitMessages", matchContext, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		commitMutex.Lock()
+		committed = append(committed, args.Get(1).(kafka.Message).Offset)
+		commitMutex.Unlock()
+	})
+
+	var importers atomic.Int32
+	eventLoop := EventLoop{
+		out:          newLockedWriter(&bytes.Buffer{}),
+		metaEventbus: metaEventbus,
+		reader:       reader,
+		workers:      2,
+		newImporter: func() ImporterInterface {
+			importers.Add(1)
+			importer := NewMockImporterInterface(t)
+			importer.On("beginReadTransaction").Return(nil)
+			importer.On("importLessonTypes", matchContext).Return([]events.LessonType{}, nil)
+			importer.On("endReadTransaction").Return(nil)
+			importer.On("execute", matchContext, mock.Anything, mock.Anything, mock.Anything).Return(ImportSummary{}, nil).
+				Run(func(args mock.Arguments) {
+					// the previous year catch-up only finishes after the current year went through
+					if args.Int(3) == previousYearEvent.Year {
+						<-currentYearProcessed
+					}
+				})
+
+			return importer
+		},
+	}
+
+	assert.Equal(t, breakLoopError, eventLoop.execute(context.Background()))
+	assert.Equal(t, int32(2), importers.Load())
+	assert.Equal(t, []int64{10, 11}, committed)
+}
+
+func TestEventLoopCoalescesBacklog(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	breakLoopError := errors.New("breakLoop")
+
+	loadedEvent := func(previousDay int, currentDay int) events.SecondaryDbLoadedEvent {
+		return events.SecondaryDbLoadedEvent{
+			PreviousSecondaryDatabaseDatetime: time.Date(2023, 9, previousDay, 4, 0, 0, 0, time.UTC),
+			CurrentSecondaryDatabaseDatetime:  time.Date(2023, 9, currentDay, 4, 0, 0, 0, time.UTC),
+			Year:                              2023,
+		}
+	}
+	loadedMessage := func(event events.SecondaryDbLoadedEvent, offset int64) kafka.Message {
+		payload, _ := json.Marshal(event)
+		return kafka.Message{Key: []byte(events.SecondaryDbLoadedEventName), Value: payload, Offset: offset}
+	}
+	firstEvent := loadedEvent(10, 11)
+	secondEvent := loadedEvent(11, 12)
+	thirdEvent := loadedEvent(12, 13)
+
+	firstStarted := make(chan struct{})
+	backlogFetched := make(chan struct{})
+
+	var processed []events.SecondaryDbLoadedEvent
+	metaEventbus := NewMockMetaEventbusInterface(t)
+	metaEventbus.On("sendSecondaryDbLessonProcessedEventName", matchContext, mock.Anything).Return(nil).
+		Run(func(args mock.Arguments) {
+			processed = append(processed, args.Get(1).(events.SecondaryDbLoadedEvent))
+		})
+
+	var committed []int64
+	reader := mocks.NewReaderInterface(t)
+	reader.On("FetchMessage", matchContext).Return(loadedMessage(firstEvent, 1), nil).Once()
+	reader.On("FetchMessage", matchContext).Return(loadedMessage(secondEvent, 2), nil).Once().Run(func(args mock.Arguments) {
+		<-firstStarted
+	})
+	reader.On("FetchMessage", matchContext).Return(loadedMessage(thirdEvent, 3), nil).Once()
+	reader.On("FetchMessage", matchContext).Return(kafka.Message{}, breakLoopError).Run(func(args mock.Arguments) {
+		close(backlogFetched)
+	})
+	reader.On("CommitMessages", matchContext, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		committed = append(committed, args.Get(1).(kafka.Message).Offset)
+	})
+
+	importer := NewMockImporterInterface(t)
+	importer.On("beginReadTransaction").Return(nil)
+	importer.On("importLessonTypes", matchContext).Return([]events.LessonType{}, nil)
+	importer.On("endReadTransaction").Return(nil)
+	importer.On(
+		"execute", matchContext,
+		firstEvent.PreviousSecondaryDatabaseDatetime, firstEvent.CurrentSecondaryDatabaseDatetime, 2023,
+	).Return(ImportSummary{}, nil).Once().Run(func(args mock.Arguments) {
+		close(firstStarted)
+		<-backlogFetched
+	})
+	importer.On(
+		"execute", matchContext,
+		secondEvent.PreviousSecondaryDatabaseDatetime, thirdEvent.CurrentSecondaryDatabaseDatetime, 2023,
+	).Return(ImportSummary{}, nil).Once()
+
+	out := &bytes.Buffer{}
+	eventLoop := EventLoop{
+		out:          out,
+		metaEventbus: metaEventbus,
+		reader:       reader,
+		importer:     importer,
+	}
+
+	assert.Equal(t, breakLoopError, eventLoop.execute(context.Background()))
+	assert.Equal(t, []events.SecondaryDbLoadedEvent{firstEvent, secondEvent, thirdEvent}, processed)
+	assert.Equal(t, []int64{1, 2, 3}, committed)
+	assert.Contains(t, out.String(), "Coalesce 2 SecondaryDbLoadedEvent of 2023 year into 2023-09-11")
+	importer.AssertNumberOfCalls(t, "execute", 2)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const FileYearLockBackend = "file"
+const DbYearLockBackend = "db"
+
+const DefaultYearLockLease = time.Minute
+const yearLockPollInterval = time.Second
+
+// YearLockHolder is the current owner of a year lock and the end of its lease.
+type YearLockHolder struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// YearLockBackend stores the leases. tryAcquire takes a free, expired or own lease and returns nil,
+// otherwise it returns the holder of the lease.
+type YearLockBackend interface {
+	tryAcquire(year int, owner string, expiresAt time.Time, now time.Time) (*YearLockHolder, error)
+	renew(year int, owner string, expiresAt time.Time) error
+	release(year int, owner string) error
+}
+
+// YearLock keeps two importer instances from importing the same year at once. The lease is renewed while
+// the lock is held, so a crashed holder blocks the year for one lease at most.
+type YearLock struct {
+	out     io.Writer
+	backend YearLockBackend
+	owner   string
+	lease   time.Duration
+	wait    time.Duration
+}
+
+func newYearLock(out io.Writer, backend YearLockBackend, lease time.Duration, wait time.Duration) *YearLock {
+	hostname, _ := os.Hostname()
+
+	return &YearLock{
+		out:     out,
+		backend: backend,
+		owner:   hostname + "/" + strconv.Itoa(os.Getpid()),
+		lease:   lease,
+		wait:    wait,
+	}
+}
+
+// acquire waits up to the configured time for the year lock. The returned ctx is cancelled once the lease
+// can't be renewed, so the run under it fails instead of overlapping another instance; context.Cause tells why.
+// The release func stops renewing and frees the lock.
+func (lock *YearLock) acquire(ctx context.Context, year int) (lockCtx context.Context, release func(), err error) {
+	if lock == nil {
+		return ctx, func() {}, nil
+	}
+
+	deadline := time.Now().Add(lock.wait)
+	var holder *YearLockHolder
+	for {
+		now := time.Now()
+		holder, err = lock.backend.tryAcquire(year, lock.owner, now.Add(lock.lease), now)
+		if err != nil {
+			return nil, nil, errors.New("failed to acquire year lock: " + err.Error())
+		}
+		if holder == nil {
+			break
+		}
+
+		yearLockContentions.Add(1)
+		if !now.Before(deadline) {
+			return nil, nil, fmt.Errorf(
+				"%d year import is locked by %s until %s", year, holder.Owner, holder.ExpiresAt.Format(dateFormat),
+			)
+		}
+		fmt.Fprintf(
+			lock.out, "%d year import is locked by %s until %s, waiting\n",
+			year, holder.Owner, holder.ExpiresAt.Format(dateFormat),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(min(yearLockPollInterval, time.Until(deadline))):
+		}
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		lock.renew(year, done, cancel)
+	}()
+
+	return lockCtx, func() {
+		close(done)
+		<-renewed
+		cancel(nil)
+		if err := lock.backend.release(year, lock.owner); err != nil {
+			fmt.Fprintf(lock.out, "Failed to release %d year lock: %v\n", year, err)
+		}
+	}, nil
+}
+
+// renew extends the lease until done; the first failure cancels the run, as the lease may already be taken over.
+func (lock *YearLock) renew(year int, done <-chan struct{}, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(lock.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := lock.backend.renew(year, lock.owner, time.Now().Add(lock.lease)); err != nil {
+				fmt.Fprintf(lock.out, "Failed to renew %d year lock: %v\n", year, err)
+				cancel(errors.New("lost " + strconv.Itoa(year) + " year lock: " + err.Error()))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// YearLockTable must exist in the secondary DB for YEAR_LOCK=db:
+//
+//	CREATE TABLE LESSONS_IMPORTER_LOCK (LOCK_YEAR INTEGER NOT NULL PRIMARY KEY, OWNER VARCHAR(128) NOT NULL, EXPIRES_AT BIGINT NOT NULL)
+//
+// EXPIRES_AT holds Unix milliseconds, so the lease does not depend on the DB time zone.
+const YearLockTable = "LESSONS_IMPORTER_LOCK"
+
+const takeYearLockQuery = `UPDATE ` + YearLockTable + ` SET OWNER = ?, EXPIRES_AT = ?
+WHERE LOCK_YEAR = ? AND (OWNER = ? OR EXPIRES_AT < ?)`
+
+const insertYearLockQuery = `INSERT INTO ` + YearLockTable + ` (LOCK_YEAR, OWNER, EXPIRES_AT) VALUES (?, ?, ?)`
+
+const selectYearLockQuery = `SELECT OWNER, EXPIRES_AT FROM ` + YearLockTable + ` WHERE LOCK_YEAR = ?`
+
+const renewYearLockQuery = `UPDATE ` + YearLockTable + ` SET EXPIRES_AT = ? WHERE LOCK_YEAR = ? AND OWNER = ?`
+
+const releaseYearLockQuery = `DELETE FROM ` + YearLockTable + ` WHERE LOCK_YEAR = ? AND OWNER = ?`
+
+// DbYearLock keeps the leases in a table of the secondary DB, so instances on different hosts see each other.
+// Each statement runs on its own, outside the read transaction of the import.
+type DbYearLock struct {
+	db      *sql.DB
+	dialect *Dialect
+}
+
+func (backend *DbYearLock) tryAcquire(year int, owner string, expiresAt time.Time, now time.Time) (*YearLockHolder, error) {
+	taken, err := backend.exec(takeYearLockQuery, owner, expiresAt.UnixMilli(), year, owner, now.UnixMilli())
+	if err != nil || taken {
+		return nil, err
+	}
+
+	_, insertErr := backend.exec(insertYearLockQuery, year, owner, expiresAt.UnixMilli())
+	if insertErr == nil {
+		return nil, nil
+	}
+
+	var holder YearLockHolder
+	var holderExpiresAt int64
+	err = backend.db.QueryRow(backend.query(selectYearLockQuery), year).Scan(&holder.Owner, &holderExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, insertErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	holder.ExpiresAt = time.UnixMilli(holderExpiresAt)
+
+	return &holder, nil
+}
+
+func (backend *DbYearLock) renew(year int, owner string, expiresAt time.Time) error {
+	renewed, err := backend.exec(renewYearLockQuery, expiresAt.UnixMilli(), year, owner)
+	if err == nil && !renewed {
+		err = errors.New("lease lost")
+	}
+
+	return err
+}
+
+func (backend *DbYearLock) release(year int, owner string) error {
+	_, err := backend.exec(releaseYearLockQuery, year, owner)
+	return err
+}
+
+func (backend *DbYearLock) exec(query string, args ...any) (bool, error) {
+	result, err := backend.db.Exec(backend.query(query), args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// query numbers the placeholders for dialects that do not accept ?.
+func (backend *DbYearLock) query(query string) string {
+	if !backend.dialect.numberedPlaceholders {
+		return query
+	}
+
+	parts := strings.Split(query, "?")
+	var numbered strings.Builder
+	for i, part := range parts {
+		numbered.WriteString(part)
+		if i < len(parts)-1 {
+			numbered.WriteString("$" + strconv.Itoa(i+1))
+		}
+	}
+
+	return numbered.String()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDbYearLock(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	assert.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	backend := &DbYearLock{db: db, dialect: SqliteDialect}
+	now := time.UnixMilli(time.Now().UnixMilli())
+
+	_, err = backend.tryAcquire(2022, "consumer/1", now.Add(time.Minute), now)
+	assert.ErrorContains(t, err, "no such table: "+YearLockTable)
+
+	_, err = db.Exec(`CREATE TABLE ` + YearLockTable + ` (LOCK_YEAR INTEGER NOT NULL PRIMARY KEY, OWNER VARCHAR(128) NOT NULL, EXPIRES_AT BIGINT NOT NULL)`)
+	assert.NoError(t, err)
+
+	holder, err := backend.tryAcquire(2022, "consumer/1", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+	assert.Nil(t, holder)
+
+	holder, err = backend.tryAcquire(2022, "backfill/2", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+	assert.Equal(t, &YearLockHolder{Owner: "consumer/1", ExpiresAt: now.Add(time.Minute)}, holder)
+
+	holder, err = backend.tryAcquire(2022, "consumer/1", now.Add(2*time.Minute), now)
+	assert.NoError(t, err)
+	assert.Nil(t, holder)
+
+	assert.NoError(t, backend.renew(2022, "consumer/1", now.Add(3*time.Minute)))
+	assert.EqualError(t, backend.renew(2022, "backfill/2", now.Add(3*time.Minute)), "lease lost")
+
+	holder, err = backend.tryAcquire(2022, "backfill/2", now.Add(5*time.Minute), now.Add(4*time.Minute))
+	assert.NoError(t, err)
+	assert.Nil(t, holder)
+
+	assert.NoError(t, backend.release(2022, "consumer/1"))
+	holder, err = backend.tryAcquire(2022, "consumer/1", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "backfill/2", holder.Owner)
+
+	assert.NoError(t, backend.release(2022, "backfill/2"))
+	holder, err = backend.tryAcquire(2022, "consumer/1", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+	assert.Nil(t, holder)
+}
+
+func TestDbYearLockQuery(t *testing.T) {
+	backend := &DbYearLock{dialect: PostgresDialect}
+	assert.Equal(
+		t, "UPDATE "+YearLockTable+" SET EXPIRES_AT = $1 WHERE LOCK_YEAR = $2 AND OWNER = $3",
+		backend.query(renewYearLockQuery),
+	)
+
+	backend = &DbYearLock{dialect: FirebirdDialect}
+	assert.Equal(t, releaseYearLockQuery, backend.query(releaseYearLockQuery))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// FileYearLock keeps each lease in its own file; an flock guards only the read-modify-write of the lease,
+// so the lease itself outlives the process and expires by time. An empty file is a free lock.
+type FileYearLock struct {
+	dir string
+}
+
+func (backend *FileYearLock) path(year int) string {
+	return filepath.Join(backend.dir, "year-"+strconv.Itoa(year)+".lock")
+}
+
+func (backend *FileYearLock) tryAcquire(year int, owner string, expiresAt time.Time, now time.Time) (holder *YearLockHolder, err error) {
+	err = backend.update(year, func(current *YearLockHolder) (*YearLockHolder, error) {
+		if current != nil && current.Owner != owner && current.ExpiresAt.After(now) {
+			holder = current
+			return current, nil
+		}
+
+		return &YearLockHolder{Owner: owner, ExpiresAt: expiresAt}, nil
+	})
+
+	return
+}
+
+func (backend *FileYearLock) renew(year int, owner string, expiresAt time.Time) error {
+	return backend.update(year, func(current *YearLockHolder) (*YearLockHolder, error) {
+		if current == nil || current.Owner != owner {
+			return current, errors.New("lease lost")
+		}
+
+		return &YearLockHolder{Owner: owner, ExpiresAt: expiresAt}, nil
+	})
+}
+
+func (backend *FileYearLock) release(year int, owner string) error {
+	return backend.update(year, func(current *YearLockHolder) (*YearLockHolder, error) {
+		if current == nil || current.Owner != owner {
+			return current, nil
+		}
+
+		return nil, nil
+	})
+}
+
+func (backend *FileYearLock) update(year int, change func(current *YearLockHolder) (*YearLockHolder, error)) error {
+	file, err := os.OpenFile(backend.path(year), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	var current *YearLockHolder
+	if len(content) != 0 {
+		current = &YearLockHolder{}
+		if err = json.Unmarshal(content, current); err != nil {
+			return errors.New("wrong lock file " + backend.path(year) + ": " + err.Error())
+		}
+	}
+
+	next, err := change(current)
+	if err != nil || next == current {
+		return err
+	}
+
+	content = nil
+	if next != nil {
+		if content, err = json.Marshal(next); err != nil {
+			return err
+		}
+	}
+
+	if err = file.Truncate(0); err == nil {
+		_, err = file.WriteAt(content, 0)
+	}
+
+	return err
+}
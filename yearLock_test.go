@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/kneu-messenger-pigeon/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestYearLock(t *testing.T) {
+	t.Run("contended", func(t *testing.T) {
+		var out bytes.Buffer
+		backend := &FileYearLock{dir: t.TempDir()}
+
+		first := newYearLock(&out, backend, time.Minute, 0)
+		second := newYearLock(&out, backend, time.Minute, 0)
+		second.owner = "backfill/42"
+
+		_, release, err := first.acquire(context.Background(), 2022)
+		assert.NoError(t, err)
+
+		contentionsBefore := yearLockContentions.Value()
+		_, _, err = second.acquire(context.Background(), 2022)
+		assert.ErrorContains(t, err, "2022 year import is locked by "+first.owner+" until ")
+		assert.Equal(t, contentionsBefore+1, yearLockContentions.Value())
+
+		_, otherRelease, err := second.acquire(context.Background(), 2023)
+		assert.NoError(t, err)
+		otherRelease()
+
+		release()
+		_, release, err = second.acquire(context.Background(), 2022)
+		assert.NoError(t, err)
+		release()
+	})
+
+	t.Run("wait for release", func(t *testing.T) {
+		var out bytes.Buffer
+		backend := &FileYearLock{dir: t.TempDir()}
+
+		first := newYearLock(&out, backend, time.Minute, 0)
+		second := newYearLock(&out, backend, time.Minute, 5*time.Second)
+		second.owner = "backfill/42"
+
+		_, release, err := first.acquire(context.Background(), 2022)
+		assert.NoError(t, err)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			release()
+		}()
+
+		_, secondRelease, err := second.acquire(context.Background(), 2022)
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "2022 year import is locked by "+first.owner)
+		secondRelease()
+	})
+
+	t.Run("expired lease", func(t *testing.T) {
+		backend := &FileYearLock{dir: t.TempDir()}
+		now := time.Now()
+
+		holder, err := backend.tryAcquire(2022, "crashed/1", now.Add(-time.Second), now.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Nil(t, holder)
+
+		holder, err = backend.tryAcquire(2022, "consumer/2", now.Add(time.Minute), now)
+		assert.NoError(t, err)
+		assert.Nil(t, holder)
+
+		assert.EqualError(t, backend.renew(2022, "crashed/1", now.Add(time.Minute)), "lease lost")
+		assert.NoError(t, backend.renew(2022, "consumer/2", now.Add(2*time.Minute)))
+
+		holder, err = backend.tryAcquire(2022, "crashed/1", now.Add(time.Minute), now)
+		assert.NoError(t, err)
+		assert.Equal(t, "consumer/2", holder.Owner)
+		assert.True(t, now.Add(2*time.Minute).Equal(holder.ExpiresAt))
+	})
+
+	t.Run("renew lease", func(t *testing.T) {
+		backend := &FileYearLock{dir: t.TempDir()}
+		lock := newYearLock(&bytes.Buffer{}, backend, 30*time.Millisecond, 0)
+
+		_, release, err := lock.acquire(context.Background(), 2022)
+		assert.NoError(t, err)
+		time.Sleep(60 * time.Millisecond)
+
+		holder, err := backend.tryAcquire(2022, "backfill/42", time.Now().Add(time.Minute), time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, lock.owner, holder.Owner)
+
+		release()
+		content, err := os.ReadFile(filepath.Join(backend.dir, "year-2022.lock"))
+		assert.NoError(t, err)
+		assert.Empty(t, content)
+	})
+
+	t.Run("lost lease cancels the run", func(t *testing.T) {
+		var out bytes.Buffer
+		backend := &FileYearLock{dir: t.TempDir()}
+		lock := newYearLock(&out, backend, 30*time.Millisecond, 0)
+
+		lockCtx, release, err := lock.acquire(context.Background(), 2022)
+		assert.NoError(t, err)
+		defer release()
+
+		now := time.Now()
+		holder, err := backend.tryAcquire(2022, "backfill/42", now.Add(time.Minute), now.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Nil(t, holder)
+
+		select {
+		case <-lockCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("lock context is not cancelled")
+		}
+		assert.EqualError(t, context.Cause(lockCtx), "lost 2022 year lock: lease lost")
+		assert.Contains(t, out.String(), "Failed to renew 2022 year lock: lease lost\n")
+	})
+
+	t.Run("wrong lock file", func(t *testing.T) {
+		backend := &FileYearLock{dir: t.TempDir()}
+		assert.NoError(t, os.WriteFile(filepath.Join(backend.dir, "year-2022.lock"), []byte("{"), 0644))
+
+		_, _, err := newYearLock(&bytes.Buffer{}, backend, time.Minute, 0).acquire(context.Background(), 2022)
+		assert.ErrorContains(t, err, "failed to acquire year lock: wrong lock file ")
+	})
+
+	t.Run("nil lock", func(t *testing.T) {
+		var lock *YearLock
+		ctx := context.Background()
+		lockCtx, release, err := lock.acquire(ctx, 2022)
+		assert.NoError(t, err)
+		assert.Equal(t, ctx, lockCtx)
+		release()
+	})
+}
+
+func TestEventLoopYearLock(t *testing.T) {
+	matchContext := mock.MatchedBy(func(ctx context.Context) bool { return true })
+	event := events.SecondaryDbLoadedEvent{
+		PreviousSecondaryDatabaseDatetime: time.Date(2023, 3, 13, 4, 0, 0, 0, time.UTC),
+		CurrentSecondaryDatabaseDatetime:  time.Date(2023, 3, 14, 4, 0, 0, 0, time.UTC),
+		Year:                              2022,
+	}
+
+	t.Run("read transaction under the lock", func(t *testing.T) {
+		backend := &FileYearLock{dir: t.TempDir()}
+		lock := newYearLock(&bytes.Buffer{}, backend, time.Minute, 0)
+		lockedByUs := func() bool {
+			holder, err := backend.tryAcquire(2022, "backfill/42", time.Now().Add(time.Minute), time.Now())
+			return err == nil && holder != nil && holder.Owner == lock.owner
+		}
+
+		importer := NewMockImporterInterface(t)
+		importer.On("beginReadTransaction").Return(nil).Once().Run(func(mock.Arguments) {
+			assert.True(t, lockedByUs())
+		})
+		importer.On("importLessonTypes", matchContext).Return([]events.LessonType{}, nil)
+		importer.On("execute", matchContext, event.PreviousSecondaryDatabaseDatetime, event.CurrentSecondaryDatabaseDatetime, 2022).
+			Return(ImportSummary{}, nil)
+		importer.On("endReadTransaction").Return(nil).Once().Run(func(mock.Arguments) {
+			assert.True(t, lockedByUs())
+		})
+		eventLoop := &EventLoop{out: &bytes.Buffer{}, importer: importer, yearLock: lock}
+		_, err := eventLoop.importWindow(context.Background(), event)
+
+		assert.NoError(t, err)
+		assert.False(t, lockedByUs())
+	})
+
+	t.Run("locked year is not read", func(t *testing.T) {
+		backend := &FileYearLock{dir: t.TempDir()}
+		now := time.Now()
+		_, err := backend.tryAcquire(2022, "backfill/42", now.Add(time.Minute), now)
+		assert.NoError(t, err)
+
+		eventLoop := &EventLoop{
+			out:      &bytes.Buffer{},
+			importer: NewMockImporterInterface(t),
+			yearLock: newYearLock(&bytes.Buffer{}, backend, time.Minute, 0),
+		}
+		_, err = eventLoop.importWindow(context.Background(), event)
+
+		assert.ErrorContains(t, err, "2022 year import is locked by backfill/42 until ")
+	})
+}